@@ -0,0 +1,166 @@
+package ormlite
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+
+	"github.com/pkg/errors"
+)
+
+// RowAssembler picks model to scan each row into, e.g. by discriminator column
+// of single table inheritance. Rows are read from the table of the assembler
+// itself, Assemble receives values of all its columns keyed by column names.
+type RowAssembler interface {
+	Model
+	Assemble(values map[string]interface{}) (Model, error)
+}
+
+// Queries rows of the assembler table passing models assembled from them to fn
+// with column info containing values of has one relations keys
+func queryAssembledRows(ctx context.Context, db Executor, opts *Options, count *int, fn func(reflect.Value, []columnInfo) error) error {
+	assembler := opts.Assembler
+	mInfo, err := getModelInfo(assembler)
+	if err != nil {
+		return err
+	}
+	if err := overrideTable(mInfo, opts); err != nil {
+		return err
+	}
+	if mInfo.table == "" {
+		return ErrNoTable
+	}
+	colInfo, err := getColumnInfo(reflect.TypeOf(assembler).Elem())
+	if err != nil {
+		return errors.Wrapf(err, "failed to get column info for type: %T", assembler)
+	}
+
+	if opts, err = prepareQuery(mInfo, colInfo, opts); err != nil {
+		return err
+	}
+
+	rows, err := queryWithOptions(ctx, db, mInfo.table, []string{mInfo.table + ".*"}, opts, count)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var (
+			values = make([]interface{}, len(columns))
+			ptrs   = make([]interface{}, len(columns))
+		)
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return err
+		}
+		row := make(map[string]interface{}, len(columns))
+		for i, c := range columns {
+			row[c] = values[i]
+		}
+
+		m, err := assembler.Assemble(row)
+		if err != nil {
+			return err
+		}
+		entry, entryColInfo, err := assembleModel(m, row)
+		if err != nil {
+			return err
+		}
+		if err := fn(entry, entryColInfo); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Sets column values to the fields of given model returning it with column
+// info containing values of has one relations keys
+func assembleModel(m Model, row map[string]interface{}) (reflect.Value, []columnInfo, error) {
+	value := reflect.ValueOf(m)
+	if value.Kind() != reflect.Ptr || value.IsNil() || value.Elem().Kind() != reflect.Struct {
+		return value, nil, errors.Errorf("assembler returned %T, expected pointer to struct", m)
+	}
+	colInfo, err := getColumnInfo(value.Elem().Type())
+	if err != nil {
+		return value, nil, errors.Wrapf(err, "failed to get column info for type: %T", m)
+	}
+
+	for k, ci := range colInfo {
+		v, ok := row[ci.Name]
+		if !ok {
+			continue
+		}
+		switch ci.RelationInfo.Type {
+		case hasOne:
+			colInfo[k].RelationInfo.RefPkValue = v
+		case noRelation:
+			if err := assignValue(scanDest(value.Elem().Field(ci.Index)), v); err != nil {
+				return value, nil, errors.Wrapf(err, "can't assign column %s", ci.Name)
+			}
+		}
+	}
+	return value, colInfo, nil
+}
+
+// Assigns raw column value to the scan destination
+func assignValue(dest, src interface{}) error {
+	if scanner, ok := dest.(sql.Scanner); ok {
+		return scanner.Scan(src)
+	}
+	dv := reflect.ValueOf(dest).Elem()
+	if src == nil {
+		dv.Set(reflect.Zero(dv.Type()))
+		return nil
+	}
+	sv := reflect.ValueOf(src)
+	switch {
+	case sv.Type().AssignableTo(dv.Type()):
+		dv.Set(sv)
+	case sv.Type().ConvertibleTo(dv.Type()):
+		dv.Set(sv.Convert(dv.Type()))
+	case dv.Kind() == reflect.Ptr:
+		pv := reflect.New(dv.Type().Elem())
+		if err := assignValue(scanDest(pv.Elem()), src); err != nil {
+			return err
+		}
+		dv.Set(pv)
+	default:
+		return errors.Errorf("can't assign %T to %v", src, dv.Type())
+	}
+	return nil
+}
+
+// Loads relations of assembled models grouping them by type
+func loadAssembledRelations(ctx context.Context, db Executor, opts *Options, entries []reflect.Value, colInfoPerEntry [][]columnInfo) error {
+	var (
+		types  []reflect.Type
+		groups = make(map[reflect.Type][]int)
+	)
+	for i, e := range entries {
+		if _, ok := groups[e.Type()]; !ok {
+			types = append(types, e.Type())
+		}
+		groups[e.Type()] = append(groups[e.Type()], i)
+	}
+	for _, t := range types {
+		var (
+			slice   = reflect.MakeSlice(reflect.SliceOf(t), 0, len(groups[t]))
+			colInfo = make([][]columnInfo, 0, len(groups[t]))
+		)
+		for _, i := range groups[t] {
+			slice = reflect.Append(slice, entries[i])
+			colInfo = append(colInfo, colInfoPerEntry[i])
+		}
+		if err := loadRelationsForSlice(ctx, db, opts, slice, colInfo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package ormlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type animalOwner struct {
+	ID   int64 `ormlite:"primary"`
+	Name string
+}
+
+func (*animalOwner) Table() string { return "owners" }
+
+type dog struct {
+	ID    int64 `ormlite:"primary"`
+	Name  string
+	Legs  int
+	Owner *animalOwner `ormlite:"has_one,col=owner_id"`
+}
+
+func (*dog) Table() string { return "animals" }
+
+type bird struct {
+	ID    int64 `ormlite:"primary"`
+	Name  string
+	Wings *int
+}
+
+func (*bird) Table() string { return "animals" }
+
+type animal struct {
+	ID   int64 `ormlite:"primary"`
+	Kind string
+	Name string
+}
+
+func (*animal) Table() string { return "animals" }
+
+func (*animal) Assemble(values map[string]interface{}) (Model, error) {
+	switch kind := fmt.Sprintf("%s", values["kind"]); kind {
+	case "dog":
+		return &dog{}, nil
+	case "bird":
+		return &bird{}, nil
+	default:
+		return nil, fmt.Errorf("unknown kind: %s", kind)
+	}
+}
+
+func TestRowAssembler(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(`
+		create table owners(id integer primary key, name text);
+		create table animals(id integer primary key, kind text, name text, legs int, wings int, owner_id int);
+		insert into owners(name) values ('john');
+		insert into animals(kind, name, legs, wings, owner_id) values
+			('dog', 'rex', 4, null, 1), ('bird', 'tweety', null, 2, null), ('dog', 'spot', 3, null, null);
+	`)
+	require.NoError(t, err)
+
+	var (
+		mm    []Model
+		count int
+	)
+	require.NoError(t, QuerySliceCount(db, &Options{Assembler: &animal{}, RelationDepth: 1}, &mm, &count))
+	assert.Equal(t, 3, count)
+	require.Len(t, mm, 3)
+	if rex, ok := mm[0].(*dog); assert.True(t, ok, "expected dog, got %T", mm[0]) {
+		assert.Equal(t, "rex", rex.Name)
+		assert.Equal(t, 4, rex.Legs)
+		if assert.NotNil(t, rex.Owner) {
+			assert.Equal(t, "john", rex.Owner.Name)
+		}
+	}
+	if tweety, ok := mm[1].(*bird); assert.True(t, ok, "expected bird, got %T", mm[1]) {
+		assert.Equal(t, "tweety", tweety.Name)
+		if assert.NotNil(t, tweety.Wings) {
+			assert.Equal(t, 2, *tweety.Wings)
+		}
+	}
+	if spot, ok := mm[2].(*dog); assert.True(t, ok, "expected dog, got %T", mm[2]) {
+		assert.Nil(t, spot.Owner)
+	}
+
+	var names []string
+	require.NoError(t, QueryFunc(context.Background(), db, &Options{Assembler: &animal{}, Where: Where{"legs": Greater(0)}}, nil,
+		func(m Model) error {
+			d, ok := m.(*dog)
+			if !ok {
+				return fmt.Errorf("unexpected model %T", m)
+			}
+			names = append(names, d.Name)
+			return nil
+		}))
+	assert.Equal(t, []string{"rex", "spot"}, names)
+
+	var dogs []*dog
+	assert.Error(t, QuerySlice(db, &Options{Assembler: &animal{}}, &dogs), "birds can't be stored as dogs")
+}
@@ -0,0 +1,106 @@
+package ormlite
+
+import (
+	"context"
+	"database/sql"
+)
+
+// DB routes read queries to the reader handle (e.g. connected to a replica)
+// and write queries to the writer one
+type DB struct {
+	reader Executor
+	writer Executor
+}
+
+// NewDB creates DB using given handles, if one of them is nil the other one
+// is used for both reads and writes
+func NewDB(writer, reader Executor) *DB {
+	if reader == nil {
+		reader = writer
+	}
+	if writer == nil {
+		writer = reader
+	}
+	return &DB{reader: reader, writer: writer}
+}
+
+// Reader returns handle used for read queries
+func (d *DB) Reader() Executor { return d.reader }
+
+// Writer returns handle used for write queries
+func (d *DB) Writer() Executor { return d.writer }
+
+// QueryStruct does the same as QueryStruct using reader handle
+func (d *DB) QueryStruct(opts *Options, out Model) error {
+	return QueryStruct(d.reader, opts, out)
+}
+
+// QueryStructContext does the same as QueryStructContext using reader handle
+func (d *DB) QueryStructContext(ctx context.Context, opts *Options, out Model) error {
+	return QueryStructContext(ctx, d.reader, opts, out)
+}
+
+// QuerySlice does the same as QuerySlice using reader handle
+func (d *DB) QuerySlice(opts *Options, out interface{}) error {
+	return QuerySlice(d.reader, opts, out)
+}
+
+// QuerySliceContext does the same as QuerySliceContext using reader handle
+func (d *DB) QuerySliceContext(ctx context.Context, opts *Options, out interface{}) error {
+	return QuerySliceContext(ctx, d.reader, opts, out)
+}
+
+// QuerySliceCount does the same as QuerySliceCount using reader handle
+func (d *DB) QuerySliceCount(opts *Options, out interface{}, count *int) error {
+	return QuerySliceCount(d.reader, opts, out, count)
+}
+
+// QuerySliceCountContext does the same as QuerySliceCountContext using reader handle
+func (d *DB) QuerySliceCountContext(ctx context.Context, opts *Options, out interface{}, count *int) error {
+	return QuerySliceCountContext(ctx, d.reader, opts, out, count)
+}
+
+// Count does the same as Count using reader handle
+func (d *DB) Count(m Model, opts *Options) (int64, error) {
+	return Count(d.reader, m, opts)
+}
+
+// CountContext does the same as CountContext using reader handle
+func (d *DB) CountContext(ctx context.Context, m Model, opts *Options) (int64, error) {
+	return CountContext(ctx, d.reader, m, opts)
+}
+
+// Insert does the same as Insert using writer handle
+func (d *DB) Insert(m Model) error {
+	return Insert(d.writer, m)
+}
+
+// InsertContext does the same as InsertContext using writer handle
+func (d *DB) InsertContext(ctx context.Context, m Model) error {
+	return InsertContext(ctx, d.writer, m)
+}
+
+// Upsert does the same as Upsert using writer handle
+func (d *DB) Upsert(m Model) error {
+	return Upsert(d.writer, m)
+}
+
+// UpsertContext does the same as UpsertContext using writer handle
+func (d *DB) UpsertContext(ctx context.Context, m Model) error {
+	return UpsertContext(ctx, d.writer, m)
+}
+
+// Update does the same as Update using writer handle
+func (d *DB) Update(m Model) error {
+	return Update(d.writer, m)
+}
+
+// UpdateContext does the same as UpdateContext using writer handle
+func (d *DB) UpdateContext(ctx context.Context, m Model, deep bool) error {
+	return UpdateContext(ctx, d.writer, m, deep)
+}
+
+// Delete does the same as Delete using writer handle
+func (d *DB) Delete(m Model) (sql.Result, error) {
+	return Delete(d.writer, m)
+}
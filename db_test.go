@@ -0,0 +1,44 @@
+package ormlite
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadWriteDB(t *testing.T) {
+	var handles []*sql.DB
+	for _, name := range []string{"writer", "reader"} {
+		c, err := sql.Open("sqlite3", ":memory:")
+		require.NoError(t, err)
+		_, err = c.Exec(`create table simple_model(id integer primary key, not_tagged_field text, tagged_field text)`)
+		require.NoError(t, err)
+		_, err = c.Exec(`insert into simple_model(not_tagged_field, tagged_field) values (?, '')`, name)
+		require.NoError(t, err)
+		handles = append(handles, c)
+	}
+	writer, reader := handles[0], handles[1]
+	db := NewDB(writer, reader)
+
+	require.NoError(t, db.Insert(&simpleModel{NotTaggedField: "written"}))
+
+	var m simpleModel
+	require.NoError(t, QueryStruct(writer, &Options{Where: Where{"id": 2}}, &m))
+	assert.Equal(t, "written", m.NotTaggedField)
+
+	count, err := db.Count(&simpleModel{}, nil)
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, count, "write shouldn't reach reader")
+
+	var mm []*simpleModel
+	require.NoError(t, db.QuerySlice(nil, &mm))
+	if assert.Len(t, mm, 1) {
+		assert.Equal(t, "reader", mm[0].NotTaggedField)
+	}
+
+	single := NewDB(writer, nil)
+	assert.Equal(t, writer, single.Reader())
+	assert.Equal(t, writer, single.Writer())
+}
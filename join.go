@@ -0,0 +1,146 @@
+package ormlite
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Has one relation loaded by the join of related table to the model query
+type joinedRelation struct {
+	// index of relation in column info of the model
+	info int
+	// struct type of related model
+	model reflect.Type
+	// indexes of scanned fields of related model and aliases of their columns
+	fields  []int
+	columns []string
+}
+
+// Adds left joins of has one related tables to the query described by options
+// if JoinRelations is set and returns relations with columns to select. Only
+// related models without relations and expressions of their own are joined,
+// others are loaded with separate queries as usual.
+func buildJoinedRelations(mInfo *modelInfo, colInfo []columnInfo, opts *Options) ([]joinedRelation, []string, error) {
+	if opts == nil || !opts.JoinRelations || opts.RelationDepth == 0 || opts.Assembler != nil {
+		return nil, nil, nil
+	}
+	var (
+		joined  []joinedRelation
+		columns []string
+	)
+	for i, ci := range colInfo {
+		if ci.RelationInfo.Type != hasOne {
+			continue
+		}
+		relType := ci.RelationInfo.RelatedType.Elem()
+		relColInfo, err := getColumnInfo(relType)
+		if err != nil {
+			return nil, nil, err
+		}
+		relInfo, err := getModelInfo(reflect.New(relType).Interface())
+		if err != nil {
+			return nil, nil, err
+		}
+		if relInfo.table == "" || !isJoinable(relColInfo) {
+			continue
+		}
+
+		var (
+			alias      = "ormlite_" + ci.Name
+			pk         string
+			relColumns = opts.RelationColumns[ci.RelationInfo.Column]
+			jr         = joinedRelation{info: i, model: relType}
+		)
+		for _, rc := range relColInfo {
+			if rc.Primary {
+				if pk != "" {
+					pk = ""
+					break // compound key
+				}
+				pk = rc.Name
+			}
+		}
+		if pk == "" {
+			continue
+		}
+		for _, rc := range relColInfo {
+			if _, ok := relColumns[rc.Name]; relColumns != nil && !ok && !rc.Primary {
+				continue
+			}
+			column := fmt.Sprintf("%s__%s", alias, rc.Name)
+			columns = append(columns, fmt.Sprintf("%s.%s as %s", alias, rc.Name, column))
+			jr.fields = append(jr.fields, rc.Index)
+			jr.columns = append(jr.columns, column)
+		}
+
+		join := fmt.Sprintf(" left join %s as %s on %s.%s = %s.%s", relInfo.table, alias, alias, pk, mInfo.table, ci.Name)
+		for _, f := range relInfo.fields {
+			if isSoftDeleteField(f) && !opts.WithTrashed {
+				join += fmt.Sprintf(" and %s.%s is null", alias, f.column)
+			}
+		}
+		opts.joins = append(opts.joins, join)
+		joined = append(joined, jr)
+	}
+	if len(joined) != 0 {
+		qualifyModelColumns(mInfo, opts)
+	}
+	return joined, columns, nil
+}
+
+// Makes columns of the model referenced by conditions, orderings and groupings
+// of options qualified with its table, so they aren't ambiguous with columns
+// of joined tables
+func qualifyModelColumns(mInfo *modelInfo, opts *Options) {
+	opts.qualified = map[string]string{"rowid": mInfo.table + ".rowid"}
+	for _, f := range mInfo.fields {
+		if isOmittedField(f) || isExpressionField(f) || isReferenceField(f) && !isHasOne(f) {
+			continue
+		}
+		opts.qualified[f.column] = fmt.Sprintf("%s.%s", mInfo.table, f.column)
+	}
+	if opts.softDelete != "" && !strings.Contains(opts.softDelete, ".") {
+		opts.softDelete = fmt.Sprintf("%s.%s", mInfo.table, opts.softDelete)
+	}
+}
+
+// Checks if model can be scanned from joined columns, i.e. it doesn't have
+// relations and expressions
+func isJoinable(colInfo []columnInfo) bool {
+	for _, ci := range colInfo {
+		if ci.RelationInfo.Type != noRelation || ci.Expression {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns scan destinations of joined related models, models are set to the
+// fields of the entry by setJoinedRelations
+func joinedScanDest(joined []joinedRelation) ([]reflect.Value, []interface{}) {
+	var (
+		related = make([]reflect.Value, len(joined))
+		dests   []interface{}
+	)
+	for i, jr := range joined {
+		related[i] = reflect.New(jr.model)
+		for _, idx := range jr.fields {
+			dests = append(dests, scanDest(related[i].Elem().Field(idx)))
+		}
+	}
+	return related, dests
+}
+
+// Sets scanned related models to the fields of entry having relation keys,
+// keys are cleared in entry column info, so relations aren't loaded again
+func setJoinedRelations(entry reflect.Value, entryColInfo []columnInfo, joined []joinedRelation, related []reflect.Value) {
+	for i, jr := range joined {
+		ri := &entryColInfo[jr.info].RelationInfo
+		if ri.RefPkValue == nil {
+			continue
+		}
+		entry.Field(entryColInfo[jr.info].Index).Set(related[i])
+		ri.RefPkValue = nil
+	}
+}
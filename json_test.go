@@ -0,0 +1,63 @@
+//go:build sqlite_json
+
+package ormlite
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Tests of json paths require sqlite built with json1 extension, so they
+// are run with sqlite_json build tag: go test -tags sqlite_json ./...
+
+type jsonDocument struct {
+	ID   int64 `ormlite:"primary"`
+	Data map[string]interface{}
+}
+
+func (*jsonDocument) Table() string { return "json_document" }
+
+func TestJSONPath(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec("create table json_document(id integer primary key, data text)")
+	require.NoError(t, err)
+
+	for _, data := range []map[string]interface{}{
+		{"role": "admin", "address": map[string]interface{}{"city": "Berlin"}, "tags": []string{"a", "b"}},
+		{"role": "user", "address": map[string]interface{}{"city": "Paris"}, "tags": []string{"b"}},
+		{"role": "user", "address": map[string]interface{}{"city": "Berlin"}},
+	} {
+		require.NoError(t, Insert(db, &jsonDocument{Data: data}))
+	}
+
+	ids := func(where Where) (ids []int64) {
+		var dd []*jsonDocument
+		require.NoError(t, QuerySlice(db, &Options{Where: where, OrderBy: &OrderBy{Field: "id"}, StrictColumns: true}, &dd))
+		for _, d := range dd {
+			ids = append(ids, d.ID)
+		}
+		return ids
+	}
+	assert.Equal(t, []int64{1}, ids(Where{JSONPath("data", "$.role"): StrictString("admin")}))
+	assert.Equal(t, []int64{3}, ids(Where{
+		JSONPath("data", "$.role"):         StrictString("user"),
+		JSONPath("data", "$.address.city"): StrictString("Berlin"),
+	}))
+	assert.Equal(t, []int64{2, 3}, ids(Where{JSONPath("data", "$.address.city"): []string{"Paris", "Berlin"}, "id": Greater(1)}))
+	assert.Equal(t, []int64{2}, ids(Where{JSONPath("data", "$.tags[0]"): StrictString("b")}))
+
+	count, err := Count(db, &jsonDocument{}, &Options{Where: Where{JSONPath("data", "$.role"): StrictString("user")}})
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, count)
+
+	var dd []*jsonDocument
+	assert.Error(t, QuerySlice(db, &Options{Where: Where{JSONPath("data", "$.role') or ('1"): "x"}}, &dd))
+	assert.Error(t, QuerySlice(db, &Options{Where: Where{JSONPath("data", "role"): "x"}}, &dd))
+	assert.Error(t, QuerySlice(db, &Options{Where: Where{JSONPath("payload", "$.role"): "x"}}, &dd))
+}
@@ -3,11 +3,15 @@ package ormlite
 import (
 	"database/sql"
 	"database/sql/driver"
+	"encoding/json"
 	"github.com/iancoleman/strcase"
 	"github.com/pkg/errors"
 	"github.com/spf13/cast"
 	"reflect"
+	"regexp"
 	"strings"
+	"sync"
+	"time"
 )
 
 type IModel interface {
@@ -20,6 +24,21 @@ type Expression interface {
 	driver.Valuer
 }
 
+// ArgExpression is an expression having arguments bound to the placeholders
+// of its column sql, e.g. `distance(lat, lon, ?, ?) as distance`
+type ArgExpression interface {
+	Expression
+	Args() []interface{}
+}
+
+const (
+	// TimeUnix is a time storage mode keeping time as unix epoch seconds
+	TimeUnix = "unix"
+	// TimeRFC3339 is a time storage mode keeping time as RFC3339 text in UTC
+	// with nanoseconds
+	TimeRFC3339 = "rfc3339"
+)
+
 type fieldType int
 
 const (
@@ -29,6 +48,12 @@ const (
 	pkField
 	uniqueField
 	expField
+	defaultField
+	notNullField
+	softDeleteField
+	autoincrementField
+	readonlyField
+	writeonlyField
 )
 
 func isUniqueField(field modelField) bool {
@@ -39,12 +64,36 @@ func isPkField(field modelField) bool {
 	return field.Type&pkField == pkField
 }
 
+// Checks if field is selected but never written, e.g. generated column
+func isReadonlyField(field modelField) bool {
+	return field.Type&readonlyField == readonlyField
+}
+
+// Checks if field is written but never selected
+func isWriteonlyField(field modelField) bool {
+	return field.Type&writeonlyField == writeonlyField
+}
+
+func isAutoincrementField(field modelField) bool {
+	return field.Type&autoincrementField == autoincrementField
+}
+
 func isReferenceField(field modelField) bool {
 	return field.Type&referenceField == referenceField
 }
 
 func isZeroField(field reflect.Value) bool {
-	return field.Interface() == reflect.Zero(field.Type()).Interface()
+	// values of some types (e.g. slices stored as JSON or structs containing
+	// them) can't be compared, so zero value isn't compared with ==
+	if field.IsZero() {
+		return true
+	}
+	// valuers like sql.NullString are zero when they represent NULL
+	if v, ok := field.Interface().(driver.Valuer); ok {
+		value, err := v.Value()
+		return err == nil && value == nil
+	}
+	return false
 }
 
 func isOmittedField(field modelField) bool {
@@ -55,6 +104,18 @@ func isExpressionField(field modelField) bool {
 	return field.Type&expField == expField
 }
 
+func hasDefault(field modelField) bool {
+	return field.Type&defaultField == defaultField
+}
+
+func isNotNullField(field modelField) bool {
+	return field.Type&notNullField == notNullField
+}
+
+func isSoftDeleteField(field modelField) bool {
+	return field.Type&softDeleteField == softDeleteField
+}
+
 func isHasOne(field modelField) bool {
 	return field.reference.Type == "has_one"
 }
@@ -73,7 +134,10 @@ type fieldReference struct {
 	table     string
 	condition string
 	column    string
-	view      bool // flag that related data comes from view, so no sync is required
+	ref       string // column of many to many mapping table referring related model
+	view      bool   // flag that related data comes from view, so no sync is required
+	noCreate  bool   // flag that unsaved has one related model is not inserted
+	ignore    bool   // flag that many to many edges are inserted with `or ignore`
 }
 
 type modelField struct {
@@ -82,6 +146,17 @@ type modelField struct {
 	unique    bool
 	reference fieldReference
 	value     reflect.Value
+	// name of unique constraint group the field belongs to, fields tagged
+	// with bare unique setting form a single unnamed group
+	uniqueGroup string
+	// how time values are stored in the column, see TimeUnix and TimeRFC3339
+	timeStorage string
+	// literal to use instead of zero value on insert, if it's empty column
+	// is omitted to let database apply it's own default
+	defaultValue string
+	// expression assigned to the column when upsert conflicts, e.g.
+	// "count + excluded.count", the incoming value is used if it's empty
+	onConflict string
 }
 
 type modelInfo struct {
@@ -92,6 +167,9 @@ type modelInfo struct {
 
 // Check if given interface is a Model or slice of Models
 func getModelValue(o interface{}) (reflect.Value, error) {
+	if tm, ok := o.(*tableModel); ok {
+		return getModelValue(tm.Model)
+	}
 	value, ok := o.(reflect.Value)
 	if !ok {
 		value = reflect.ValueOf(o)
@@ -141,23 +219,38 @@ func getFieldInfo(mValue reflect.Value, fIndex int) (modelField, error) {
 	mField.reference.rType = field.Type
 	// parse references
 	switch {
+	case lookForSetting(tag, "concat") != "" &&
+		(lookForSetting(tag, "many_to_many") != "" || lookForSetting(tag, "has_many") != ""):
+		// keys of related models are read only, so nothing is synced
+		mField.reference.Type = "concat"
+		mField.Type += referenceField
 	case lookForSetting(tag, "many_to_many") != "":
 		mField.reference.Type = "many_to_many"
 		mField.reference.table = lookForSetting(tag, "table")
 		mField.reference.condition = lookForSettingWithSep(tag, "condition", ":")
+		mField.reference.ref = lookForSetting(tag, "ref")
 		mField.Type += referenceField
 		if lookForSetting(tag, "view") != "" {
 			mField.reference.view = true
 		}
+		if lookForSetting(tag, "ignore") != "" {
+			mField.reference.ignore = true
+		}
 	case lookForSetting(tag, "has_many") != "":
 		mField.reference.Type = "has_many"
 		mField.Type += referenceField
 	case lookForSetting(tag, "has_one") != "":
 		mField.reference.Type = "has_one"
 		mField.Type += referenceField
+		if lookForSetting(tag, "nocreate") != "" {
+			mField.reference.noCreate = true
+		}
 	case tag == "-":
 		mField.Type += omittedField
 	default:
+		if !isSupportedFieldType(field.Type) {
+			return mField, unsupportedFieldError(mValue.Type(), field)
+		}
 		mField.Type += regularField
 		if _, ok := mField.value.Interface().(Expression); ok {
 			mField.Type += expField
@@ -166,14 +259,248 @@ func getFieldInfo(mValue reflect.Value, fIndex int) (modelField, error) {
 	if lookForSetting(tag, "primary") != "" {
 		mField.reference.column = lookForSetting(tag, "ref")
 		mField.Type += pkField
+		if lookForSetting(tag, "autoincrement") != "" {
+			if !isIntKind(field.Type.Kind()) {
+				return mField, errors.Errorf("autoincrement field %s must be integer", field.Name)
+			}
+			mField.Type += autoincrementField
+		}
 	}
-	if lookForSetting(tag, "unique") != "" {
+	if group := lookForSetting(tag, "unique"); group != "" {
 		mField.Type += uniqueField
+		if group != "unique" {
+			mField.uniqueGroup = group
+		}
+	}
+	if storage := lookForSetting(tag, "time"); storage != "" && storage != "time" {
+		if storage != TimeUnix && storage != TimeRFC3339 {
+			return mField, errors.Errorf("field %s has unknown time storage %s", field.Name, storage)
+		}
+		mField.timeStorage = storage
+	}
+	if lookForSetting(tag, "notnull") != "" {
+		mField.Type += notNullField
+	}
+	if lookForSetting(tag, "softdelete") != "" {
+		mField.Type += softDeleteField
+	}
+	readonly, writeonly := lookForSetting(tag, "readonly") != "", lookForSetting(tag, "writeonly") != ""
+	if readonly && writeonly {
+		return mField, errors.Errorf("field %s can't be both readonly and writeonly", field.Name)
+	}
+	if readonly {
+		mField.Type += readonlyField
+	}
+	if writeonly {
+		mField.Type += writeonlyField
+	}
+	if expr := lookForExprSetting(tag, "on_conflict"); expr != "" {
+		mField.onConflict = expr
+	}
+	if def := lookForSetting(tag, "default"); def != "" {
+		if def == "default" && strings.Contains(","+tag+",", ",default=default,") {
+			// literal can't be told apart from the bare setting
+			return mField, errors.Errorf("default value of field %s can't be the word default", field.Name)
+		}
+		mField.Type += defaultField
+		if def != "default" {
+			mField.defaultValue = def
+		}
 	}
 
 	return mField, nil
 }
 
+var (
+	scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+	valuerType  = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+	timeType    = reflect.TypeOf(time.Time{})
+)
+
+// Checks if values of the type can be stored in a column
+func isSupportedFieldType(t reflect.Type) bool {
+	if scanConverter(t) != nil {
+		return true
+	}
+	if t.Implements(scannerType) || reflect.PtrTo(t).Implements(scannerType) || t.Implements(valuerType) || t == timeType {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Bool, reflect.String, reflect.Interface,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	case reflect.Slice, reflect.Map:
+		return true
+	case reflect.Ptr:
+		return !isJSONType(t.Elem()) && isSupportedFieldType(t.Elem())
+	}
+	return false
+}
+
+// Checks if values of the type are stored as JSON text, it's true for slices
+// (except bytes) and maps that can't be passed to the driver as is
+func isJSONType(t reflect.Type) bool {
+	if t.Implements(scannerType) || reflect.PtrTo(t).Implements(scannerType) || t.Implements(valuerType) {
+		return false
+	}
+	switch t.Kind() {
+	case reflect.Map:
+		return true
+	case reflect.Slice:
+		return t.Elem().Kind() != reflect.Uint8
+	}
+	return false
+}
+
+// jsonField encodes field value to JSON text on write and decodes it on read,
+// nil values are stored as NULL
+type jsonField struct {
+	field reflect.Value
+}
+
+func (j jsonField) Value() (driver.Value, error) {
+	if j.field.IsNil() {
+		return nil, nil
+	}
+	b, err := json.Marshal(j.field.Interface())
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (j jsonField) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		j.field.Set(reflect.Zero(j.field.Type()))
+		return nil
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return errors.Errorf("can't decode %T as JSON", src)
+	}
+	value := reflect.New(j.field.Type())
+	if err := json.Unmarshal(data, value.Interface()); err != nil {
+		return err
+	}
+	j.field.Set(value.Elem())
+	return nil
+}
+
+func unsupportedFieldError(model reflect.Type, field reflect.StructField) error {
+	return errors.Errorf(
+		"field %s.%s has unsupported type %v, mark it with relation tag or skip with \"-\"",
+		model.Name(), field.Name, field.Type)
+}
+
+// ValidateModel checks that all model fields can be handled, so errors caused by
+// wrong field types could be caught on startup instead of failing queries
+func ValidateModel(m Model) error {
+	mInfo, err := getModelInfo(m)
+	if err != nil {
+		return err
+	}
+	_, err = getColumnInfo(mInfo.value.Type())
+	return err
+}
+
+// tableModel is a model stored in the table other than returned by its Table
+type tableModel struct {
+	Model
+	table string
+}
+
+func (m *tableModel) Table() string { return m.table }
+
+// InTable returns model that is written to (or deleted from) given table
+// instead of the one returned by its Table, e.g. to save models of the same
+// type to different partitions. Relations of the model use their own tables.
+func InTable(m Model, table string) Model {
+	return &tableModel{Model: m, table: table}
+}
+
+var tableNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Checks that table name is a plain identifier, since it's rendered into
+// queries as is
+func validateTableName(table string) error {
+	if !tableNamePattern.MatchString(table) {
+		return errors.Errorf("invalid table name: %q", table)
+	}
+	return nil
+}
+
+// RelationMeta describes relation declared by model field
+type RelationMeta struct {
+	// Field is the name of struct field holding relation
+	Field string
+	// Column is the column name of relation field
+	Column string
+	// Kind is one of has_one, has_many or many_to_many
+	Kind string
+	// Target is the type of related model or type of the field for
+	// relations loading concatenated keys
+	Target reflect.Type
+	// Table is the mapping table of many to many relation or the table
+	// of related keys of concat relation
+	Table string
+	// Condition is additional condition of the mapping table
+	Condition string
+	// Concat is true if relation loads only keys of related models
+	Concat bool
+}
+
+// ModelRelations returns relations declared by model fields in order of
+// fields, nil is returned if m is not a pointer to struct
+func ModelRelations(m Model) []RelationMeta {
+	t := reflect.TypeOf(m)
+	if t == nil || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+	t = t.Elem()
+
+	var relations []RelationMeta
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !isExportedField(field) {
+			continue
+		}
+		ri := extractRelationInfo(field)
+		if ri == nil {
+			continue
+		}
+		meta := RelationMeta{
+			Field:     field.Name,
+			Column:    ri.Column,
+			Target:    ri.RelatedType,
+			Table:     ri.Table,
+			Condition: ri.Condition,
+		}
+		tag := field.Tag.Get(packageTagName)
+		switch ri.Type {
+		case hasOne:
+			meta.Kind = "has_one"
+		case hasMany:
+			meta.Kind = "has_many"
+		case manyToMany:
+			meta.Kind = "many_to_many"
+		case concatRelation:
+			meta.Concat = true
+			meta.Kind = "has_many"
+			if lookForSetting(tag, "many_to_many") != "" {
+				meta.Kind = "many_to_many"
+			}
+		}
+		relations = append(relations, meta)
+	}
+	return relations
+}
+
 // Parse model to obtain information useful for query builder
 func getModelInfo(o interface{}) (*modelInfo, error) {
 	mv, err := getModelValue(o)
@@ -185,6 +512,12 @@ func getModelInfo(o interface{}) (*modelInfo, error) {
 		table: reflect.New(mv.Type()).Interface().(IModel).Table(),
 		value: mv,
 	}
+	if tm, ok := o.(*tableModel); ok {
+		if err := validateTableName(tm.table); err != nil {
+			return nil, err
+		}
+		mi.table = tm.table
+	}
 
 	for i := 0; i < mv.NumField(); i++ {
 		if !mv.Field(i).CanInterface() {
@@ -199,15 +532,37 @@ func getModelInfo(o interface{}) (*modelInfo, error) {
 	return &mi, nil
 }
 
+func isIntKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return true
+	}
+	return false
+}
+
+// Applies last inserted id to the primary field tagged as autoincrement or to
+// the first zero integer primary field if there is no such one, so provided
+// parts of compound keys are kept
 func setModelPk(info *modelInfo, id int64) error {
-	// check if there were last inserted id and apply it to primary key
-	for _, field := range info.fields {
-		if isPkField(field) && !isReferenceField(field) {
-			if isZeroField(field.value) {
-				field.value.SetInt(id)
+	var target *modelField
+	for i, field := range info.fields {
+		if isPkField(field) && isAutoincrementField(field) {
+			target = &info.fields[i]
+			break
+		}
+	}
+	if target == nil {
+		for i, field := range info.fields {
+			if isPkField(field) && !isReferenceField(field) && isIntKind(field.value.Kind()) &&
+				isZeroField(field.value) {
+				target = &info.fields[i]
+				break
 			}
 		}
 	}
+	if target != nil && isZeroField(target.value) {
+		target.value.SetInt(id)
+	}
 	return nil
 }
 
@@ -234,6 +589,15 @@ func getRefModelPk(field modelField) *int64 {
 	return nil
 }
 
+// Checks if key field has zero value, has one relation used as a key is zero
+// when related model does not have primary key value
+func isZeroKey(field modelField) bool {
+	if isHasOne(field) {
+		return getRefModelPk(field) == nil
+	}
+	return isZeroField(field.value)
+}
+
 func getModelPkKeys(o interface{}) ([]interface{}, error) {
 	mi, err := getModelInfo(o)
 	if err != nil {
@@ -282,26 +646,61 @@ func getModelColumns(fields []modelField) ([]string, []string, []interface{}) {
 	)
 	for _, field := range fields {
 		if isOmittedField(field) || isExpressionField(field) ||
-			isReferenceField(field) && !isHasOne(field) {
+			isReferenceField(field) && !isHasOne(field) ||
+			isReadonlyField(field) && !isPkField(field) {
 			continue
 		}
 		if isPkField(field) {
-			if isZeroField(field.value) {
+			if isZeroKey(field) {
 				continue
 			}
 			indexes = append(indexes, field.column)
 		}
+		if hasDefault(field) && field.defaultValue == "" && isZeroField(field.value) {
+			continue // let database apply column default
+		}
 		if isUniqueField(field) {
 			indexes = append(indexes, field.column)
 		}
 		columns = append(columns, field.column)
+		args = append(args, fieldArg(field))
+	}
+	return columns, indexes, args
+}
+
+// Sets default literals from tags to model fields having zero values
+func applyDefaults(info *modelInfo) error {
+	for _, field := range info.fields {
+		if hasDefault(field) && field.defaultValue != "" && isZeroField(field.value) {
+			if err := setFieldValue(field.value, field.defaultValue); err != nil {
+				return errors.Wrapf(err, "can't apply default value to %s", field.column)
+			}
+		}
+	}
+	return nil
+}
+
+// Checks that fields tagged as notnull have values, if only is not nil just
+// listed columns are checked
+func checkRequiredFields(info *modelInfo, only map[string]struct{}) error {
+	for _, field := range info.fields {
+		if !isNotNullField(field) || hasDefault(field) && field.defaultValue == "" {
+			continue
+		}
+		if only != nil {
+			if _, ok := only[field.column]; !ok {
+				continue
+			}
+		}
 		if isHasOne(field) {
-			args = append(args, getRefModelPk(field))
-		} else {
-			args = append(args, field.value.Interface())
+			if field.value.IsNil() {
+				return errors.Wrapf(ErrFieldRequired, "column %s", field.column)
+			}
+		} else if isZeroField(field.value) {
+			return errors.Wrapf(ErrFieldRequired, "column %s", field.column)
 		}
 	}
-	return columns, indexes, args
+	return nil
 }
 
 func pkIsNull(info *modelInfo) bool {
@@ -314,3 +713,381 @@ func pkIsNull(info *modelInfo) bool {
 	}
 	return false
 }
+
+// ToMap returns model's column values keyed by column name, has one relations
+// are represented by primary key of related model or nil if it's not set
+func ToMap(m Model) (map[string]interface{}, error) {
+	info, err := getModelInfo(m)
+	if err != nil {
+		return nil, err
+	}
+	var data = make(map[string]interface{})
+	for _, field := range info.fields {
+		if isOmittedField(field) || isExpressionField(field) ||
+			isReferenceField(field) && !isHasOne(field) {
+			continue
+		}
+		if isHasOne(field) {
+			if pk := getRefModelPk(field); pk != nil {
+				data[field.column] = *pk
+			} else {
+				data[field.column] = nil
+			}
+			continue
+		}
+		data[field.column] = field.value.Interface()
+	}
+	return data, nil
+}
+
+// FromMap sets model fields from given data keyed by column name, values are
+// converted to the field type if possible, columns missing in model are ignored
+func FromMap(m Model, data map[string]interface{}) error {
+	info, err := getModelInfo(m)
+	if err != nil {
+		return err
+	}
+	if !info.value.CanSet() {
+		return errors.New("expected pointer to model")
+	}
+	for _, field := range info.fields {
+		if isOmittedField(field) || isExpressionField(field) ||
+			isReferenceField(field) && !isHasOne(field) {
+			continue
+		}
+		v, ok := data[field.column]
+		if !ok {
+			continue
+		}
+		if isHasOne(field) {
+			if err := setHasOneFromValue(field.value, v); err != nil {
+				return errors.Wrapf(err, "can't set column %s", field.column)
+			}
+			continue
+		}
+		if err := setFieldValue(field.value, v); err != nil {
+			return errors.Wrapf(err, "can't set column %s", field.column)
+		}
+	}
+	return nil
+}
+
+// sets has one relation field to a new model having only primary key set
+func setHasOneFromValue(field reflect.Value, v interface{}) error {
+	if v == nil {
+		field.Set(reflect.Zero(field.Type()))
+		return nil
+	}
+	if field.Kind() != reflect.Ptr {
+		return errors.Errorf("unsupported has one field type: %v", field.Type())
+	}
+	related := reflect.New(field.Type().Elem())
+	info, err := getModelInfo(related)
+	if err != nil {
+		return err
+	}
+	for _, f := range info.fields {
+		if isPkField(f) && !isReferenceField(f) {
+			if err := setFieldValue(f.value, v); err != nil {
+				return err
+			}
+			field.Set(related)
+			return nil
+		}
+	}
+	return errors.New("related model does not have primary key")
+}
+
+// Sets value to the field converting it's type when possible
+func setFieldValue(field reflect.Value, v interface{}) error {
+	if v == nil {
+		field.Set(reflect.Zero(field.Type()))
+		return nil
+	}
+	var (
+		converted interface{}
+		err       error
+	)
+	switch field.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		converted, err = cast.ToInt64E(v)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		converted, err = cast.ToUint64E(v)
+	case reflect.Float32, reflect.Float64:
+		converted, err = cast.ToFloat64E(v)
+	case reflect.Bool:
+		converted, err = cast.ToBoolE(v)
+	case reflect.String:
+		converted, err = cast.ToStringE(v)
+	default:
+		if field.Type() == reflect.TypeOf(time.Time{}) {
+			t, err := cast.ToTimeE(v)
+			if err != nil {
+				return err
+			}
+			field.Set(reflect.ValueOf(t))
+			return nil
+		}
+		value := reflect.ValueOf(v)
+		if value.Type().ConvertibleTo(field.Type()) {
+			field.Set(value.Convert(field.Type()))
+			return nil
+		}
+		if s, ok := field.Addr().Interface().(sql.Scanner); ok {
+			return s.Scan(v)
+		}
+		return errors.Errorf("can't convert %T to %v", v, field.Type())
+	}
+	if err != nil {
+		return err
+	}
+	field.Set(reflect.ValueOf(converted).Convert(field.Type()))
+	return nil
+}
+
+// nullableField is a scan destination setting zero value to the field when
+// column is NULL (e.g. aggregate over empty set) instead of failing
+type nullableField struct {
+	field reflect.Value
+}
+
+func (n nullableField) Scan(src interface{}) error {
+	if src == nil {
+		n.field.Set(reflect.Zero(n.field.Type()))
+		return nil
+	}
+	switch n.field.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var v sql.NullInt64
+		if err := v.Scan(src); err != nil {
+			return err
+		}
+		if n.field.OverflowInt(v.Int64) {
+			return errors.Errorf("value %d overflows %v", v.Int64, n.field.Type())
+		}
+		n.field.SetInt(v.Int64)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var v sql.NullInt64
+		if err := v.Scan(src); err != nil {
+			return err
+		}
+		if v.Int64 < 0 || n.field.OverflowUint(uint64(v.Int64)) {
+			return errors.Errorf("value %d overflows %v", v.Int64, n.field.Type())
+		}
+		n.field.SetUint(uint64(v.Int64))
+	case reflect.Float32, reflect.Float64:
+		var v sql.NullFloat64
+		if err := v.Scan(src); err != nil {
+			return err
+		}
+		n.field.SetFloat(v.Float64)
+	case reflect.Bool:
+		var v sql.NullBool
+		if err := v.Scan(src); err != nil {
+			return err
+		}
+		n.field.SetBool(v.Bool)
+	case reflect.String:
+		var v sql.NullString
+		if err := v.Scan(src); err != nil {
+			return err
+		}
+		n.field.SetString(v.String)
+	default:
+		t, err := scanTime(src)
+		if err != nil {
+			return err
+		}
+		n.field.Set(reflect.ValueOf(t))
+	}
+	return nil
+}
+
+// Converts column value to time, unix epoch and RFC3339 text are supported
+// along with values converted by the driver
+func scanTime(src interface{}) (time.Time, error) {
+	switch v := src.(type) {
+	case int64:
+		return time.Unix(v, 0), nil
+	case string:
+		return time.Parse(time.RFC3339Nano, v)
+	case []byte:
+		return time.Parse(time.RFC3339Nano, string(v))
+	}
+	var v sql.NullTime
+	if err := v.Scan(src); err != nil {
+		return time.Time{}, err
+	}
+	return v.Time, nil
+}
+
+// Checks if the type (or type it points to) is based on bool and scans
+// values by itself
+func isBoolScanner(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Bool && reflect.PtrTo(t).Implements(scannerType)
+}
+
+// boolScanner converts integer results (e.g. of comparison expressions) to
+// bool before passing them to the scanner of bool based field
+type boolScanner struct {
+	field reflect.Value
+}
+
+func (b boolScanner) Scan(src interface{}) error {
+	if src != nil {
+		var v sql.NullBool
+		if err := v.Scan(src); err != nil {
+			return err
+		}
+		src = v.Bool
+	}
+	if b.field.Kind() != reflect.Ptr {
+		return b.field.Addr().Interface().(sql.Scanner).Scan(src)
+	}
+	if src == nil {
+		b.field.Set(reflect.Zero(b.field.Type()))
+		return nil
+	}
+	if b.field.IsNil() {
+		b.field.Set(reflect.New(b.field.Type().Elem()))
+	}
+	return b.field.Interface().(sql.Scanner).Scan(src)
+}
+
+var scanConverters = struct {
+	sync.RWMutex
+	converters map[reflect.Type]func(src interface{}) (interface{}, error)
+}{converters: make(map[reflect.Type]func(src interface{}) (interface{}, error))}
+
+// RegisterScanConverter sets function converting values returned by the driver
+// into values of the type before they are scanned into fields of the type,
+// result has to be assignable or convertible to the type, nil result sets zero
+// value. Nil converter removes registered one.
+func RegisterScanConverter(t reflect.Type, convert func(src interface{}) (interface{}, error)) {
+	scanConverters.Lock()
+	if convert == nil {
+		delete(scanConverters.converters, t)
+	} else {
+		scanConverters.converters[t] = convert
+	}
+	scanConverters.Unlock()
+}
+
+func scanConverter(t reflect.Type) func(src interface{}) (interface{}, error) {
+	scanConverters.RLock()
+	defer scanConverters.RUnlock()
+	return scanConverters.converters[t]
+}
+
+// convertedField scans values converted by registered converter of field type
+type convertedField struct {
+	field   reflect.Value
+	convert func(src interface{}) (interface{}, error)
+}
+
+func (c convertedField) Scan(src interface{}) error {
+	v, err := c.convert(src)
+	if err != nil {
+		return errors.Wrapf(err, "can't convert %T to %v", src, c.field.Type())
+	}
+	if v == nil {
+		c.field.Set(reflect.Zero(c.field.Type()))
+		return nil
+	}
+	rv := reflect.ValueOf(v)
+	switch {
+	case rv.Type().AssignableTo(c.field.Type()):
+		c.field.Set(rv)
+	case rv.Type().ConvertibleTo(c.field.Type()):
+		c.field.Set(rv.Convert(c.field.Type()))
+	default:
+		return errors.Errorf("converted value %T can't be stored in %v", v, c.field.Type())
+	}
+	return nil
+}
+
+// Returns scan destination for the field, basic types and time are wrapped
+// to tolerate NULL values
+func scanDest(field reflect.Value) interface{} {
+	if convert := scanConverter(field.Type()); convert != nil {
+		return convertedField{field, convert}
+	}
+	if isBoolScanner(field.Type()) {
+		return boolScanner{field}
+	}
+	ptr := field.Addr().Interface()
+	if _, ok := ptr.(sql.Scanner); ok {
+		return ptr
+	}
+	switch field.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64, reflect.Bool, reflect.String:
+		return nullableField{field}
+	}
+	if field.Type() == reflect.TypeOf(time.Time{}) {
+		return nullableField{field}
+	}
+	if isJSONType(field.Type()) {
+		return jsonField{field}
+	}
+	return ptr
+}
+
+// rfc3339Fixed is time.RFC3339Nano layout keeping trailing zeros of
+// fractional seconds, so stored text is ordered the same way as time
+const rfc3339Fixed = "2006-01-02T15:04:05.000000000Z07:00"
+
+// Formats time according to column storage mode, without mode time is passed
+// to the driver as is
+func formatTime(t time.Time, storage string) interface{} {
+	switch storage {
+	case TimeUnix:
+		return t.Unix()
+	case TimeRFC3339:
+		return t.UTC().Format(rfc3339Fixed)
+	}
+	return t
+}
+
+// Returns value of the field to be written to the column
+func fieldArg(field modelField) interface{} {
+	if isHasOne(field) {
+		return getRefModelPk(field)
+	}
+	if t, ok := field.value.Interface().(time.Time); ok && field.timeStorage != "" {
+		return formatTime(t, field.timeStorage)
+	}
+	if isJSONType(field.value.Type()) {
+		return jsonField{field.value}
+	}
+	return field.value.Interface()
+}
+
+// Returns column marking soft deleted rows qualified with table name or empty
+// string if model is deleted permanently
+func softDeleteColumn(info *modelInfo) string {
+	for _, f := range info.fields {
+		if isSoftDeleteField(f) {
+			return info.table + "." + f.column
+		}
+	}
+	return ""
+}
+
+// Returns time storage modes of model columns
+func timeStorages(info *modelInfo) map[string]string {
+	var storages map[string]string
+	for _, f := range info.fields {
+		if f.timeStorage != "" {
+			if storages == nil {
+				storages = make(map[string]string)
+			}
+			storages[f.column] = f.timeStorage
+		}
+	}
+	return storages
+}
@@ -3,10 +3,12 @@ package ormlite
 import (
 	"database/sql"
 	"database/sql/driver"
+	"fmt"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
+	"reflect"
 	"testing"
 )
 
@@ -97,6 +99,486 @@ func (s *expressionFieldFixture) TestUpdate() {
 	}
 }
 
+type doubledField int64
+
+func (d *doubledField) Scan(src interface{}) error {
+	v, ok := src.(int64)
+	if !ok {
+		return errors.New("unsupported doubled type")
+	}
+	*d = doubledField(v)
+	return nil
+}
+
+func (d *doubledField) Value() (driver.Value, error) { return int64(*d), nil }
+
+func (d *doubledField) Column() string { return "(id * 2) as doubled" }
+
+type modelWithDoubled struct {
+	ID      int64 `ormlite:"primary"`
+	Doubled *doubledField
+}
+
+func (m *modelWithDoubled) Table() string { return "test" }
+
+func (s *expressionFieldFixture) TestWhereByExpressionAlias() {
+	var mm []*modelWithDoubled
+	if assert.NoError(s.T(), QuerySlice(s.db, &Options{Where: Where{"doubled": Greater(6)}}, &mm)) {
+		if assert.Len(s.T(), mm, 2) {
+			assert.EqualValues(s.T(), 8, *mm[0].Doubled)
+			assert.EqualValues(s.T(), 10, *mm[1].Doubled)
+		}
+	}
+
+	count, err := Count(s.db, &modelWithDoubled{}, &Options{Where: Where{"doubled": Greater(6)}})
+	if assert.NoError(s.T(), err) {
+		assert.EqualValues(s.T(), 2, count)
+	}
+}
+
+type shiftedField struct {
+	offset int64
+	value  int64
+}
+
+func (f *shiftedField) Scan(src interface{}) error {
+	v, ok := src.(int64)
+	if !ok {
+		return errors.New("unsupported shifted type")
+	}
+	f.value = v
+	return nil
+}
+
+func (f *shiftedField) Value() (driver.Value, error) { return f.value, nil }
+
+func (f *shiftedField) Column() string { return "(id + ?) as shifted" }
+
+func (f *shiftedField) Args() []interface{} {
+	if f == nil {
+		return []interface{}{0}
+	}
+	return []interface{}{f.offset}
+}
+
+type modelWithShifted struct {
+	ID      int64 `ormlite:"primary"`
+	Shifted *shiftedField
+}
+
+func (m *modelWithShifted) Table() string { return "test" }
+
+func (s *expressionFieldFixture) TestExpressionArgs() {
+	m := modelWithShifted{Shifted: &shiftedField{offset: 100}}
+	if assert.NoError(s.T(), QueryStruct(s.db, &Options{Where: Where{"id": 2}}, &m)) {
+		assert.EqualValues(s.T(), 102, m.Shifted.value)
+	}
+
+	var mm []*modelWithShifted
+	if assert.NoError(s.T(), QuerySlice(s.db, &Options{Where: Where{"id": Greater(3)}}, &mm)) && assert.Len(s.T(), mm, 2) {
+		assert.EqualValues(s.T(), 4, mm[0].Shifted.value)
+		assert.EqualValues(s.T(), 5, mm[1].Shifted.value)
+	}
+
+	mm = nil
+	require.NoError(s.T(), QueryUnion(s.db, &mm, true,
+		UnionPart{Model: &modelWithShifted{Shifted: &shiftedField{offset: 10}}, Options: &Options{Where: Where{"id": 1}}},
+		UnionPart{Model: &modelWithShifted{Shifted: &shiftedField{offset: 20}}, Options: &Options{Where: Where{"id": 2}}},
+	))
+	if assert.Len(s.T(), mm, 2) {
+		assert.EqualValues(s.T(), 11, mm[0].Shifted.value)
+		assert.EqualValues(s.T(), 22, mm[1].Shifted.value)
+	}
+
+	count, err := Count(s.db, &modelWithShifted{Shifted: &shiftedField{offset: 1}}, &Options{Where: Where{"id": Greater(2)}})
+	if assert.NoError(s.T(), err) {
+		assert.EqualValues(s.T(), 3, count)
+	}
+
+	// arguments of slice queries are bound by options
+	offset := int64(len("runtime"))
+	mm = nil
+	opts := &Options{Where: Where{"id": 2}, ColumnArgs: map[string][]interface{}{"shifted": {offset}}}
+	if assert.NoError(s.T(), QuerySlice(s.db, opts, &mm)) && assert.Len(s.T(), mm, 1) {
+		assert.EqualValues(s.T(), 2+offset, mm[0].Shifted.value)
+	}
+	m = modelWithShifted{Shifted: &shiftedField{offset: 100}}
+	if assert.NoError(s.T(), QueryStruct(s.db, opts, &m)) {
+		assert.EqualValues(s.T(), 2+offset, m.Shifted.value, "options take precedence over field")
+	}
+
+	// nil field with Args of value receiver doesn't panic
+	var nm []*modelWithValueArgs
+	opts = &Options{Where: Where{"id": 1}, ColumnArgs: map[string][]interface{}{"doubled": {2}}}
+	if assert.NoError(s.T(), QuerySlice(s.db, opts, &nm)) && assert.Len(s.T(), nm, 1) {
+		assert.EqualValues(s.T(), 2, nm[0].Doubled.value)
+	}
+	nm = nil
+	assert.NotPanics(s.T(), func() {
+		assert.Error(s.T(), QuerySlice(s.db, &Options{Where: Where{"id": 1}}, &nm), "argument isn't bound")
+	})
+}
+
+// valueArgsField implements Args with value receiver
+type valueArgsField struct{ value int64 }
+
+func (f *valueArgsField) Scan(src interface{}) error {
+	f.value, _ = src.(int64)
+	return nil
+}
+
+func (f *valueArgsField) Value() (driver.Value, error) { return f.value, nil }
+
+func (f *valueArgsField) Column() string { return "(id * ?) as doubled" }
+
+func (f valueArgsField) Args() []interface{} { return []interface{}{2} }
+
+type modelWithValueArgs struct {
+	ID      int64 `ormlite:"primary"`
+	Doubled *valueArgsField
+}
+
+func (m *modelWithValueArgs) Table() string { return "test" }
+
+type greaterFlag bool
+
+func (f *greaterFlag) Scan(src interface{}) error {
+	v, ok := src.(bool)
+	if !ok {
+		return errors.Errorf("unsupported flag type %T", src)
+	}
+	*f = greaterFlag(v)
+	return nil
+}
+
+func (f *greaterFlag) Value() (driver.Value, error) { return bool(*f), nil }
+
+func (f *greaterFlag) Column() string { return "(id > 3) as greater" }
+
+type modelWithFlags struct {
+	ID      int64 `ormlite:"primary"`
+	Greater *greaterFlag
+	Even    bool
+}
+
+func (m *modelWithFlags) Table() string { return "test" }
+
+func (s *expressionFieldFixture) TestBoolExpression() {
+	var mm []*modelWithFlags
+	opts := &Options{From: "select id, id % 2 = 0 as even from test", OrderBy: &OrderBy{Field: "id"}}
+	if assert.NoError(s.T(), QuerySlice(s.db, opts, &mm)) && assert.Len(s.T(), mm, 5) {
+		for _, m := range mm {
+			if assert.NotNil(s.T(), m.Greater) {
+				assert.Equal(s.T(), m.ID > 3, bool(*m.Greater), "id %d", m.ID)
+			}
+			assert.Equal(s.T(), m.ID%2 == 0, m.Even, "id %d", m.ID)
+		}
+	}
+
+	m := modelWithFlags{Greater: new(greaterFlag)}
+	if assert.NoError(s.T(), QueryStruct(s.db, &Options{Where: Where{"id": 4}, From: opts.From}, &m)) {
+		assert.True(s.T(), bool(*m.Greater))
+		assert.True(s.T(), m.Even)
+	}
+}
+
+type tripledField int64
+
+func (t *tripledField) Scan(src interface{}) error {
+	v, ok := src.(int64)
+	if !ok {
+		return errors.New("unsupported tripled type")
+	}
+	*t = tripledField(v)
+	return nil
+}
+
+func (t *tripledField) Value() (driver.Value, error) { return int64(*t), nil }
+
+// expression selects a helper column along with its own one
+func (t *tripledField) Column() string { return "id % 2 as odd, id * 3 as tripled" }
+
+type modelWithInterleaved struct {
+	ID      int64 `ormlite:"primary"`
+	Tripled *tripledField
+	Name    string
+	Doubled *doubledField
+}
+
+func (m *modelWithInterleaved) Table() string { return "test" }
+
+func (s *expressionFieldFixture) TestInterleavedExpressions() {
+	var mm []*modelWithInterleaved
+	opts := &Options{Where: Where{"id": LessOrEqual(2)}, OrderBy: &OrderBy{Field: "id"}}
+	if assert.NoError(s.T(), QuerySlice(s.db, opts, &mm)) && assert.Len(s.T(), mm, 2) {
+		for _, m := range mm {
+			if assert.NotNil(s.T(), m.Tripled) && assert.NotNil(s.T(), m.Doubled) {
+				assert.EqualValues(s.T(), m.ID*3, *m.Tripled)
+				assert.EqualValues(s.T(), m.ID*2, *m.Doubled)
+			}
+		}
+		assert.Equal(s.T(), "1", mm[0].Name)
+		assert.Equal(s.T(), "2", mm[1].Name)
+	}
+
+	m := modelWithInterleaved{Tripled: new(tripledField), Doubled: new(doubledField)}
+	if assert.NoError(s.T(), QueryStruct(s.db, &Options{Where: Where{"id": 3}}, &m)) {
+		assert.EqualValues(s.T(), 3, m.ID)
+		assert.EqualValues(s.T(), 9, *m.Tripled)
+		assert.Equal(s.T(), "3", m.Name)
+		assert.EqualValues(s.T(), 6, *m.Doubled)
+	}
+}
+
+type modelWithDoubledKey struct {
+	Doubled *doubledField `ormlite:"primary"`
+	Name    string
+}
+
+func (m *modelWithDoubledKey) Table() string { return "test" }
+
+func TestExpressionPrimaryKey(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(new(expressionFieldFixture).Query())
+	require.NoError(t, err)
+
+	var m modelWithDoubledKey
+	require.NoError(t, QueryStruct(db, &Options{Where: Where{"doubled": 6}}, &m))
+	require.NotNil(t, m.Doubled)
+	assert.EqualValues(t, 6, *m.Doubled)
+	assert.Equal(t, "3", m.Name)
+
+	var mm []*modelWithDoubledKey
+	require.NoError(t, QuerySlice(db, &Options{Where: Where{"doubled": LessOrEqual(4)}}, &mm))
+	assert.Len(t, mm, 2)
+
+	res, err := Delete(db, &m)
+	require.NoError(t, err)
+	affected, err := res.RowsAffected()
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, affected)
+
+	count, err := Count(db, &modelWithDoubledKey{}, nil)
+	require.NoError(t, err)
+	assert.EqualValues(t, 4, count)
+	var deleted modelWithDoubledKey
+	require.NoError(t, QueryStruct(db, &Options{Where: Where{"doubled": 6}}, &deleted))
+	assert.Nil(t, deleted.Doubled)
+
+	_, err = Delete(db, &modelWithDoubledKey{})
+	assert.Error(t, err)
+}
+
 func TestExpressionFields(t *testing.T) {
 	suite.Run(t, new(expressionFieldFixture))
 }
+
+func TestToMapFromMap(t *testing.T) {
+	m := simpleModelWithRelation{ID: 1, NotTaggedField: "test", Related: &simpleModel{ID: 2}}
+	data, err := ToMap(&m)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"id": int64(1), "not_tagged_field": "test", "related_id": int64(2),
+	}, data)
+
+	var m1 simpleModelWithRelation
+	require.NoError(t, FromMap(&m1, data))
+	assert.Equal(t, m, m1)
+
+	// values decoded from json come as float64
+	var m2 simpleModelWithRelation
+	require.NoError(t, FromMap(&m2, map[string]interface{}{
+		"id": float64(3), "not_tagged_field": "json", "related_id": nil,
+	}))
+	assert.EqualValues(t, 3, m2.ID)
+	assert.Equal(t, "json", m2.NotTaggedField)
+	assert.Nil(t, m2.Related)
+
+	assert.Error(t, FromMap(&m2, map[string]interface{}{"id": "not a number"}))
+}
+
+type modelWithUnsupportedField struct {
+	ID      int64 `ormlite:"primary"`
+	Updates chan int
+}
+
+func (*modelWithUnsupportedField) Table() string { return "unsupported" }
+
+type modelWithNestedStruct struct {
+	ID     int64 `ormlite:"primary"`
+	Nested struct{ Name string }
+	Hidden struct{ Name string } `ormlite:"-"`
+}
+
+func (*modelWithNestedStruct) Table() string { return "nested" }
+
+// modelWithRelationLikeColumn has a column which name contains relation setting
+type modelWithRelationLikeColumn struct {
+	ID      int64    `ormlite:"primary"`
+	Updates chan int `ormlite:"col=has_many_updates"`
+}
+
+func (*modelWithRelationLikeColumn) Table() string { return "unsupported" }
+
+func TestValidateModel(t *testing.T) {
+	err := ValidateModel(&modelWithUnsupportedField{})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "modelWithUnsupportedField.Updates")
+		assert.Contains(t, err.Error(), "chan int")
+	}
+
+	err = ValidateModel(&modelWithNestedStruct{})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "modelWithNestedStruct.Nested")
+	}
+
+	// tagged fields are checked unless they are relations
+	_, err = getColumnInfo(reflect.TypeOf(modelWithRelationLikeColumn{}))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "modelWithRelationLikeColumn.Updates")
+	}
+
+	var mm []*modelWithUnsupportedField
+	err = QuerySlice(nil, nil, &mm)
+	if assert.Error(t, err, "query should fail before touching database") {
+		assert.Contains(t, err.Error(), "modelWithUnsupportedField.Updates")
+	}
+
+	for _, m := range []Model{&simpleModel{}, &modelWithDoubled{}, &BigModel{}, &modelWithNullFields{}, &modelHasOne{}} {
+		assert.NoError(t, ValidateModel(m), "%T", m)
+	}
+}
+
+type modelWithJSONFields struct {
+	ID     int64 `ormlite:"primary"`
+	Tags   []string
+	Scores map[string]int
+}
+
+func (*modelWithJSONFields) Table() string { return "documents" }
+
+func TestJSONFields(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(`create table documents(id integer primary key, tags text, scores text)`)
+	require.NoError(t, err)
+	require.NoError(t, ValidateModel(&modelWithJSONFields{}))
+
+	m := modelWithJSONFields{Tags: []string{"go", "sql"}, Scores: map[string]int{"a": 1}}
+	require.NoError(t, Insert(db, &m))
+	var tags string
+	require.NoError(t, db.QueryRow("select tags from documents where id = ?", m.ID).Scan(&tags))
+	assert.Equal(t, `["go","sql"]`, tags)
+
+	var stored modelWithJSONFields
+	require.NoError(t, QueryStruct(db, &Options{Where: Where{"id": m.ID}}, &stored))
+	assert.Equal(t, m, stored)
+
+	stored.Tags = append(stored.Tags, "orm")
+	stored.Scores = nil
+	require.NoError(t, Upsert(db, &stored))
+	var mm []*modelWithJSONFields
+	require.NoError(t, QuerySlice(db, nil, &mm))
+	if assert.Len(t, mm, 1) {
+		assert.Equal(t, []string{"go", "sql", "orm"}, mm[0].Tags)
+		assert.Nil(t, mm[0].Scores)
+	}
+}
+
+func TestModelRelations(t *testing.T) {
+	assert.Equal(t, []RelationMeta{
+		{Field: "HasOne", Column: "has_one", Kind: "has_one", Target: reflect.TypeOf(&testSearchHasOneModel{})},
+		{Field: "HasMany", Column: "has_many", Kind: "has_many", Target: reflect.TypeOf(&testSearchHasManyModel{})},
+		{Field: "ManyToMany", Column: "many_to_many", Kind: "many_to_many", Target: reflect.TypeOf(&testSearchMTMModel{}),
+			Table: "relation_table"},
+	}, ModelRelations(&testSearchBaseModel{}))
+
+	relations := ModelRelations(&compactAuthor{})
+	if assert.Len(t, relations, 2) {
+		assert.Equal(t, RelationMeta{Field: "BookIDs", Column: "book_i_ds", Kind: "has_many", Target: reflect.TypeOf([]int64{}),
+			Table: "books", Concat: true}, relations[0])
+		assert.Equal(t, "many_to_many", relations[1].Kind)
+	}
+
+	assert.Empty(t, ModelRelations(&simpleModel{}))
+}
+
+type ticketPriority int
+
+const (
+	lowPriority ticketPriority = iota + 1
+	highPriority
+)
+
+type ticketWithPriority struct {
+	ID       int64 `ormlite:"primary"`
+	Priority ticketPriority
+}
+
+func (*ticketWithPriority) Table() string { return "tickets" }
+
+func TestRegisterScanConverter(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Exec(`
+		create table tickets(id integer primary key, priority text);
+		insert into tickets(priority) values ('low'), ('high'), (null), ('urgent');
+	`)
+	require.NoError(t, err)
+
+	RegisterScanConverter(reflect.TypeOf(ticketPriority(0)), func(src interface{}) (interface{}, error) {
+		if src == nil {
+			return nil, nil
+		}
+		switch fmt.Sprintf("%s", src) {
+		case "low":
+			return lowPriority, nil
+		case "high":
+			return highPriority, nil
+		}
+		return nil, errors.Errorf("unknown priority %v", src)
+	})
+	defer RegisterScanConverter(reflect.TypeOf(ticketPriority(0)), nil)
+
+	var m ticketWithPriority
+	require.NoError(t, QueryStruct(db, &Options{Where: Where{"id": 2}}, &m))
+	assert.Equal(t, highPriority, m.Priority)
+
+	var mm []*ticketWithPriority
+	require.NoError(t, QuerySlice(db, &Options{Where: Where{"id": []int64{1, 3}}}, &mm))
+	if assert.Len(t, mm, 2) {
+		assert.Equal(t, lowPriority, mm[0].Priority)
+		assert.Zero(t, mm[1].Priority)
+	}
+
+	mm = nil
+	assert.Error(t, QuerySlice(db, nil, &mm), "unknown value is reported by converter")
+}
+
+func TestIsZeroField(t *testing.T) {
+	type withSlice struct{ Tags []string }
+	var v struct {
+		Struct    withSlice
+		Array     [2][]int
+		Slice     []string
+		Empty     []string
+		Null      sql.NullString
+		Interface interface{}
+	}
+	v.Empty = []string{}
+	rv := reflect.ValueOf(&v).Elem()
+	for i, zero := range []bool{true, true, true, false, true, true} {
+		assert.Equal(t, zero, isZeroField(rv.Field(i)), rv.Type().Field(i).Name)
+	}
+
+	v.Struct.Tags, v.Array[1] = []string{"a"}, []int{1}
+	v.Null, v.Interface = sql.NullString{String: "", Valid: true}, []int{}
+	for _, i := range []int{0, 1, 4, 5} {
+		assert.False(t, isZeroField(rv.Field(i)), rv.Type().Field(i).Name)
+	}
+}
@@ -4,12 +4,13 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
-	"math/rand"
 	"os"
 	"reflect"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
 	"time"
-	"unsafe"
 
 	"github.com/pkg/errors"
 )
@@ -26,19 +27,26 @@ const (
 	hasMany
 	hasOne
 	manyToMany
+	concatRelation
 
-	letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
-
-	letterIdxBits       = 6                    // 6 bits to represent a letter index
-	letterIdxMask       = 1<<letterIdxBits - 1 // All 1-bits, as many as letterIdxBits
-	letterIdxMax        = 63 / letterIdxBits   // # of letter indices fitting in 63 bits
-	tempTableNameLength = 2 << 2
+	maxPrintedArgs = 20
 )
 
 var (
 	// ErrNoRowsAffected is an error to return when no rows were affected
 	ErrNoRowsAffected = errors.New("no rows affected")
-	src               = rand.NewSource(time.Now().UnixNano())
+	// ErrFieldRequired is an error to return when field tagged as notnull has zero value
+	ErrFieldRequired = errors.New("field is required")
+	// ErrNoTable is an error to return when rows of model without table are queried
+	ErrNoTable = errors.New("model does not have a table")
+	// ErrRelationDepthExceeded is an error to return when relation depth is bigger than MaxRelationDepth
+	ErrRelationDepthExceeded = errors.New("relation depth exceeded")
+	// ErrMultipleRows is returned by QueryStruct when several rows match options
+	// having ErrorOnMultiple set
+	ErrMultipleRows = errors.New("multiple rows match")
+
+	// MaxRelationDepth limits depth of loaded relations to protect from endless loading of cyclic models
+	MaxRelationDepth = 20
 )
 
 // Error is a custom struct that contains sql error, query and arguments
@@ -48,27 +56,117 @@ type Error struct {
 	Args     []interface{}
 }
 
-// Error implements error interface
+// Error implements error interface, query arguments are never included in
+// the message, use "%+v" verb to print query and arguments
 func (e *Error) Error() string { return e.SQLError.Error() }
 
+// Format implements fmt.Formatter, "%+v" prints query and arguments passed
+// through the redactor set by SetArgRedactor, long argument lists are truncated
+func (e *Error) Format(s fmt.State, verb rune) {
+	switch {
+	case verb == 'v' && s.Flag('+'):
+		fmt.Fprintf(s, "%s\nquery: %s\nargs: %s", e.Error(), e.Query, formatArgs(redactArgs(e.Args)))
+	case verb == 'q':
+		fmt.Fprintf(s, "%q", e.Error())
+	default:
+		fmt.Fprint(s, e.Error())
+	}
+}
+
+var argRedactor struct {
+	sync.RWMutex
+	redact func([]interface{}) []interface{}
+}
+
+// SetArgRedactor sets function used to hide sensitive query arguments when
+// errors or debug output are printed, nil disables redaction
+func SetArgRedactor(redact func([]interface{}) []interface{}) {
+	argRedactor.Lock()
+	argRedactor.redact = redact
+	argRedactor.Unlock()
+}
+
+func redactArgs(args []interface{}) []interface{} {
+	argRedactor.RLock()
+	defer argRedactor.RUnlock()
+	if argRedactor.redact == nil || len(args) == 0 {
+		return args
+	}
+	// redactor may modify slice in place, so give it a copy
+	return argRedactor.redact(append([]interface{}(nil), args...))
+}
+
+func formatArgs(args []interface{}) string {
+	if len(args) > maxPrintedArgs {
+		return fmt.Sprintf("%v ... (%d more)", args[:maxPrintedArgs], len(args)-maxPrintedArgs)
+	}
+	return fmt.Sprintf("%v", args)
+}
+
+// Prints query and it's arguments if ORMLITE_DEBUG environment variable is set
+func debugQuery(q string, args []interface{}) {
+	if os.Getenv("ORMLITE_DEBUG") == "1" {
+		fmt.Println(q)
+		fmt.Println(formatArgs(redactArgs(args)))
+	}
+}
+
 // OrderBy describes ordering rule
 type OrderBy struct {
 	Field string `json:"field"`
 	Order string `json:"order"`
 }
 
+var orderFieldPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// Checks that ordering refers a column and has known direction, since it's
+// rendered into query as is
+func (o OrderBy) validate() error {
+	if !orderFieldPattern.MatchString(o.Field) {
+		return errors.Errorf("invalid order field: %q", o.Field)
+	}
+	switch strings.ToLower(o.Order) {
+	case "", "asc", "desc":
+		return nil
+	}
+	return errors.Errorf("invalid order direction of %s: %q", o.Field, o.Order)
+}
+
 // Where is a map containing fields and their values to meet in the result
 type Where map[string]interface{}
 
-type Greater float64
+// Comparison compares column with a bound value or another column referenced
+// by Col, it's built by Greater, Less and other comparison operators
+type Comparison struct {
+	operator string
+	operand  interface{}
+}
+
+// Greater returns condition rendering col > ?, e.g. Where{"end": Greater(Col("start"))}
+// renders end > start
+func Greater(v interface{}) Comparison {
+	return Comparison{operator: ">", operand: v}
+}
 
-type Less float64
+// Less returns condition rendering col < ?
+func Less(v interface{}) Comparison {
+	return Comparison{operator: "<", operand: v}
+}
 
-type GreaterOrEqual float64
+// GreaterOrEqual returns condition rendering col >= ?
+func GreaterOrEqual(v interface{}) Comparison {
+	return Comparison{operator: ">=", operand: v}
+}
 
-type LessOrEqual float64
+// LessOrEqual returns condition rendering col <= ?
+func LessOrEqual(v interface{}) Comparison {
+	return Comparison{operator: "<=", operand: v}
+}
 
-type NotEqual float64
+// NotEqual returns condition rendering col != ?
+func NotEqual(v interface{}) Comparison {
+	return Comparison{operator: "!=", operand: v}
+}
 
 type BitwiseAND float64
 
@@ -76,6 +174,102 @@ type BitwiseANDStrict float64
 
 type StrictString string
 
+// Between matches values within the closed range
+type Between struct {
+	From, To interface{}
+}
+
+// After matches time values later than given one
+type After time.Time
+
+// Before matches time values earlier than given one
+type Before time.Time
+
+// OrGroup contains values compared with the same column and glued with OR,
+// values can be wrapped with any operator
+type OrGroup []interface{}
+
+// AnyOf returns group of values matching rows that meet any of them,
+// e.g. Where{"status": AnyOf(1, Greater(5))} renders (status = ? or status > ?)
+func AnyOf(values ...interface{}) OrGroup {
+	return values
+}
+
+// NullSafe compares column with the value using is operator, so the same
+// condition matches NULL when value is nil
+type NullSafe struct {
+	Value interface{}
+}
+
+// NullSafeEqual returns condition rendering col is ? with given value
+func NullSafeEqual(value interface{}) NullSafe {
+	return NullSafe{Value: value}
+}
+
+// InTuples filters rows by a set of compound values, it's used with a key listing
+// columns separated by comma, e.g. Where{"first_id,second_id": InTuples{{1, 2}, {2, 1}}}
+type InTuples [][]interface{}
+
+// Col references a column instead of a bound value, e.g. Where{"start": Col("end")}
+// renders start = end, Where{"start": Less(Col("end"))} renders start < end
+type Col string
+
+// SubQuery matches column values against rows returned by raw sql query,
+// its arguments are bound in place of the subquery
+type SubQuery struct {
+	SQL  string
+	Args []interface{}
+}
+
+// InQuery returns condition matching rows having column value among the
+// ones selected by given query, e.g. Where{"id": InQuery("select user_id
+// from sessions where active = ?", true)} renders id in (select ...)
+func InQuery(sql string, args ...interface{}) SubQuery {
+	return SubQuery{SQL: sql, Args: args}
+}
+
+// JSONPath returns where key comparing value at the path of JSON column, e.g.
+// Where{JSONPath("data", "$.role"): StrictString("admin")} renders
+// json_extract(data, '$.role') = ?. Path consists of object keys and array
+// indexes, e.g. $.roles[0].name
+func JSONPath(column, path string) string {
+	return fmt.Sprintf("json_extract(%s, '%s')", column, path)
+}
+
+// OptionalValue is a condition value that is dropped from the query when
+// it's nil or zero, pointers are dereferenced. Operators (e.g. Greater(0))
+// are explicit values, so they are kept even if zero
+type OptionalValue struct {
+	Value interface{}
+}
+
+// Optional returns condition applied only if given value is set, e.g.
+// Where{"name": Optional(req.Name)} doesn't filter rows if req.Name is nil
+func Optional(value interface{}) OptionalValue {
+	return OptionalValue{Value: value}
+}
+
+// operatorPkgPath is package path of operator types, e.g. Greater
+var operatorPkgPath = reflect.TypeOf(Greater(0)).PkgPath()
+
+// Returns value of optional condition and whether it's set
+func (o OptionalValue) value() (interface{}, bool) {
+	v := reflect.ValueOf(o.Value)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil, false
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return nil, false
+	}
+	if v.IsZero() && v.Type().PkgPath() != operatorPkgPath {
+		return nil, false
+	}
+	return v.Interface(), true
+}
+
 const (
 	// AND is a glue between multiple statements after `where`
 	AND = " and "
@@ -83,19 +277,141 @@ const (
 	OR = " or "
 )
 
+// MultipleRows defines how QueryStruct handles several rows matching options
+type MultipleRows int
+
+const (
+	// FirstRow scans the first matching row, only one row is selected
+	FirstRow MultipleRows = iota
+	// LastRow scans the last matching row
+	LastRow
+	// ErrorOnMultiple makes QueryStruct return ErrMultipleRows
+	ErrorOnMultiple
+)
+
 // Options represents query options
 type Options struct {
-	Where         Where    `json:"where"`
-	Divider       string   `json:"divider"`
-	Limit         int      `json:"limit"`
-	Offset        int      `json:"offset"`
-	OrderBy       *OrderBy `json:"order_by"`
+	Where Where `json:"where"`
+	// Divider glues where conditions, AND is used if it's empty
+	Divider string   `json:"divider"`
+	Limit   int      `json:"limit"`
+	Offset  int      `json:"offset"`
+	OrderBy *OrderBy `json:"order_by"`
+	// NoRows makes query return no rows, e.g. to count matching rows with
+	// QuerySliceCount only, since zero or negative limit doesn't limit rows
+	NoRows bool `json:"no_rows"`
+	// ThenBy contains orderings applied after OrderBy
+	ThenBy []OrderBy `json:"then_by"`
+	// RelationDepth limits depth of loaded relations, zero depth skips
+	// loading of any relations
 	RelationDepth int      `json:"relation_depth"`
 	RelatedTo     []IModel `json:"related"`
+	// RelatedExists makes RelatedTo models searched with exists subqueries
+	// instead of joins, so rows (and counts) are never duplicated
+	RelatedExists bool `json:"related_exists"`
+	// GroupBy contains columns to group rows by
+	GroupBy []string `json:"group_by"`
+	// Having contains conditions applied to grouped rows, they are always
+	// glued with AND
+	Having Where `json:"having"`
 	// Columns contains map with string keys of columns to include to the query
 	// instead of querying all model fields
 	Columns map[string]struct{} `json:"columns"`
-	joins   []string
+	// From contains subquery to select rows from instead of model table,
+	// it's aliased with table name, so columns are mapped to the fields as usual.
+	// It's raw sql, so it's never decoded from json
+	From string `json:"-"`
+	// WithTrashed includes rows marked by soft delete column, it's
+	// propagated to the queries of related models
+	WithTrashed bool `json:"with_trashed"`
+	// Exists filters models having at least one related model of has many
+	// relation matching conditions, it's keyed by column name of relation field
+	// (e.g. "children") and conditions are glued with AND
+	Exists map[string]Where `json:"exists"`
+	// RelationColumns restricts columns of loaded relations like Columns does for
+	// queried model, it's keyed by column name of relation field and applied to
+	// relations of queried model only
+	RelationColumns map[string]map[string]struct{} `json:"relation_columns"`
+	// RelationWhere filters loaded models of has many and many to many relations,
+	// it's keyed by column name of relation field, conditions are glued with AND
+	// and applied to relations of queried model only
+	RelationWhere map[string]Where `json:"relation_where"`
+	// RelationOrder orders loaded models of has many and many to many relations,
+	// it's keyed by column name of relation field, models are ordered by their
+	// primary key unless ordering is set
+	RelationOrder map[string][]OrderBy `json:"relation_order"`
+	// Assembler picks model type for each row, rows are read from its table
+	// and slice elements must be able to hold any of assembled models
+	Assembler RowAssembler `json:"-"`
+	// Table overrides table of queried model, e.g. to query partitions of the
+	// same model, it's not applied to relations. It can point query to any
+	// table, so it's never decoded from json
+	Table string `json:"-"`
+	// StrictColumns makes query fail before it's executed if keys of Where,
+	// orderings or Columns are not columns of queried model
+	StrictColumns bool `json:"strict_columns"`
+	// OnMultiple defines which row QueryStruct scans when several rows match
+	OnMultiple MultipleRows `json:"on_multiple"`
+	// JoinRelations makes slice queries join tables of has one relations and
+	// scan related models from the same rows instead of querying them per row
+	JoinRelations bool `json:"join_relations"`
+	// ColumnArgs binds arguments of ArgExpression columns by their aliases,
+	// they take precedence over arguments returned by Args of model fields
+	ColumnArgs map[string][]interface{} `json:"column_args"`
+	// error of building options reported by queries
+	err   error
+	joins []string
+	// time storage modes of queried model columns used to format operands
+	timeStorage map[string]string
+	// soft delete column of queried model to skip marked rows
+	softDelete string
+	// conditions glued with AND regardless of divider (e.g. built from Exists
+	// option) with their arguments
+	filters    []string
+	filterArgs []interface{}
+	// conditions of relation loaded by these options, they are glued with AND
+	// regardless of divider
+	scope Where
+	// arguments of selected expression columns
+	columnArgs []interface{}
+	// arguments of From subquery built by the package (e.g. LatestPerGroup)
+	fromArgs []interface{}
+	// columns of queried model qualified with its table, they are used when
+	// joined tables may have columns with the same names
+	qualified map[string]string
+	// flag that RelationDepth is set explicitly (e.g. zero depth of the last
+	// loaded relation level), so default relation depth is not applied
+	depthSet bool
+}
+
+var defaultDepth struct {
+	sync.RWMutex
+	depth int
+}
+
+// SetDefaultRelationDepth sets depth of relations loaded by queries with nil
+// options or options having zero RelationDepth, zero disables it
+func SetDefaultRelationDepth(n int) {
+	defaultDepth.Lock()
+	defaultDepth.depth = n
+	defaultDepth.Unlock()
+}
+
+// Returns options with default relation depth applied, given options are not
+// modified
+func withDefaultRelationDepth(opts *Options) *Options {
+	defaultDepth.RLock()
+	depth := defaultDepth.depth
+	defaultDepth.RUnlock()
+	if depth == 0 || opts != nil && (opts.RelationDepth != 0 || opts.depthSet) {
+		return opts
+	}
+	if opts == nil {
+		return &Options{RelationDepth: depth}
+	}
+	c := *opts
+	c.RelationDepth = depth
+	return &c
 }
 
 // DefaultOptions returns default options for query
@@ -109,6 +425,24 @@ func WithWhere(options *Options, where Where) *Options {
 	return options
 }
 
+// WithExample modifies existing options by adding conditions built by
+// ByExample from given model to their where clause, error of invalid model
+// is returned by query using the options
+func WithExample(options *Options, m Model) *Options {
+	where, err := byExample(m)
+	if err != nil {
+		options.err = errors.Wrap(err, "invalid example")
+		return options
+	}
+	if options.Where == nil {
+		options.Where = make(Where)
+	}
+	for k, v := range where {
+		options.Where[k] = v
+	}
+	return options
+}
+
 // WithLimit modifies existing options by adding limit parameter to them
 func WithLimit(options *Options, limit int) *Options {
 	options.Limit = limit
@@ -116,12 +450,9 @@ func WithLimit(options *Options, limit int) *Options {
 }
 
 // WithOffset modifies existing options by adding offset parameter to them.
-// If options does not have positive limit parameter the offset will remain unchanged
-// to avoid sql query correctness.
+// If options does not have limit parameter all rows after the offset are returned.
 func WithOffset(options *Options, offset int) *Options {
-	if options.Limit != 0 {
-		options.Offset = offset
-	}
+	options.Offset = offset
 	return options
 }
 
@@ -131,6 +462,170 @@ func WithOrder(options *Options, by OrderBy) *Options {
 	return options
 }
 
+// WithOrders modifies existing options by setting ordering by several columns,
+// e.g. WithOrders(opts, OrderBy{"a", "asc"}, OrderBy{"b", "desc"}) renders
+// order by a asc, b desc
+func WithOrders(options *Options, orders ...OrderBy) *Options {
+	options.OrderBy, options.ThenBy = nil, nil
+	if len(orders) != 0 {
+		options.OrderBy = &orders[0]
+		options.ThenBy = append([]OrderBy(nil), orders[1:]...)
+	}
+	return options
+}
+
+// WithDivider modifies existing options by setting glue between where conditions
+func WithDivider(options *Options, divider string) *Options {
+	options.Divider = divider
+	return options
+}
+
+// WithRelationDepth modifies existing options by setting depth of loaded relations
+func WithRelationDepth(options *Options, depth int) *Options {
+	options.RelationDepth = depth
+	options.depthSet = true
+	return options
+}
+
+// WithoutRelations modifies existing options to skip loading of relations
+func WithoutRelations(options *Options) *Options {
+	return WithRelationDepth(options, 0)
+}
+
+// WithRelated modifies existing options by adding models to search related ones
+func WithRelated(options *Options, related ...IModel) *Options {
+	options.RelatedTo = append(options.RelatedTo, related...)
+	return options
+}
+
+// WithColumns modifies existing options by adding columns to query
+// instead of all model fields
+func WithColumns(options *Options, columns ...string) *Options {
+	if options.Columns == nil {
+		options.Columns = make(map[string]struct{}, len(columns))
+	}
+	for _, c := range columns {
+		options.Columns[c] = struct{}{}
+	}
+	return options
+}
+
+// Clone returns a deep copy of options, so it can be modified without
+// affecting the original ones
+func (o *Options) Clone() *Options {
+	if o == nil {
+		return nil
+	}
+	c := *o
+	c.joins = nil
+	c.timeStorage = nil
+	c.softDelete = ""
+	c.filters, c.filterArgs = nil, nil
+	c.columnArgs = nil
+	c.qualified = nil
+	if o.Exists != nil {
+		c.Exists = make(map[string]Where, len(o.Exists))
+		for k, w := range o.Exists {
+			c.Exists[k] = make(Where, len(w))
+			for wk, wv := range w {
+				c.Exists[k][wk] = wv
+			}
+		}
+	}
+	if o.RelationWhere != nil {
+		c.RelationWhere = make(map[string]Where, len(o.RelationWhere))
+		for k, w := range o.RelationWhere {
+			c.RelationWhere[k] = make(Where, len(w))
+			for wk, wv := range w {
+				c.RelationWhere[k][wk] = wv
+			}
+		}
+	}
+	if o.RelationOrder != nil {
+		c.RelationOrder = make(map[string][]OrderBy, len(o.RelationOrder))
+		for k, orders := range o.RelationOrder {
+			c.RelationOrder[k] = append([]OrderBy(nil), orders...)
+		}
+	}
+	if o.Where != nil {
+		c.Where = make(Where, len(o.Where))
+		for k, v := range o.Where {
+			c.Where[k] = v
+		}
+	}
+	if o.Having != nil {
+		c.Having = make(Where, len(o.Having))
+		for k, v := range o.Having {
+			c.Having[k] = v
+		}
+	}
+	if o.Columns != nil {
+		c.Columns = make(map[string]struct{}, len(o.Columns))
+		for k := range o.Columns {
+			c.Columns[k] = struct{}{}
+		}
+	}
+	if o.RelationColumns != nil {
+		c.RelationColumns = make(map[string]map[string]struct{}, len(o.RelationColumns))
+		for rel, columns := range o.RelationColumns {
+			c.RelationColumns[rel] = make(map[string]struct{}, len(columns))
+			for k := range columns {
+				c.RelationColumns[rel][k] = struct{}{}
+			}
+		}
+	}
+	if o.ColumnArgs != nil {
+		c.ColumnArgs = make(map[string][]interface{}, len(o.ColumnArgs))
+		for k, args := range o.ColumnArgs {
+			c.ColumnArgs[k] = append([]interface{}(nil), args...)
+		}
+	}
+	if o.OrderBy != nil {
+		orderBy := *o.OrderBy
+		c.OrderBy = &orderBy
+	}
+	c.ThenBy = append([]OrderBy(nil), o.ThenBy...)
+	c.GroupBy = append([]string(nil), o.GroupBy...)
+	c.RelatedTo = append([]IModel(nil), o.RelatedTo...)
+	return &c
+}
+
+// Returns copy of options or new options if they are nil
+func cloneOptions(options *Options) *Options {
+	if options == nil {
+		return new(Options)
+	}
+	return options.Clone()
+}
+
+// CloneWithWhere is the same as WithWhere but modifies a copy of options
+func CloneWithWhere(options *Options, where Where) *Options {
+	return WithWhere(cloneOptions(options), where)
+}
+
+// CloneWithLimit is the same as WithLimit but modifies a copy of options
+func CloneWithLimit(options *Options, limit int) *Options {
+	return WithLimit(cloneOptions(options), limit)
+}
+
+// CloneWithOffset is the same as WithOffset but modifies a copy of options
+func CloneWithOffset(options *Options, offset int) *Options {
+	return WithOffset(cloneOptions(options), offset)
+}
+
+// CloneWithOrder is the same as WithOrder but modifies a copy of options
+func CloneWithOrder(options *Options, by OrderBy) *Options {
+	return WithOrder(cloneOptions(options), by)
+}
+
+// Executor is an interface of database handle used to run queries, it's
+// implemented by *sql.DB, *sql.Tx and *sql.Conn
+type Executor interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
 // Model is an interface that represents model of database
 type Model interface {
 	Table() string
@@ -143,6 +638,11 @@ type relationInfo struct {
 	FieldName   string
 	Condition   string
 	RefPkValue  interface{}
+	// column name of the relation field used as a key of RelationColumns
+	Column string
+	// column of related keys joined by concat relation or column of mapping
+	// table referring related model of many to many relation
+	Ref string
 }
 
 type columnInfo struct {
@@ -150,6 +650,7 @@ type columnInfo struct {
 	Name         string
 	Index        int
 	Primary      bool
+	Expression   bool
 }
 
 func isExportedField(f reflect.StructField) bool {
@@ -169,26 +670,68 @@ func lookForSettingWithSep(s, setting, sep string) string {
 	return ""
 }
 
-func getTempTableName(n int) string {
-	b := make([]byte, n)
-	// A src.Int63() generates 63 random bits, enough for letterIdxMax characters!
-	for i, cache, remain := n-1, src.Int63(), letterIdxMax; i >= 0; {
-		if remain == 0 {
-			cache, remain = src.Int63(), letterIdxMax
+func lookForSetting(s, setting string) string {
+	return lookForSettingWithSep(s, setting, "=")
+}
+
+// flagSettings are settings of the tag having no value
+var flagSettings = map[string]bool{
+	"autoincrement": true, "cascade": true, "concat": true, "default": true, "has_many": true,
+	"has_one": true, "ignore": true, "index": true, "many_to_many": true, "nocreate": true,
+	"notnull": true, "primary": true, "readonly": true, "softdelete": true, "unique": true,
+	"view": true, "writeonly": true,
+}
+
+// Returns value of setting listing several values separated by comma, since
+// settings are separated by comma too, items following the setting which
+// aren't settings themselves are parts of its value, e.g. field=a_id,b_id
+func lookForListSetting(s, setting string) string {
+	pairs := strings.Split(s, ",")
+	for i, pair := range pairs {
+		kvs := strings.SplitN(pair, "=", 2)
+		if len(kvs) != 2 || kvs[0] != setting {
+			continue
 		}
-		if idx := int(cache & letterIdxMask); idx < len(letterBytes) {
-			b[i] = letterBytes[idx]
-			i--
+		values := []string{kvs[1]}
+		for _, next := range pairs[i+1:] {
+			if strings.ContainsAny(next, "=:") || flagSettings[next] {
+				break
+			}
+			values = append(values, next)
 		}
-		cache >>= letterIdxBits
-		remain--
+		return strings.Join(values, ",")
 	}
+	return ""
+}
 
-	return *(*string)(unsafe.Pointer(&b))
+// valueSettings are settings of the tag having a value
+var valueSettings = map[string]bool{
+	"col": true, "condition": true, "default": true, "field": true, "index": true, "on_conflict": true,
+	"ref": true, "table": true, "time": true, "unique": true, "where": true,
 }
 
-func lookForSetting(s, setting string) string {
-	return lookForSettingWithSep(s, setting, "=")
+// Returns value of setting containing sql expression, since expressions may
+// contain commas, items following the setting are parts of its value unless
+// they start another setting outside of parentheses, e.g. on_conflict=coalesce(a, 0)
+func lookForExprSetting(s, setting string) string {
+	pairs := strings.Split(s, ",")
+	for i, pair := range pairs {
+		kvs := strings.SplitN(pair, "=", 2)
+		if len(kvs) != 2 || kvs[0] != setting {
+			continue
+		}
+		value := kvs[1]
+		for _, next := range pairs[i+1:] {
+			name := strings.SplitN(strings.SplitN(next, "=", 2)[0], ":", 2)[0]
+			open := strings.Count(value, "(") > strings.Count(value, ")")
+			if !open && (flagSettings[name] || valueSettings[name]) {
+				break
+			}
+			value += "," + next
+		}
+		return value
+	}
+	return ""
 }
 
 func getColumnInfo(t reflect.Type) ([]columnInfo, error) {
@@ -204,19 +747,22 @@ func getColumnInfo(t reflect.Type) ([]columnInfo, error) {
 		}
 
 		tag := t.Field(i).Tag.Get(packageTagName)
-		if tag == "-" {
+		if tag == "-" || lookForSetting(tag, "writeonly") != "" {
 			continue
 		}
 
 		var ci = columnInfo{Index: i}
 		if exp, ok := v.Elem().Field(i).Interface().(Expression); ok {
 			ci.Name = exp.Column()
+			ci.Expression = true
 		} else {
 			ci.Name = getFieldColumnName(t.Field(i))
 		}
 
 		if ri := extractRelationInfo(t.Field(i)); ri != nil {
 			ci.RelationInfo = *ri
+		} else if !isSupportedFieldType(t.Field(i).Type) {
+			return nil, unsupportedFieldError(t, t.Field(i))
 		} else {
 			ci.RelationInfo = relationInfo{Type: noRelation}
 		}
@@ -238,7 +784,14 @@ func extractRelationInfo(field reflect.StructField) *relationInfo {
 		return nil
 	}
 
-	if strings.Contains(t, "has_one") {
+	if lookForSetting(t, "concat") != "" && (lookForSetting(t, "has_many") != "" || lookForSetting(t, "many_to_many") != "") {
+		info.Type = concatRelation
+		info.RelatedType = field.Type
+		info.Table = lookForSetting(t, "table")
+		info.FieldName = lookForSetting(t, "field")
+		info.Ref = lookForSetting(t, "ref")
+		info.Condition = lookForSettingWithSep(t, "condition", ":")
+	} else if lookForSetting(t, "has_one") != "" {
 		info.Type = hasOne
 		info.RelatedType = field.Type
 		info.FieldName = getFieldColumnName(field)
@@ -251,135 +804,183 @@ func extractRelationInfo(field reflect.StructField) *relationInfo {
 		if info.RefPkValue == nil {
 			return nil // maybe we need to return an error here
 		}
-	} else if strings.Contains(t, "many_to_many") {
+	} else if lookForSetting(t, "many_to_many") != "" {
 		info.Type = manyToMany
 		info.RelatedType = field.Type.Elem()
 		tOption := lookForSetting(t, "table")
 		info.Condition = lookForSettingWithSep(t, "condition", ":")
 		info.Table = tOption
-		info.FieldName = lookForSetting(t, "field")
-	} else if strings.Contains(t, "has_many") {
+		info.FieldName = lookForListSetting(t, "field")
+		info.Ref = lookForSetting(t, "ref")
+	} else if lookForSetting(t, "has_many") != "" {
 		info.RelatedType = field.Type.Elem()
 		info.Type = hasMany
 	} else {
 		return nil
 	}
+	info.Column = getFieldColumnName(field)
 	return &info
 }
 
-func queryWithOptions(ctx context.Context, db *sql.DB, table string, columns []string, opts *Options, count *int) (*sql.Rows, error) {
-	var (
-		values    []interface{}
-		q         string
-		tableName = getTempTableName(tempTableNameLength)
-	)
-	q = fmt.Sprintf("select %s from %s", strings.Join(columns, ","), table)
-	if count != nil {
-		q = fmt.Sprintf("create temp table %s as ", tableName) + q
-	}
+// Builds select query with clauses described by options
+func buildSelectQuery(table string, columns []string, opts *Options) (string, []interface{}) {
+	q, values := buildFilteredQuery(table, columns, opts)
+	return q + orderLimitClause(opts), values
+}
+
+// Builds select query filtering rows as described by options but without
+// ordering and limits, so it's suitable for counting rows
+func buildFilteredQuery(table string, columns []string, opts *Options) (string, []interface{}) {
+	var values []interface{}
+	q := fmt.Sprintf("select %s from %s", strings.Join(columns, ","), querySource(table, opts))
 	if opts != nil {
+		// select list precedes all other clauses
+		values = append(values, opts.columnArgs...)
+		values = append(values, opts.fromArgs...)
 		if len(opts.joins) != 0 {
 			q += strings.Join(opts.joins, " ")
 		}
-		if opts.Where != nil && len(opts.Where) != 0 {
-			var keys []string
-			for k, v := range opts.Where {
-				if v != nil {
-					value := reflect.ValueOf(v)
-					switch value.Kind() {
-					case reflect.Slice:
-						if strings.Contains(k, ",") {
-							rowValueCount := len(strings.Split(k, ","))
-							for i := 0; i < value.Len()/rowValueCount; i++ {
-								keys = append(keys, fmt.Sprintf("(%s) = (%s)", k, strings.Trim(strings.Repeat("?,", rowValueCount), ",")))
-							}
-							opts.Divider = OR
-						} else {
-							count := value.Len()
-							if opts.Limit != 0 && opts.Limit < count {
-								count = opts.Limit
-							}
-							keys = append(keys, fmt.Sprintf("%s in (%s)", k, strings.Trim(strings.Repeat("?,", count), ",")))
-						}
-						for i := 0; i < value.Len(); i++ {
-							values = append(values, value.Index(i).Interface())
-						}
-					case reflect.String:
-						switch v.(type) {
-						case StrictString:
-							keys = append(keys, fmt.Sprintf("%s = ?", k))
-							values = append(values, v)
-						default:
-							keys = append(keys, fmt.Sprintf("%s like ?", k))
-							values = append(values, fmt.Sprintf("%%%s%%", v))
-						}
-					default:
-						switch v.(type) {
-						case Greater:
-							keys = append(keys, fmt.Sprintf("%s > ?", k))
-						case GreaterOrEqual:
-							keys = append(keys, fmt.Sprintf("%s >= ?", k))
-						case Less:
-							keys = append(keys, fmt.Sprintf("%s < ?", k))
-						case LessOrEqual:
-							keys = append(keys, fmt.Sprintf("%s <= ?", k))
-						case NotEqual:
-							keys = append(keys, fmt.Sprintf("%s != ?", k))
-						case BitwiseAND:
-							keys = append(keys, fmt.Sprintf("%s&? > 0", k))
-						case BitwiseANDStrict:
-							keys = append(keys, fmt.Sprintf("%s&? = ?", k))
-							values = append(values, v)
-						default:
-							keys = append(keys, fmt.Sprintf("%s = ?", k))
-						}
-						values = append(values, v)
-					}
-				} else {
-					keys = append(keys, fmt.Sprintf("%s is null", k))
-				}
-			}
-			if len(keys) > 0 {
-				q += fmt.Sprintf(" where %s", strings.Join(keys, opts.Divider))
+		keys, args := buildWhereConditions(opts, expressionAliases(columns))
+		where := strings.Join(keys, whereDivider(opts))
+		// filters of options are always glued with AND regardless of divider
+		filters := append([]string(nil), opts.filters...)
+		args = append(args, opts.filterArgs...)
+		if opts.softDelete != "" {
+			filters = append(filters, fmt.Sprintf("%s is null", opts.softDelete))
+		}
+		if len(filters) != 0 {
+			if where != "" {
+				where = fmt.Sprintf("(%s)%s", where, AND)
 			}
+			where += strings.Join(filters, AND)
 		}
-		if opts.OrderBy != nil {
-			q += fmt.Sprintf(" order by %s %s", opts.OrderBy.Field, opts.OrderBy.Order)
+		if where != "" {
+			q += fmt.Sprintf(" where %s", where)
+			values = append(values, args...)
 		}
-		if opts.Limit != 0 {
-			q += fmt.Sprintf(" limit %d", opts.Limit)
-			if opts.Offset != 0 {
-				q += fmt.Sprintf(" offset %d", opts.Offset)
-			}
+		q += groupByClause(opts, &values)
+	}
+	return q, values
+}
+
+// Returns source of rows to select from, it's either table or subquery
+// from options aliased with table name
+func querySource(table string, opts *Options) string {
+	if opts != nil && opts.From != "" {
+		return fmt.Sprintf("(%s) as %s", opts.From, table)
+	}
+	return table
+}
+
+// Builds query counting rows described by options, table is counted directly
+// if there is nothing to filter or group
+func buildCountQuery(table string, columns []string, opts *Options) (string, []interface{}) {
+	return buildCountQueryExpr(table, columns, opts, "count(*)")
+}
+
+// Builds query counting rows with given aggregate expression
+func buildCountQueryExpr(table string, columns []string, opts *Options, expr string) (string, []interface{}) {
+	if opts == nil || (len(opts.joins) == 0 && len(opts.Where) == 0 && len(opts.GroupBy) == 0 && len(opts.Having) == 0 &&
+		len(opts.filters) == 0 && opts.softDelete == "") {
+		var values []interface{}
+		if opts != nil {
+			values = opts.fromArgs
 		}
+		return fmt.Sprintf("select %s from %s", expr, querySource(table, opts)), values
 	}
-	if os.Getenv("ORMLITE_DEBUG") == "1" {
-		fmt.Println(q)
-		fmt.Println(values)
+	q, values := buildFilteredQuery(table, columns, opts)
+	return fmt.Sprintf("select %s from (%s)", expr, q), values
+}
+
+func orderLimitClause(opts *Options) string {
+	var clause string
+	if opts == nil {
+		return clause
 	}
-	if count != nil {
-		_, err := db.Exec(q, values...)
-		if err != nil {
-			return nil, &Error{errors.Wrap(err, "failed to get rows count from temp table"), q, []any{tableName}}
+	if opts.OrderBy != nil {
+		clause += fmt.Sprintf(" order by %s %s", qualifyKey(opts, opts.OrderBy.Field), opts.OrderBy.Order)
+		for _, o := range opts.ThenBy {
+			clause += fmt.Sprintf(", %s %s", qualifyKey(opts, o.Field), o.Order)
+		}
+	}
+	if opts.NoRows {
+		clause += " limit 0"
+		if opts.Offset != 0 {
+			clause += fmt.Sprintf(" offset %d", opts.Offset)
 		}
-		row := db.QueryRow(fmt.Sprintf("select count() from %s", tableName))
-		if err := row.Scan(count); err != nil {
-			return nil, &Error{errors.Wrap(err, "failed to execute count on a temp table"), "", []any{tableName}}
+	} else if opts.Limit != 0 {
+		clause += fmt.Sprintf(" limit %d", opts.Limit)
+		if opts.Offset != 0 {
+			clause += fmt.Sprintf(" offset %d", opts.Offset)
 		}
-		for i, colName := range columns {
-			if strings.HasPrefix(colName, table) {
-				columns[i] = colName[len(table)+1:]
-			}
+	} else if opts.Offset != 0 {
+		// sqlite requires limit clause to use offset, negative one means no limit
+		clause += fmt.Sprintf(" limit -1 offset %d", opts.Offset)
+	}
+	return clause
+}
+
+// Checks orderings of options
+func validateOrders(opts *Options) error {
+	if opts == nil {
+		return nil
+	}
+	if opts.OrderBy == nil {
+		if len(opts.ThenBy) != 0 {
+			return errors.New("ThenBy requires OrderBy to be set")
+		}
+		return nil
+	}
+	if err := opts.OrderBy.validate(); err != nil {
+		return err
+	}
+	for _, o := range opts.ThenBy {
+		if err := o.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Queries rows described by options, if count is not nil it's set to the
+// number of matching rows regardless of limit and offset
+func queryWithOptions(ctx context.Context, db Executor, table string, columns []string, opts *Options, count *int) (*tracedRows, error) {
+	if err := validateOrders(opts); err != nil {
+		return nil, err
+	}
+	if count != nil {
+		cq, cv := buildCountQuery(table, columns, opts)
+		debugQuery(cq, cv)
+		if err := queryRowScan(ctx, db, cq, cv, count); err != nil {
+			return nil, &Error{errors.Wrap(err, "failed to count rows"), cq, cv}
 		}
-		q = fmt.Sprintf("select %s from %s", strings.Join(columns, ","), tableName)
 	}
-	rows, err := db.QueryContext(ctx, q, values...)
+	q, values := buildSelectQuery(table, columns, opts)
+	debugQuery(q, values)
+	rows, err := queryContext(ctx, db, q, values...)
 	if err != nil {
 		return nil, &Error{err, q, values}
 	}
 	return rows, nil
 }
 
+// Renders group by and having clauses adding having arguments to args
+func groupByClause(opts *Options, args *[]interface{}) string {
+	if len(opts.GroupBy) == 0 {
+		return ""
+	}
+	groupBy := make([]string, len(opts.GroupBy))
+	for i, g := range opts.GroupBy {
+		groupBy[i] = qualifyKey(opts, g)
+	}
+	clause := " group by " + strings.Join(groupBy, ",")
+	if keys, having := buildHavingConditions(opts); len(keys) > 0 {
+		clause += " having " + strings.Join(keys, AND)
+		*args = append(*args, having...)
+	}
+	return clause
+}
+
 func getPrimaryFieldsInfo(value reflect.Value) ([]pkFieldInfo, error) {
 	var pkFields []pkFieldInfo
 	for k := 0; k < value.NumField(); k++ {
@@ -387,7 +988,12 @@ func getPrimaryFieldsInfo(value reflect.Value) ([]pkFieldInfo, error) {
 		ft := value.Type().Field(k)
 		if lookForSetting(ft.Tag.Get(packageTagName), "primary") == "primary" {
 			var info pkFieldInfo
-			info.name = getFieldColumnName(ft)
+			if exp, ok := fv.Interface().(Expression); ok {
+				// expression keys are compared using expression itself
+				info.name = expressionSQL(exp)
+			} else {
+				info.name = getFieldColumnName(ft)
+			}
 			info.field = fv
 			info.relationName = lookForSetting(ft.Tag.Get(packageTagName), "ref")
 			pkFields = append(pkFields, info)
@@ -396,11 +1002,42 @@ func getPrimaryFieldsInfo(value reflect.Value) ([]pkFieldInfo, error) {
 	return pkFields, nil
 }
 
-func loadRelationsForSlice(ctx context.Context, db *sql.DB, opts *Options, slicePtr reflect.Value, colInfoPerEntry [][]columnInfo) error {
+func checkRelationDepth(opts *Options) error {
+	// negative depth keeps decreasing on every level, so it's limited as well
+	if opts != nil && (opts.RelationDepth > MaxRelationDepth || opts.RelationDepth < -MaxRelationDepth) {
+		return errors.Wrapf(ErrRelationDepthExceeded, "depth %d, max %d", opts.RelationDepth, MaxRelationDepth)
+	}
+	return nil
+}
+
+// IsRelationDepthExceeded checks if error was caused by relation depth bigger than MaxRelationDepth
+func IsRelationDepthExceeded(err error) bool {
+	return errors.Cause(err) == ErrRelationDepthExceeded
+}
+
+func loadRelationsForSlice(ctx context.Context, db Executor, opts *Options, slicePtr reflect.Value, colInfoPerEntry [][]columnInfo) error {
+	if err := checkRelationDepth(opts); err != nil {
+		return err
+	}
 	if opts != nil && opts.RelationDepth != 0 {
+		// has many relations are loaded for all entries at once unless limit
+		// is set, since it restricts number of related models per entry
+		batched := make(map[int]bool)
+		if slicePtr.Len() != 0 && opts.Limit <= 0 {
+			for _, ci := range colInfoPerEntry[0] {
+				if ci.RelationInfo.Type != hasMany {
+					continue
+				}
+				ok, err := loadHasManyRelationBatch(ctx, db, slicePtr, ci.Index, opts)
+				if err != nil {
+					return err
+				}
+				batched[ci.Index] = ok
+			}
+		}
 		for i := 0; i < slicePtr.Len(); i++ {
 			for _, ci := range colInfoPerEntry[i] {
-				if ci.RelationInfo.Type != noRelation {
+				if ci.RelationInfo.Type != noRelation && !batched[ci.Index] {
 					var modelValue = slicePtr.Index(i).Elem()
 
 					switch ci.RelationInfo.Type {
@@ -424,6 +1061,14 @@ func loadRelationsForSlice(ctx context.Context, db *sql.DB, opts *Options, slice
 						if err := loadManyToManyRelation(ctx, db, &ci.RelationInfo, modelValue.Field(ci.Index), pkFields, opts); err != nil {
 							return err
 						}
+					case concatRelation:
+						pkFields, err := getPrimaryFieldsInfo(modelValue)
+						if err != nil {
+							return err
+						}
+						if err := loadConcatRelation(ctx, db, &ci.RelationInfo, modelValue.Field(ci.Index), pkFields); err != nil {
+							return err
+						}
 					}
 				}
 			}
@@ -432,8 +1077,11 @@ func loadRelationsForSlice(ctx context.Context, db *sql.DB, opts *Options, slice
 	return nil
 }
 
-func loadStructRelations(ctx context.Context, db *sql.DB, opts *Options, out Model, pkField []pkFieldInfo, relations map[*relationInfo]reflect.Value) error {
-	if opts == nil || opts.RelationDepth != 0 {
+func loadStructRelations(ctx context.Context, db Executor, opts *Options, out Model, pkField []pkFieldInfo, relations map[*relationInfo]reflect.Value) error {
+	if err := checkRelationDepth(opts); err != nil {
+		return err
+	}
+	if opts != nil && opts.RelationDepth != 0 {
 		for ri, rv := range relations {
 			if ri.Type == manyToMany {
 				if err := loadManyToManyRelation(ctx, db, ri, rv, pkField, opts); err != nil {
@@ -447,13 +1095,20 @@ func loadStructRelations(ctx context.Context, db *sql.DB, opts *Options, out Mod
 				if err := loadHasManyRelation(ctx, db, *ri, rv, pkField, reflect.TypeOf(out), opts); err != nil {
 					return err
 				}
+			} else if ri.Type == concatRelation {
+				if err := loadConcatRelation(ctx, db, ri, rv, pkField); err != nil {
+					return err
+				}
 			}
 		}
 	}
 	return nil
 }
 
-func loadHasManyRelation(ctx context.Context, db *sql.DB, ri relationInfo, fieldValue reflect.Value, pkFields []pkFieldInfo, parentType reflect.Type, options *Options) error {
+func loadHasManyRelation(ctx context.Context, db Executor, ri relationInfo, fieldValue reflect.Value, pkFields []pkFieldInfo, parentType reflect.Type, options *Options) (err error) {
+	ctx, finish := startSpan(ctx, "load "+ri.Column, "")
+	defer func() { finish(err) }()
+
 	if fieldValue.Kind() != reflect.Slice {
 		return fmt.Errorf("can't load relations: wrong field type: %v", fieldValue.Type())
 	}
@@ -480,15 +1135,172 @@ func loadHasManyRelation(ctx context.Context, db *sql.DB, ri relationInfo, field
 		return errors.New("failed to load has many relation since none fields of related type meet parent type")
 	}
 
-	return QuerySliceContext(ctx, db, WithWhere(&Options{RelationDepth: options.RelationDepth - 1, Limit: options.Limit, Divider: OR},
-		where), fieldValue.Addr().Interface())
+	orderBy, thenBy := relationOrder(options, ri.Column, rve)
+	return QuerySliceContext(ctx, db, WithWhere(&Options{RelationDepth: options.RelationDepth - 1, depthSet: true, Limit: options.Limit, Divider: OR,
+		WithTrashed: options.WithTrashed, Columns: options.RelationColumns[ri.Column], scope: options.RelationWhere[ri.Column],
+		OrderBy: orderBy, ThenBy: thenBy}, where),
+		fieldValue.Addr().Interface())
 }
 
-func loadHasOneRelation(ctx context.Context, db *sql.DB, ri *relationInfo, rv reflect.Value, options *Options) error {
-	if ri.RefPkValue == nil {
-		return nil
-	}
-
+// Returns ordering of models loaded into relation field with given column
+// name, models are ordered by primary key unless RelationOrder is set, so
+// loaded slices are stable
+func relationOrder(options *Options, relation string, related reflect.Type) (*OrderBy, []OrderBy) {
+	orders := options.RelationOrder[relation]
+	if len(orders) == 0 {
+		for i := 0; i < related.NumField(); i++ {
+			f := related.Field(i)
+			if !isExportedField(f) || lookForSetting(f.Tag.Get(packageTagName), "primary") != "primary" {
+				continue
+			}
+			column := getFieldColumnName(f)
+			if !orderFieldPattern.MatchString(column) {
+				// e.g. primary expression columns
+				return nil, nil
+			}
+			orders = append(orders, OrderBy{Field: column})
+		}
+	}
+	if len(orders) == 0 {
+		return nil, nil
+	}
+	orderBy := orders[0]
+	return &orderBy, append([]OrderBy(nil), orders[1:]...)
+}
+
+// maxBatchKeys limits number of parent keys bound to a single has many query
+// to stay within sqlite variables limit
+const maxBatchKeys = 500
+
+// maxQueryArgs is the default sqlite limit of variables bound to a query
+const maxQueryArgs = 999
+
+// Loads has many relation stored in field with given index for all models of
+// the slice using one query per maxBatchKeys parents, returns false if models
+// can't be loaded this way (e.g. they have compound primary key)
+func loadHasManyRelationBatch(ctx context.Context, db Executor, slicePtr reflect.Value, index int, options *Options) (_ bool, err error) {
+	var (
+		parentType = slicePtr.Type().Elem()
+		fieldType  = parentType.Elem().Field(index).Type
+	)
+	ctx, finish := startSpan(ctx, "load "+getFieldColumnName(parentType.Elem().Field(index)), "")
+	defer func() { finish(err) }()
+
+	if fieldType.Kind() != reflect.Slice {
+		return false, fmt.Errorf("can't load relations: wrong field type: %v", fieldType)
+	}
+	rvt := fieldType.Elem()
+	if rvt.Kind() != reflect.Ptr {
+		return false, fmt.Errorf("can't load relations: wrong field type: %v", rvt)
+	}
+	rve := rvt.Elem()
+	if rve.Kind() != reflect.Struct {
+		return false, fmt.Errorf("can't load relations: wrong field type: %v", rve)
+	}
+
+	fkColumns := make(map[string]bool)
+	for i := 0; i < rve.NumField(); i++ {
+		if f := rve.Field(i); f.Type.AssignableTo(parentType) {
+			fkColumns[getFieldColumnName(f)] = true
+		}
+	}
+	if len(fkColumns) == 0 {
+		return false, errors.New("failed to load has many relation since none fields of related type meet parent type")
+	}
+
+	// keys have to be selected to match models with their parents
+	var (
+		relation = getFieldColumnName(parentType.Elem().Field(index))
+		columns  map[string]struct{}
+	)
+	if selected, ok := options.RelationColumns[relation]; ok {
+		columns = make(map[string]struct{}, len(selected)+len(fkColumns))
+		for c := range selected {
+			columns[c] = struct{}{}
+		}
+		for c := range fkColumns {
+			columns[c] = struct{}{}
+		}
+	}
+
+	var (
+		keys    []interface{}
+		parents = make(map[string][]int)
+	)
+	for i := 0; i < slicePtr.Len(); i++ {
+		pkFields, err := getPrimaryFieldsInfo(slicePtr.Index(i).Elem())
+		if err != nil {
+			return false, err
+		}
+		if len(pkFields) != 1 {
+			return false, nil
+		}
+		pk := pkFields[0].field.Interface()
+		key := fmt.Sprint(pk)
+		if _, ok := parents[key]; !ok {
+			keys = append(keys, pk)
+		}
+		parents[key] = append(parents[key], i)
+	}
+
+	for len(keys) != 0 {
+		chunk := keys
+		if len(chunk) > maxBatchKeys {
+			chunk = chunk[:maxBatchKeys]
+		}
+		keys = keys[len(chunk):]
+
+		where := Where{}
+		for c := range fkColumns {
+			where[c] = chunk
+		}
+		opts := &Options{RelationDepth: options.RelationDepth - 1, depthSet: true, Divider: OR, Where: where, WithTrashed: options.WithTrashed,
+			Columns: columns, scope: options.RelationWhere[relation]}
+		opts.OrderBy, opts.ThenBy = relationOrder(options, relation, rve)
+
+		related := reflect.New(fieldType).Elem()
+		colInfoPerEntry, err := querySliceRows(ctx, db, opts, related, nil)
+		if err != nil {
+			return false, err
+		}
+
+		for j := 0; j < related.Len(); j++ {
+			// model may refer the same parent by several fields
+			appended := make(map[int]bool)
+			for _, ci := range colInfoPerEntry[j] {
+				if ci.RelationInfo.Type != hasOne || ci.RelationInfo.RefPkValue == nil ||
+					!fkColumns[ci.Name] {
+					continue
+				}
+				for _, p := range parents[fmt.Sprint(ci.RelationInfo.RefPkValue)] {
+					if appended[p] {
+						continue
+					}
+					appended[p] = true
+					fv := slicePtr.Index(p).Elem().Field(index)
+					fv.Set(reflect.Append(fv, related.Index(j)))
+				}
+			}
+		}
+
+		if err := loadRelationsForSlice(ctx, db, opts, related, colInfoPerEntry); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func loadHasOneRelation(ctx context.Context, db Executor, ri *relationInfo, rv reflect.Value, options *Options) (err error) {
+	if ri.RefPkValue == nil {
+		return nil
+	}
+	ctx, finish := startSpan(ctx, "load "+ri.Column, "")
+	defer func() { finish(err) }()
+
+	if err := checkRelationDepth(options); err != nil {
+		return err
+	}
+
 	_, ok := rv.Interface().(Model)
 	if !ok {
 		return fmt.Errorf("incorrect field value of one_to_one relation, expected ormlite.Model")
@@ -508,6 +1320,9 @@ func loadHasOneRelation(ctx context.Context, db *sql.DB, ri *relationInfo, rv re
 	}
 	if err := QueryStructContext(ctx, db, WithWhere(&Options{
 		RelationDepth: options.RelationDepth - 1,
+		depthSet:      true,
+		WithTrashed:   options.WithTrashed,
+		Columns:       options.RelationColumns[ri.Column],
 	}, Where{refPkField: ri.RefPkValue}), refObj.Interface().(Model)); err != nil {
 		return err
 	}
@@ -515,7 +1330,10 @@ func loadHasOneRelation(ctx context.Context, db *sql.DB, ri *relationInfo, rv re
 	return nil
 }
 
-func loadManyToManyRelation(ctx context.Context, db *sql.DB, ri *relationInfo, rv reflect.Value, pkFields []pkFieldInfo, options *Options) error {
+func loadManyToManyRelation(ctx context.Context, db Executor, ri *relationInfo, rv reflect.Value, pkFields []pkFieldInfo, options *Options) (err error) {
+	ctx, finish := startSpan(ctx, "load "+ri.Column, "")
+	defer func() { finish(err) }()
+
 	var (
 		refPkField, PkField, where []string
 		args                       []interface{}
@@ -548,17 +1366,17 @@ func loadManyToManyRelation(ctx context.Context, db *sql.DB, ri *relationInfo, r
 		return errors.New("can't load relations: related struct does not have primary key")
 	}
 
+	refPkField, err = getRelatedMappingColumns(ri, refPkField)
+	if err != nil {
+		return err
+	}
+	mappingColumns, err := getMappingColumns(ri, pkFields)
+	if err != nil {
+		return err
+	}
 	for i, pkField := range pkFields {
-		fNames := strings.Split(ri.FieldName, ",")
-		if ri.FieldName != "" {
-			if len(fNames) != len(pkFields) {
-				return errors.New("field count does not match count of primary fields")
-			}
-			where = append(where, fmt.Sprintf("%s = ?", fNames[i]))
-		} else {
-			where = append(where, fmt.Sprintf("%s = ?", pkField.relationName))
-		}
-		args = append(args, pkFields[0].field.Interface())
+		where = append(where, fmt.Sprintf("%s = ?", mappingColumns[i]))
+		args = append(args, pkField.field.Interface())
 	}
 
 	if ri.Condition != "" {
@@ -571,57 +1389,127 @@ func loadManyToManyRelation(ctx context.Context, db *sql.DB, ri *relationInfo, r
 	}
 
 	query := fmt.Sprintf("select %s from %s%s", strings.Join(refPkField, ","), ri.Table, whereClause)
-	rows, err := db.QueryContext(ctx, query, args...)
+	rows, err := queryContext(ctx, db, query, args...)
 	if err != nil {
 		return &Error{err, query, args}
 	}
+	defer rows.Close()
 
+	// each mapping row refers a single related model, so values of compound
+	// keys are bound as tuples to keep their parts paired
+	var (
+		keys   []interface{}
+		tuples InTuples
+	)
 	for rows.Next() {
-		var relatedPrimaryKeyValues []interface{}
-		for i := 0; i < len(PkField); i++ {
-			var relatedPk interface{}
-			relatedPrimaryKeyValues = append(relatedPrimaryKeyValues, &relatedPk)
+		var (
+			relatedPrimaryKeyValues = make([]interface{}, len(PkField))
+			dest                    = make([]interface{}, len(PkField))
+		)
+		for i := range dest {
+			dest[i] = &relatedPrimaryKeyValues[i]
 		}
-		if err := rows.Scan(relatedPrimaryKeyValues...); err != nil {
+		if err := rows.Scan(dest...); err != nil {
 			return err
 		}
-		if _, ok := relatedQueryConditions[strings.Join(PkField, ",")]; !ok {
-			relatedQueryConditions[strings.Join(PkField, ",")] = relatedPrimaryKeyValues
+		if len(PkField) == 1 {
+			keys = append(keys, relatedPrimaryKeyValues[0])
 		} else {
-			relatedQueryConditions[strings.Join(PkField, ",")] = append(
-				relatedQueryConditions[strings.Join(PkField, ",")].([]interface{}), relatedPrimaryKeyValues...)
+			tuples = append(tuples, relatedPrimaryKeyValues)
 		}
 	}
+	if err := rows.Err(); err != nil {
+		return &Error{err, query, args}
+	}
+	switch {
+	case len(keys) != 0:
+		relatedQueryConditions[PkField[0]] = keys
+	case len(tuples) != 0:
+		relatedQueryConditions[strings.Join(PkField, ",")] = tuples
+	}
 	if len(relatedQueryConditions) == 0 {
 		return nil // query has no rows so there is no need to load any model
 	}
+	orderBy, thenBy := relationOrder(options, ri.Column, rve)
 	return QuerySliceContext(
 		ctx, db, WithWhere(&Options{
-			RelationDepth: options.RelationDepth - 1, Divider: options.Divider, Limit: options.Limit},
-			relatedQueryConditions),
+			RelationDepth: options.RelationDepth - 1, depthSet: true, Divider: options.Divider, Limit: options.Limit,
+			WithTrashed: options.WithTrashed, Columns: options.RelationColumns[ri.Column],
+			scope: options.RelationWhere[ri.Column], OrderBy: orderBy, ThenBy: thenBy}, relatedQueryConditions),
 		rv.Addr().Interface(),
 	)
 }
 
+// Loads keys of related models joined by group_concat into string field or
+// slice of keys without querying related models themselves
+func loadConcatRelation(ctx context.Context, db Executor, ri *relationInfo, rv reflect.Value, pkFields []pkFieldInfo) (err error) {
+	ctx, finish := startSpan(ctx, "load "+ri.Column, "")
+	defer func() { finish(err) }()
+
+	if ri.Table == "" || ri.FieldName == "" || ri.Ref == "" {
+		return errors.New("concat relation requires table, field and ref settings")
+	}
+	if len(pkFields) != 1 {
+		return errors.New("concat relation requires model with single primary key")
+	}
+	var (
+		where = []string{fmt.Sprintf("%s = ?", ri.FieldName)}
+		args  = []interface{}{pkFields[0].field.Interface()}
+	)
+	if ri.Condition != "" {
+		where = append(where, ri.Condition)
+	}
+	// keys are ordered in subquery, since group_concat doesn't guarantee it
+	query := fmt.Sprintf("select group_concat(%[1]s) from (select %[1]s from %[2]s where %[3]s order by %[1]s)",
+		ri.Ref, ri.Table, strings.Join(where, AND))
+	debugQuery(query, args)
+	var keys sql.NullString
+	if err := queryRowScan(ctx, db, query, args, &keys); err != nil {
+		return &Error{err, query, args}
+	}
+
+	switch rv.Kind() {
+	case reflect.String:
+		rv.SetString(keys.String)
+	case reflect.Slice:
+		rv.Set(reflect.Zero(rv.Type()))
+		if keys.String == "" {
+			return nil
+		}
+		for _, k := range strings.Split(keys.String, ",") {
+			key := reflect.New(rv.Type().Elem()).Elem()
+			if err := setFieldValue(key, k); err != nil {
+				return errors.Wrapf(err, "can't convert key of %s relation", ri.Column)
+			}
+			rv.Set(reflect.Append(rv, key))
+		}
+	default:
+		return errors.Errorf("concat relation requires string or slice field, got %v", rv.Type())
+	}
+	return nil
+}
+
 // QueryStruct looks up for rows in given table and scans it to provided struct or slice of structs
-func QueryStruct(db *sql.DB, opts *Options, out Model) error {
+func QueryStruct(db Executor, opts *Options, out Model) error {
 	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
 	defer cancel()
 	return QueryStructContext(ctx, db, opts, out)
 }
 
 // QueryStructContext looks up for rows in given table and scans it to provided struct or slice of structs
-func QueryStructContext(ctx context.Context, db *sql.DB, opts *Options, out Model) error {
+func QueryStructContext(ctx context.Context, db Executor, opts *Options, out Model) error {
 	model := reflect.ValueOf(out).Elem()
 	if model.Type().Kind() != reflect.Struct {
 		return fmt.Errorf("expected pointer to struct, got %T", model.Type())
 	}
+	opts = withDefaultRelationDepth(opts)
 
 	var (
-		pkFields  []pkFieldInfo
-		columns   []string
-		fieldPTRs []interface{}
-		relations = make(map[*relationInfo]reflect.Value)
+		pkFields   []pkFieldInfo
+		columns    []string
+		columnArgs []interface{}
+		fieldPTRs  []interface{}
+		relations  = make(map[*relationInfo]reflect.Value)
 	)
 
 	pkFields, err := getPrimaryFieldsInfo(model)
@@ -636,7 +1524,7 @@ func QueryStructContext(ctx context.Context, db *sql.DB, opts *Options, out Mode
 		}
 
 		tag := model.Type().Field(i).Tag.Get(packageTagName)
-		if tag == "-" {
+		if tag == "-" || lookForSetting(tag, "writeonly") != "" {
 			continue
 		}
 
@@ -662,13 +1550,22 @@ func QueryStructContext(ctx context.Context, db *sql.DB, opts *Options, out Mode
 		}
 		if exp, ok := model.Field(i).Interface().(Expression); ok {
 			columns = append(columns, exp.Column())
+			columnArgs = append(columnArgs, columnExpressionArgs(opts, exp.Column(), model.Field(i))...)
 		} else {
 			columns = append(columns, getFieldColumnName(model.Type().Field(i)))
 		}
-		fieldPTRs = append(fieldPTRs, model.Field(i).Addr().Interface())
+		fieldPTRs = append(fieldPTRs, scanDest(model.Field(i)))
 	}
 
-	if len(columns) == 0 && len(relations) != 0 {
+	table := out.Table()
+	if opts != nil && opts.Table != "" {
+		if err := validateTableName(opts.Table); err != nil {
+			return err
+		}
+		table = opts.Table
+	}
+	if table == "" || len(columns) == 0 && len(relations) != 0 {
+		// model without table is backed by relations only
 		goto Relations
 	}
 
@@ -684,87 +1581,320 @@ func QueryStructContext(ctx context.Context, db *sql.DB, opts *Options, out Mode
 				}
 			}
 		}
-		rows, err := queryWithOptions(ctx, db, out.Table(), columns, opts, nil)
+		mInfo, err := getModelInfo(out)
 		if err != nil {
 			return err
 		}
+		mInfo.table = table
+		// relations are loaded with original options, since nil ones differ from empty
+		queryOpts, err := prepareModelQuery(mInfo, opts)
+		if err != nil {
+			return err
+		}
+		queryOpts = withColumnArgs(queryOpts, columnArgs)
+		onMultiple := FirstRow
+		if opts != nil {
+			onMultiple = opts.OnMultiple
+		}
+		rows, err := queryWithOptions(ctx, db, table, columns, structQueryLimit(queryOpts, onMultiple), nil)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
 
+		resultColumns, err := rows.Columns()
+		if err != nil {
+			return err
+		}
+		names := make([]string, len(columns))
+		for i, c := range columns {
+			names[i] = resultColumnName(c)
+		}
+		fieldPTRs = alignScanDest(resultColumns, names, fieldPTRs)
+		var scanned int
 		for rows.Next() {
+			if scanned++; scanned > 1 && onMultiple == ErrorOnMultiple {
+				return ErrMultipleRows
+			}
 			if err := rows.Scan(fieldPTRs...); err != nil {
 				return err
 			}
 		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
 	}
 
 Relations:
 	return loadStructRelations(ctx, db, opts, out, pkFields, relations)
 }
 
+// Returns options of QueryStruct query limited to the rows needed to handle
+// multiple matching rows, given options are not modified
+func structQueryLimit(opts *Options, onMultiple MultipleRows) *Options {
+	var limit int
+	switch onMultiple {
+	case FirstRow:
+		limit = 1
+	case ErrorOnMultiple:
+		limit = 2
+	default:
+		return opts
+	}
+	if opts == nil {
+		return &Options{Limit: limit}
+	}
+	if opts.NoRows || opts.Limit > 0 && opts.Limit <= limit {
+		return opts
+	}
+	limited := *opts
+	limited.Limit = limit
+	return &limited
+}
+
 // QuerySlice scans rows into the slice of structs
-func QuerySlice(db *sql.DB, opts *Options, out interface{}) error {
+func QuerySlice(db Executor, opts *Options, out interface{}) error {
 	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
 	defer cancel()
 	return QuerySliceContext(ctx, db, opts, out)
 }
 
 // QuerySliceCount scans rows into the slice of structs also returning count of matched rows
-func QuerySliceCount(db *sql.DB, opts *Options, out any, count *int) error {
+func QuerySliceCount(db Executor, opts *Options, out any, count *int) error {
 	return QuerySliceCountContext(context.Background(), db, opts, out, count)
 }
 
 // QuerySliceContext scans rows into the slice of structs with given context
-func QuerySliceContext(ctx context.Context, db *sql.DB, opts *Options, out any) error {
+func QuerySliceContext(ctx context.Context, db Executor, opts *Options, out any) error {
 	return QuerySliceCountContext(ctx, db, opts, out, nil)
 }
 
-// QuerySliceCountContext scans rows into the slice of structs with given context and also returning count of matched rows
-func QuerySliceCountContext(ctx context.Context, db *sql.DB, opts *Options, out any, count *int) error {
-
+// QuerySliceCountContext scans rows into the slice of structs with given context and also returning count of matched rows.
+// Slice may contain pointers to models or models themselves.
+// Count and rows are read by separate statements without any temporary state, so cancelled context just fails
+// the statement being run.
+func QuerySliceCountContext(ctx context.Context, db Executor, opts *Options, out any, count *int) error {
+	opts = withDefaultRelationDepth(opts)
 	slicePtr := reflect.ValueOf(out).Elem()
+	if elemType := slicePtr.Type().Elem(); elemType.Kind() == reflect.Struct &&
+		reflect.PtrTo(elemType).Implements(reflect.TypeOf((*Model)(nil)).Elem()) {
+		// models are scanned and their relations are loaded through pointers,
+		// since relation fields have to be addressable, then they are copied
+		pointers := reflect.New(reflect.SliceOf(reflect.PtrTo(elemType)))
+		if err := QuerySliceCountContext(ctx, db, opts, pointers.Interface(), count); err != nil {
+			return err
+		}
+		growSlice(slicePtr, pointers.Elem().Len())
+		for i := 0; i < pointers.Elem().Len(); i++ {
+			slicePtr.Set(reflect.Append(slicePtr, pointers.Elem().Index(i).Elem()))
+		}
+		return nil
+	}
 	if !slicePtr.Type().Elem().Implements(reflect.TypeOf((*Model)(nil)).Elem()) {
 		return errors.New("slice contain type that does not implement Model interface")
 	}
 
-	modelInfo, err := getModelInfo(reflect.New(slicePtr.Type().Elem().Elem()).Interface())
+	if opts != nil && opts.Assembler != nil {
+		var (
+			entries         []reflect.Value
+			colInfoPerEntry [][]columnInfo
+		)
+		err := queryAssembledRows(ctx, db, opts, count, func(entry reflect.Value, colInfo []columnInfo) error {
+			if !entry.Type().AssignableTo(slicePtr.Type().Elem()) {
+				return errors.Errorf("assembled model %v can't be stored in %v", entry.Type(), slicePtr.Type())
+			}
+			slicePtr.Set(reflect.Append(slicePtr, entry))
+			entries = append(entries, entry)
+			colInfoPerEntry = append(colInfoPerEntry, colInfo)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return loadAssembledRelations(ctx, db, opts, entries, colInfoPerEntry)
+	}
+
+	colInfoPerEntry, err := querySliceRows(ctx, db, opts, slicePtr, count)
 	if err != nil {
-		return errors.New("slice contain type that does not implement Model interface")
+		return err
+	}
+
+	return loadRelationsForSlice(ctx, db, opts, slicePtr, colInfoPerEntry)
+}
 
+// Queries rows of the slice element model appending them to the slice,
+// relations are not loaded but keys of has one relations are returned
+// in column info of each entry
+func querySliceRows(ctx context.Context, db Executor, opts *Options, slicePtr reflect.Value, count *int) ([][]columnInfo, error) {
+	modelInfo, err := getModelInfo(reflect.New(slicePtr.Type().Elem().Elem()).Interface())
+	if err != nil {
+		return nil, err
+	}
+	if err := overrideTable(modelInfo, opts); err != nil {
+		return nil, err
+	}
+	if modelInfo.table == "" {
+		return nil, ErrNoTable
 	}
 
 	var (
-		modelType       = slicePtr.Type().Elem().Elem()
-		colNames        []string
-		colInfoPerEntry [][]columnInfo
+		modelType = slicePtr.Type().Elem().Elem()
+		colNames  []string
 	)
 
 	colInfo, err := getColumnInfo(modelType)
 	if err != nil {
-		return fmt.Errorf("failed to get column info for type: %v", modelType)
+		return nil, errors.Wrapf(err, "failed to get column info for type: %v", modelType)
 	}
 
-	if opts != nil && opts.Columns != nil {
-		var selected []columnInfo
+	colInfo, colNames = selectColumns(modelInfo, colInfo, opts)
+
+	if opts, err = prepareQuery(modelInfo, colInfo, opts); err != nil {
+		return nil, err
+	}
+	opts = withColumnArgs(opts, expressionArgs(reflect.New(modelType).Elem(), colInfo, opts))
+
+	joined, joinedColumns, err := buildJoinedRelations(modelInfo, colInfo, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(joined) != 0 {
+		// columns of joined tables may have the same names
+		var selected int
 		for _, ci := range colInfo {
-			if _, ok := opts.Columns[ci.Name]; ok || ci.Primary {
-				selected = append(selected, ci)
+			if ci.RelationInfo.Type != noRelation && ci.RelationInfo.Type != hasOne {
+				continue
+			}
+			if !ci.Expression {
+				colNames[selected] = fmt.Sprintf("%s.%s", modelInfo.table, ci.Name)
 			}
+			selected++
 		}
-		colInfo = selected
+		colNames = append(colNames, joinedColumns...)
 	}
 
-	for _, ci := range colInfo {
-		if ci.RelationInfo.Type == noRelation || ci.RelationInfo.Type == hasOne {
-			if ci.Primary {
-				colNames = append(colNames, fmt.Sprintf("%s.%s", modelInfo.table, ci.Name))
-			} else {
-				colNames = append(colNames, ci.Name)
+	rows, err := queryWithOptions(ctx, db, modelInfo.table, colNames, opts, count)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if count != nil {
+		// rows are counted before they are read, so slice is grown once
+		growSlice(slicePtr, countedRows(*count, opts))
+	}
+
+	return scanSliceRows(rows, slicePtr, modelType, colInfo, joined...)
+}
+
+// Returns number of rows returned by query with options if count of rows
+// matching them is known
+func countedRows(count int, opts *Options) int {
+	if opts == nil {
+		return count
+	}
+	if opts.NoRows {
+		return 0
+	}
+	if count -= opts.Offset; opts.Limit > 0 && count > opts.Limit {
+		count = opts.Limit
+	}
+	return count
+}
+
+// Grows capacity of the slice to fit n more elements
+func growSlice(slice reflect.Value, n int) {
+	if n <= 0 || slice.Cap()-slice.Len() >= n {
+		return
+	}
+	grown := reflect.MakeSlice(slice.Type(), slice.Len(), slice.Len()+n)
+	reflect.Copy(grown, slice)
+	slice.Set(grown)
+}
+
+// Adds conditions to options filtering models having related ones listed in
+// Exists option, subqueries are used instead of joins, so rows are not duplicated
+func buildExistsConditions(mInfo *modelInfo, colInfo []columnInfo, opts *Options) error {
+	if len(opts.Exists) == 0 {
+		return nil
+	}
+	var pkColumn string
+	for _, f := range mInfo.fields {
+		if isPkField(f) {
+			if pkColumn != "" {
+				return errors.New("exists filter requires model with single primary key")
+			}
+			pkColumn = f.column
+		}
+	}
+	if pkColumn == "" {
+		return errors.New("exists filter requires model with primary key")
+	}
+
+	// relations are sorted to render the same query for the same options
+	relations := make([]string, 0, len(opts.Exists))
+	for name := range opts.Exists {
+		relations = append(relations, name)
+	}
+	sort.Strings(relations)
+
+	for _, name := range relations {
+		var relation *columnInfo
+		for i, ci := range colInfo {
+			if ci.Name == name && ci.RelationInfo.Type == hasMany {
+				relation = &colInfo[i]
+			}
+		}
+		if relation == nil {
+			return errors.Errorf("model %s does not have has many relation %s", mInfo.table, name)
+		}
+
+		relatedType := relation.RelationInfo.RelatedType.Elem()
+		relInfo, err := getModelInfo(reflect.New(relatedType).Interface())
+		if err != nil {
+			return errors.Wrap(err, "can't filter by related models")
+		}
+
+		var keys []string
+		for i := 0; i < relatedType.NumField(); i++ {
+			if f := relatedType.Field(i); f.Type.AssignableTo(mInfo.value.Addr().Type()) {
+				keys = append(keys, fmt.Sprintf("%s.%s = %s.%s", relInfo.table, getFieldColumnName(f), mInfo.table, pkColumn))
 			}
 		}
+		if len(keys) == 0 {
+			return errors.Errorf("related model %s does not refer %s", relInfo.table, mInfo.table)
+		}
+
+		where := opts.Exists[name]
+		if err := validateColumnConditions(relInfo, where); err != nil {
+			return err
+		}
+		relOpts := &Options{timeStorage: timeStorages(relInfo)}
+		conditions, args := buildConditions(where, relOpts, nil)
+		conditions = append([]string{fmt.Sprintf("(%s)", strings.Join(keys, OR))}, conditions...)
+		if sd := softDeleteColumn(relInfo); sd != "" && !opts.WithTrashed {
+			conditions = append(conditions, fmt.Sprintf("%s is null", sd))
+		}
+
+		opts.filters = append(opts.filters, fmt.Sprintf(
+			"exists (select 1 from %s where %s)", relInfo.table, strings.Join(conditions, AND)))
+		opts.filterArgs = append(opts.filterArgs, args...)
 	}
+	return nil
+}
 
+// Adds joins and where conditions to options to search models related to ones
+// listed in RelatedTo option
+func buildRelatedToJoins(mInfo *modelInfo, colInfo []columnInfo, opts *Options) error {
+	if opts != nil && opts.RelatedExists {
+		return buildRelatedToExists(mInfo, colInfo, opts)
+	}
 	if opts != nil && len(opts.RelatedTo) != 0 {
+		relatedTo, err := buildRelatedToAny(mInfo, colInfo, opts)
+		if err != nil {
+			return err
+		}
 		searchModels := map[reflect.Type][]Model{}
-		for _, sm := range opts.RelatedTo {
+		for _, sm := range relatedTo {
 			mt := reflect.TypeOf(sm)
 			if slice, ok := searchModels[mt]; ok {
 				slice = append(slice, sm)
@@ -785,13 +1915,13 @@ func QuerySliceCountContext(ctx context.Context, db *sql.DB, opts *Options, out
 						joinQuery  strings.Builder
 						conditions []string
 					)
-					for _, field := range modelInfo.fields {
+					for _, field := range mInfo.fields {
 						if isPkField(field) {
 							joinQuery.WriteString(" left join " + relModelInfo.table + " on ")
 							for _, relField := range relModelInfo.fields {
-								if modelInfo.value.Addr().Type().AssignableTo(relField.value.Type()) {
+								if mInfo.value.Addr().Type().AssignableTo(relField.value.Type()) {
 									conditions = append(conditions, fmt.Sprintf(
-										"%s.%s = %s.%s", modelInfo.table, field.column, relModelInfo.table, relField.column))
+										"%s.%s = %s.%s", mInfo.table, field.column, relModelInfo.table, relField.column))
 								}
 								if isPkField(relField) {
 									for _, sm := range slice {
@@ -826,13 +1956,13 @@ func QuerySliceCountContext(ctx context.Context, db *sql.DB, opts *Options, out
 						joinQuery  strings.Builder
 						conditions []string
 					)
-					for _, field := range modelInfo.fields {
+					for _, field := range mInfo.fields {
 						if isPkField(field) {
 							joinQuery.WriteString(" left join " + ci.RelationInfo.Table + " on ")
 							for _, relField := range relModelInfo.fields {
 								if isPkField(relField) {
 									conditions = append(conditions, fmt.Sprintf(
-										"%s.%s = %s.%s", modelInfo.table, field.column, ci.RelationInfo.Table, field.reference.column))
+										"%s.%s = %s.%s", mInfo.table, field.column, ci.RelationInfo.Table, field.reference.column))
 									for _, sm := range slice {
 										// add where conditions
 										val, err := getModelValue(sm)
@@ -844,7 +1974,7 @@ func QuerySliceCountContext(ctx context.Context, db *sql.DB, opts *Options, out
 											return errors.Wrap(err, "can't get related model primary fields")
 										}
 										for _, pField := range pFields {
-											addWhereClause(opts, fmt.Sprintf("%s.%s", ci.RelationInfo.Table, pField.relationName), pField.field)
+											addWhereClause(opts, fmt.Sprintf("%s.%s", ci.RelationInfo.Table, relatedMappingColumn(&ci.RelationInfo, pField)), pField.field)
 										}
 									}
 								}
@@ -859,57 +1989,523 @@ func QuerySliceCountContext(ctx context.Context, db *sql.DB, opts *Options, out
 			}
 		}
 	}
+	return nil
+}
+
+// Adds exists subqueries to the filters of options to search models related to
+// ones listed in RelatedTo option, so rows are never duplicated by joins. Model
+// has to be related to any of listed models of the same type, related model
+// with zero key matches models having any related one of its type
+func buildRelatedToExists(mInfo *modelInfo, colInfo []columnInfo, opts *Options) error {
+	if len(opts.RelatedTo) == 0 {
+		return nil
+	}
+	types, related := groupRelatedTo(opts.RelatedTo)
 
-	rows, err := queryWithOptions(
-		ctx, db, reflect.New(modelType).Interface().(Model).Table(), colNames, opts, count)
+	pkFields, err := getPrimaryFieldsInfo(mInfo.value)
 	if err != nil {
 		return err
 	}
-
-	if opts != nil {
-		opts.joins = nil
+	if len(pkFields) == 0 {
+		return errors.New("can't search related to: model does not have primary key")
 	}
 
-	for rows.Next() {
+	for _, t := range types {
+		relation, table, links, err := relatedLinks(mInfo, colInfo, pkFields, t)
+		if err != nil {
+			return err
+		}
+
 		var (
-			se           = reflect.New(modelType)
-			fPtrs        []interface{}
-			entryColInfo = make([]columnInfo, len(colInfo))
+			matches    []string
+			args       []interface{}
+			anyRelated bool
 		)
-
-		copy(entryColInfo, colInfo)
-		colInfoPerEntry = append(colInfoPerEntry, entryColInfo)
-
-		for i := 0; i < se.Elem().NumField(); i++ {
-			for k, ci := range colInfo {
-				if ci.Index == i {
-					if ci.RelationInfo.Type == hasOne {
-						pToPk := &entryColInfo[k].RelationInfo.RefPkValue
-						fPtrs = append(fPtrs, pToPk)
-					} else if ci.RelationInfo.Type == hasMany || ci.RelationInfo.Type == manyToMany {
-						continue
-					} else {
-						fPtrs = append(fPtrs, se.Elem().Field(i).Addr().Interface())
-					}
+		for _, rm := range related[t] {
+			relPkFields, zero, err := relatedKey(rm)
+			if err != nil {
+				return err
+			}
+			if zero {
+				anyRelated = true
+				break
+			}
+			var conditions []string
+			for _, pk := range relPkFields {
+				column := pk.name
+				if relation.Type == manyToMany {
+					column = relatedMappingColumn(relation, pk)
 				}
+				conditions = append(conditions, fmt.Sprintf("%s.%s = ?", table, column))
+				args = append(args, pk.field.Interface())
 			}
+			matches = append(matches, fmt.Sprintf("(%s)", strings.Join(conditions, AND)))
 		}
 
-		if err := rows.Scan(fPtrs...); err != nil {
-			return err
+		if anyRelated {
+			opts.filters = append(opts.filters, fmt.Sprintf("exists (select 1 from %s where %s)", table, strings.Join(links, AND)))
+			continue
 		}
-
-		slicePtr.Set(reflect.Append(slicePtr, se))
+		opts.filters = append(opts.filters, fmt.Sprintf("exists (select 1 from %s where %s%s(%s))",
+			table, strings.Join(links, AND), AND, strings.Join(matches, OR)))
+		opts.filterArgs = append(opts.filterArgs, args...)
 	}
-
-	return loadRelationsForSlice(ctx, db, opts, slicePtr, colInfoPerEntry)
+	return nil
 }
 
-func addWhereClause(options *Options, s string, value reflect.Value) {
-	if options == nil {
-		options = new(Options)
+// Adds exists subqueries to the filters of options for types of RelatedTo
+// models having one with zero key, so they match models having any related one
+// of the type, returns the rest of RelatedTo models to search with joins
+func buildRelatedToAny(mInfo *modelInfo, colInfo []columnInfo, opts *Options) ([]IModel, error) {
+	var (
+		anyTypes   = make(map[reflect.Type]bool)
+		types, all = groupRelatedTo(opts.RelatedTo)
+		rest       []IModel
+		pkFields   []pkFieldInfo
+	)
+	for _, t := range types {
+		for _, rm := range all[t] {
+			_, zero, err := relatedKey(rm)
+			if err != nil {
+				return nil, err
+			}
+			if !zero {
+				continue
+			}
+			if pkFields == nil {
+				if pkFields, err = getPrimaryFieldsInfo(mInfo.value); err != nil {
+					return nil, err
+				}
+				if len(pkFields) == 0 {
+					return nil, errors.New("can't search related to: model does not have primary key")
+				}
+			}
+			_, table, links, err := relatedLinks(mInfo, colInfo, pkFields, t)
+			if err != nil {
+				return nil, err
+			}
+			opts.filters = append(opts.filters, fmt.Sprintf("exists (select 1 from %s where %s)", table, strings.Join(links, AND)))
+			anyTypes[t] = true
+			break
+		}
 	}
-	if options.Where == nil {
+	for _, rm := range opts.RelatedTo {
+		if !anyTypes[reflect.TypeOf(rm)] {
+			rest = append(rest, rm)
+		}
+	}
+	return rest, nil
+}
+
+// Groups RelatedTo models by their types keeping order of types
+func groupRelatedTo(relatedTo []IModel) ([]reflect.Type, map[reflect.Type][]IModel) {
+	var (
+		types   []reflect.Type
+		related = make(map[reflect.Type][]IModel)
+	)
+	for _, rm := range relatedTo {
+		t := reflect.TypeOf(rm)
+		if _, ok := related[t]; !ok {
+			types = append(types, t)
+		}
+		related[t] = append(related[t], rm)
+	}
+	return types, related
+}
+
+// Returns primary key fields of RelatedTo model and whether the key is zero
+func relatedKey(rm IModel) ([]pkFieldInfo, bool, error) {
+	val, err := getModelValue(rm)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "can't get model value of related one")
+	}
+	pkFields, err := getPrimaryFieldsInfo(val)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "can't get related model primary fields")
+	}
+	for _, pk := range pkFields {
+		if isZeroField(pk.field) {
+			return pkFields, true, nil
+		}
+	}
+	return pkFields, false, nil
+}
+
+// Returns has many or many to many relation of the model to models of given
+// type with the table of related rows (mapping table for many to many) and
+// conditions linking them to the model row
+func relatedLinks(mInfo *modelInfo, colInfo []columnInfo, pkFields []pkFieldInfo, t reflect.Type) (*relationInfo, string, []string, error) {
+	var relation *relationInfo
+	for i, ci := range colInfo {
+		if ci.RelationInfo.RelatedType == t && (ci.RelationInfo.Type == hasMany || ci.RelationInfo.Type == manyToMany) {
+			relation = &colInfo[i].RelationInfo
+		}
+	}
+	if relation == nil {
+		return nil, "", nil, errors.Errorf("model %s does not have has many or many to many relation to %v", mInfo.table, t)
+	}
+
+	var links []string
+	if relation.Type == manyToMany {
+		mapping, err := getMappingColumns(relation, pkFields)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		for i, pk := range pkFields {
+			links = append(links, fmt.Sprintf("%s.%s = %s.%s", relation.Table, mapping[i], mInfo.table, pk.name))
+		}
+		if relation.Condition != "" {
+			links = append(links, relation.Condition)
+		}
+		return relation, relation.Table, links, nil
+	}
+
+	if len(pkFields) != 1 {
+		return nil, "", nil, errors.New("can't search related to: has many relation requires model with single primary key")
+	}
+	relatedType := t.Elem()
+	relInfo, err := getModelInfo(reflect.New(relatedType).Interface())
+	if err != nil {
+		return nil, "", nil, errors.Wrap(err, "can't search related to")
+	}
+	var keys []string
+	for i := 0; i < relatedType.NumField(); i++ {
+		if f := relatedType.Field(i); f.Type.AssignableTo(mInfo.value.Addr().Type()) {
+			keys = append(keys, fmt.Sprintf("%s.%s = %s.%s", relInfo.table, getFieldColumnName(f), mInfo.table, pkFields[0].name))
+		}
+	}
+	if len(keys) == 0 {
+		return nil, "", nil, errors.Errorf("related model %s does not refer %s", relInfo.table, mInfo.table)
+	}
+	links = append(links, fmt.Sprintf("(%s)", strings.Join(keys, OR)))
+	return relation, relInfo.table, links, nil
+}
+
+// Prepares per query state of options: joins to search related models and
+// time storage modes of model columns
+func prepareQuery(mInfo *modelInfo, colInfo []columnInfo, opts *Options) (*Options, error) {
+	opts, err := prepareModelQuery(mInfo, opts)
+	if err != nil || opts == nil {
+		return opts, err
+	}
+	if err := buildScopeConditions(mInfo, opts); err != nil {
+		return opts, err
+	}
+	if err := buildExistsConditions(mInfo, colInfo, opts); err != nil {
+		return opts, err
+	}
+	return opts, buildRelatedToJoins(mInfo, colInfo, opts)
+}
+
+// Prepares per query state of options that depends on model only, state is
+// kept by a copy of options, so options shared by concurrent queries are never
+// modified. Empty options are created if rows marked by soft delete column
+// have to be skipped
+func prepareModelQuery(mInfo *modelInfo, opts *Options) (*Options, error) {
+	softDelete := softDeleteColumn(mInfo)
+	if opts == nil {
+		if softDelete == "" {
+			return nil, nil
+		}
+		opts = &Options{}
+	} else {
+		opts = queryOptions(opts)
+	}
+	if opts.err != nil {
+		return opts, opts.err
+	}
+	if err := validateColumnConditions(mInfo, opts.Where); err != nil {
+		return opts, err
+	}
+	if err := validateHavingColumns(mInfo, opts.Having); err != nil {
+		return opts, err
+	}
+	if opts.StrictColumns {
+		if err := validateStrictColumns(mInfo, opts); err != nil {
+			return opts, err
+		}
+	}
+	opts.timeStorage = timeStorages(mInfo)
+	if !opts.WithTrashed {
+		opts.softDelete = softDelete
+	}
+	return opts, nil
+}
+
+// Adds conditions of loaded relation to the filters of options, so they are
+// applied regardless of divider used for relation keys
+func buildScopeConditions(mInfo *modelInfo, opts *Options) error {
+	if len(opts.scope) == 0 {
+		return nil
+	}
+	if err := validateColumnConditions(mInfo, opts.scope); err != nil {
+		return err
+	}
+	keys, args := buildConditions(opts.scope, opts, nil)
+	opts.filters = append(opts.filters, keys...)
+	opts.filterArgs = append(opts.filterArgs, args...)
+	return nil
+}
+
+// Overrides table of model info with the one set by options
+func overrideTable(info *modelInfo, opts *Options) error {
+	if opts == nil || opts.Table == "" {
+		return nil
+	}
+	if err := validateTableName(opts.Table); err != nil {
+		return err
+	}
+	info.table = opts.Table
+	return nil
+}
+
+// Returns arguments of expression columns in order they are selected, values
+// of expressions are taken from options or given model
+func expressionArgs(model reflect.Value, colInfo []columnInfo, opts *Options) []interface{} {
+	var args []interface{}
+	for _, ci := range colInfo {
+		if ci.Expression {
+			args = append(args, columnExpressionArgs(opts, ci.Name, model.Field(ci.Index))...)
+		}
+	}
+	return args
+}
+
+// Returns arguments of expression column, arguments set by options for alias
+// of the column take precedence over the ones of the field
+func columnExpressionArgs(opts *Options, column string, field reflect.Value) []interface{} {
+	if opts != nil {
+		if args, ok := opts.ColumnArgs[resultColumnName(column)]; ok {
+			return args
+		}
+	}
+	if field.Kind() == reflect.Ptr && field.IsNil() {
+		if _, ok := field.Type().Elem().MethodByName("Args"); ok {
+			// Args with value receiver can't be called on nil pointer
+			return nil
+		}
+	}
+	if exp, ok := field.Interface().(ArgExpression); ok {
+		return exp.Args()
+	}
+	return nil
+}
+
+// Sets arguments of selected expression columns to options, empty options
+// are created if there are arguments to bind
+func withColumnArgs(opts *Options, args []interface{}) *Options {
+	if len(args) == 0 {
+		return opts
+	}
+	if opts == nil {
+		opts = &Options{}
+	}
+	opts.columnArgs = args
+	return opts
+}
+
+// Returns shallow copy of options to keep per query state, state already set
+// (e.g. filters of relation) is kept but appending to it never modifies the
+// original options. Where is copied if conditions of related models are added
+// to it
+func queryOptions(opts *Options) *Options {
+	c := *opts
+	if len(c.RelatedTo) != 0 {
+		c.Where = make(Where, len(opts.Where))
+		for k, v := range opts.Where {
+			c.Where[k] = v
+		}
+	}
+	c.joins = c.joins[:len(c.joins):len(c.joins)]
+	c.filters = c.filters[:len(c.filters):len(c.filters)]
+	c.filterArgs = c.filterArgs[:len(c.filterArgs):len(c.filterArgs)]
+	return &c
+}
+
+// Filters columns according to options returning them with list of names to select
+func selectColumns(info *modelInfo, colInfo []columnInfo, opts *Options) ([]columnInfo, []string) {
+	var colNames []string
+	if opts != nil && opts.Columns != nil {
+		var selected []columnInfo
+		for _, ci := range colInfo {
+			if _, ok := opts.Columns[ci.Name]; ok || ci.Primary {
+				selected = append(selected, ci)
+			}
+		}
+		colInfo = selected
+	}
+
+	for _, ci := range colInfo {
+		if ci.RelationInfo.Type == noRelation || ci.RelationInfo.Type == hasOne {
+			if ci.Primary && !ci.Expression {
+				colNames = append(colNames, fmt.Sprintf("%s.%s", info.table, ci.Name))
+			} else {
+				colNames = append(colNames, ci.Name)
+			}
+		}
+	}
+	return colInfo, colNames
+}
+
+// Scans rows appending new models to the slice, returns column info for each
+// scanned entry containing values of has one relations keys
+func scanSliceRows(rows *tracedRows, slicePtr reflect.Value, modelType reflect.Type, colInfo []columnInfo, joined ...joinedRelation) ([][]columnInfo, error) {
+	scan, err := newModelScan(rows, modelType, colInfo, joined)
+	if err != nil {
+		return nil, err
+	}
+	colInfoPerEntry := make([][]columnInfo, 0, slicePtr.Cap()-slicePtr.Len())
+	for rows.Next() {
+		se, entryColInfo, err := scan.row(rows)
+		if err != nil {
+			return nil, err
+		}
+		colInfoPerEntry = append(colInfoPerEntry, entryColInfo)
+		slicePtr.Set(reflect.Append(slicePtr, se))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return colInfoPerEntry, nil
+}
+
+// modelScan scans rows of the query into models, result columns are matched
+// with fields once per query, since they are the same for every row
+type modelScan struct {
+	modelType reflect.Type
+	colInfo   []columnInfo
+	joined    []joinedRelation
+	order     []int
+}
+
+// Returns scan of model rows with result columns of given rows matched with
+// destinations of model fields and joined relations
+func newModelScan(rows *tracedRows, modelType reflect.Type, colInfo []columnInfo, joined []joinedRelation) (*modelScan, error) {
+	var names []string
+	for i := 0; i < modelType.NumField(); i++ {
+		for _, ci := range colInfo {
+			if ci.Index == i && isScannedColumn(ci) {
+				names = append(names, resultColumnName(ci.Name))
+			}
+		}
+	}
+	for _, jr := range joined {
+		names = append(names, jr.columns...)
+	}
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	return &modelScan{modelType: modelType, colInfo: colInfo, joined: joined, order: scanOrder(columns, names)}, nil
+}
+
+// Returns whether column is selected by the query of model, relations stored
+// in other tables are loaded separately
+func isScannedColumn(ci columnInfo) bool {
+	switch ci.RelationInfo.Type {
+	case hasMany, manyToMany, concatRelation:
+		return false
+	}
+	return true
+}
+
+// Scans current row into a new model returning pointer to it and column info
+// containing values of has one relations keys, joined relations are scanned
+// from the same row
+func (s *modelScan) row(rows *tracedRows) (reflect.Value, []columnInfo, error) {
+	var (
+		se           = reflect.New(s.modelType)
+		fPtrs        []interface{}
+		entryColInfo = make([]columnInfo, len(s.colInfo))
+	)
+
+	copy(entryColInfo, s.colInfo)
+
+	for i := 0; i < se.Elem().NumField(); i++ {
+		for k, ci := range s.colInfo {
+			if ci.Index != i || !isScannedColumn(ci) {
+				continue
+			}
+			if ci.RelationInfo.Type == hasOne {
+				fPtrs = append(fPtrs, &entryColInfo[k].RelationInfo.RefPkValue)
+			} else {
+				fPtrs = append(fPtrs, scanDest(se.Elem().Field(i)))
+			}
+		}
+	}
+
+	related, joinedPtrs := joinedScanDest(s.joined)
+	fPtrs = append(fPtrs, joinedPtrs...)
+
+	if err := rows.Scan(orderScanDest(s.order, fPtrs)...); err != nil {
+		return se, nil, err
+	}
+	setJoinedRelations(se.Elem(), entryColInfo, s.joined, related)
+	return se, entryColInfo, nil
+}
+
+// Returns name of the result column selected by column sql, it's the alias
+// of expression or the column name itself
+func resultColumnName(column string) string {
+	if i := strings.LastIndex(strings.ToLower(column), " as "); i != -1 {
+		return strings.TrimSpace(column[i+4:])
+	}
+	return column
+}
+
+// Orders scan destinations by columns returned by the query, so values land
+// in their fields even if expressions select several columns
+func alignScanDest(columns, names []string, dests []interface{}) []interface{} {
+	return orderScanDest(scanOrder(columns, names), dests)
+}
+
+// Returns index of scan destination for each column returned by the query.
+// Destinations are matched by names of their columns, the ones left unmatched
+// keep their positions if counts of columns and destinations are equal, other
+// result columns are discarded and have negative index.
+func scanOrder(columns, names []string) []int {
+	var (
+		order = make([]int, len(columns))
+		used  = make([]bool, len(names))
+	)
+	for i, c := range columns {
+		order[i] = -1
+		for j, n := range names {
+			if !used[j] && strings.EqualFold(n, c) {
+				order[i], used[j] = j, true
+				break
+			}
+		}
+	}
+	for i := range order {
+		if order[i] != -1 {
+			continue
+		}
+		if len(columns) == len(names) && !used[i] {
+			order[i], used[i] = i, true
+		}
+	}
+	return order
+}
+
+// Returns scan destinations placed in order of result columns
+func orderScanDest(order []int, dests []interface{}) []interface{} {
+	aligned := make([]interface{}, len(order))
+	for i, j := range order {
+		if j < 0 {
+			aligned[i] = new(interface{})
+		} else {
+			aligned[i] = dests[j]
+		}
+	}
+	return aligned
+}
+
+func addWhereClause(options *Options, s string, value reflect.Value) {
+	if options == nil {
+		options = new(Options)
+	}
+	if options.Where == nil {
 		options.Where = make(Where)
 	}
 	switch value.Kind() {
@@ -924,248 +2520,542 @@ func addWhereClause(options *Options, s string, value reflect.Value) {
 	}
 }
 
-// Delete removes model object from database by its primary key
-func Delete(db *sql.DB, m Model) (sql.Result, error) {
-	modelValue := reflect.ValueOf(m).Elem()
+// Delete removes model object from database by its primary key, models having
+// field tagged with softdelete are marked as deleted instead
+func Delete(db Executor, m Model) (sql.Result, error) {
+	info, err := getModelInfo(m)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	res, err := deleteModel(ctx, db, info)
+	if err == nil {
+		Untrack(m)
+	}
+	return res, err
+}
+
+// Deletes (or marks as deleted) row of the model by its primary key
+func deleteModel(ctx context.Context, db Executor, info *modelInfo) (sql.Result, error) {
+	modelValue := info.value
 
 	var (
-		where    []string
-		args     []interface{}
-		pkFields []pkFieldInfo
+		where []string
+		args  []interface{}
 	)
 
-	for i := 0; i < modelValue.NumField(); i++ {
-		fv := modelValue.Field(i)
-		ft := modelValue.Type().Field(i)
-		if lookForSetting(ft.Tag.Get(packageTagName), "primary") == "primary" {
-			var info pkFieldInfo
-			info.name = getFieldColumnName(ft)
-			info.field = fv
-			pkFields = append(pkFields, info)
-		}
+	pkFields, err := getPrimaryFieldsInfo(modelValue)
+	if err != nil {
+		return nil, err
 	}
-
 	if len(pkFields) == 0 {
 		return nil, errors.New("delete failed: model does not have primary key")
 	}
 
 	for _, pkField := range pkFields {
-		if reflect.Zero(pkField.field.Type()).Interface() == pkField.field.Interface() {
-			return nil, errors.New("delete failed: model's primary key has zero value")
+		value, err := pkFieldValue(pkField)
+		if err != nil {
+			return nil, err
 		}
-
 		where = append(where, fmt.Sprintf("%s = ?", pkField.name))
-		args = append(args, pkField.field.Interface())
+		args = append(args, value)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
-	defer cancel()
+	query := fmt.Sprintf("delete from %s where %s", info.table, strings.Join(where, " and "))
 
-	query := fmt.Sprintf("delete from %s where %s", m.Table(), strings.Join(where, " and "))
-	res, err := db.ExecContext(ctx, query, args...)
+	for _, f := range info.fields {
+		if isSoftDeleteField(f) {
+			// rows are marked with deletion time instead, so they are kept for
+			// queries including trashed ones
+			where = append(where, fmt.Sprintf("%s is null", f.column))
+			query = fmt.Sprintf("update %s set %s = ? where %s", info.table, f.column, strings.Join(where, " and "))
+			args = append([]interface{}{formatTime(time.Now(), f.timeStorage)}, args...)
+			break
+		}
+	}
+
+	res, err := execContext(ctx, db, query, args...)
 	if err != nil {
 		return nil, &Error{err, query, args}
 	}
 	return res, err
 }
 
+// Returns value of primary field stored in the column, has one relation being
+// a part of primary key is stored as key of related model
+func pkFieldValue(pkField pkFieldInfo) (interface{}, error) {
+	if isZeroField(pkField.field) {
+		return nil, errors.Errorf("delete failed: model's primary key %s has zero value", pkField.name)
+	}
+	value := pkField.field.Interface()
+	if _, ok := value.(Model); !ok {
+		return value, nil
+	}
+	keys, err := getModelPkKeys(pkField.field)
+	if err != nil {
+		return nil, errors.Wrap(err, "delete failed")
+	}
+	if len(keys) != 1 {
+		return nil, errors.Errorf("delete failed: model referenced by %s must have single primary key", pkField.name)
+	}
+	if isZeroField(reflect.ValueOf(keys[0])) {
+		return nil, errors.Errorf("delete failed: model's primary key %s has zero value", pkField.name)
+	}
+	return keys[0], nil
+}
+
+// DeleteDeep deletes the model like Delete does together with mapping rows of
+// its many to many relations, models of has many relations tagged with
+// cascade setting are deleted (or marked as deleted) as well, their own
+// relations are not touched. Everything is deleted in a single transaction
+// if executor is able to start one
+func DeleteDeep(db Executor, m Model) (sql.Result, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+	return DeleteDeepContext(ctx, db, m)
+}
+
+// DeleteDeepContext is the same as DeleteDeep but with given context
+func DeleteDeepContext(ctx context.Context, db Executor, m Model) (res sql.Result, err error) {
+	info, err := getModelInfo(m)
+	if err != nil {
+		return nil, err
+	}
+	pkFields, err := getPrimaryFieldsInfo(info.value)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkFields) == 0 {
+		return nil, errors.New("delete failed: model does not have primary key")
+	}
+	// related rows of zero key must not be deleted
+	pkValues := make([]interface{}, len(pkFields))
+	for i, pk := range pkFields {
+		if pkValues[i], err = pkFieldValue(pk); err != nil {
+			return nil, err
+		}
+	}
+
+	// mapping rows and children without soft delete column of soft deleted
+	// model are kept, so restored model has its relations back
+	var softDeleted bool
+	for _, f := range info.fields {
+		if isSoftDeleteField(f) {
+			softDeleted = true
+			break
+		}
+	}
+
+	err = withTransaction(ctx, db, func(db Executor) error {
+		modelType := info.value.Type()
+		for i := 0; i < modelType.NumField(); i++ {
+			field := modelType.Field(i)
+			if !isExportedField(field) {
+				continue
+			}
+			ri := extractRelationInfo(field)
+			if ri == nil {
+				continue
+			}
+			tag := field.Tag.Get(packageTagName)
+
+			var (
+				query string
+				args  []interface{}
+			)
+			switch {
+			case ri.Type == manyToMany && lookForSetting(tag, "view") == "" && !softDeleted:
+				mapping, err := getMappingColumns(ri, pkFields)
+				if err != nil {
+					return err
+				}
+				var where []string
+				for j := range pkFields {
+					where = append(where, fmt.Sprintf("%s = ?", mapping[j]))
+					args = append(args, pkValues[j])
+				}
+				if ri.Condition != "" {
+					where = append(where, ri.Condition)
+				}
+				query = fmt.Sprintf("delete from %s where %s", ri.Table, strings.Join(where, AND))
+			case ri.Type == hasMany && lookForSetting(tag, "cascade") != "":
+				relInfo, err := getModelInfo(reflect.New(ri.RelatedType.Elem()).Interface())
+				if err != nil {
+					return err
+				}
+				filter, filterArgs, err := hasManyFilter(relInfo, info.value.Addr().Type(), pkFields)
+				if err != nil {
+					return err
+				}
+				query, args = "", filterArgs
+				for _, f := range relInfo.fields {
+					if isSoftDeleteField(f) {
+						query = fmt.Sprintf("update %s set %s = ? where %s and %s is null", relInfo.table, f.column, filter, f.column)
+						args = append([]interface{}{formatTime(time.Now(), f.timeStorage)}, args...)
+						break
+					}
+				}
+				if query == "" {
+					if softDeleted {
+						continue
+					}
+					query = fmt.Sprintf("delete from %s where %s", relInfo.table, filter)
+				}
+			default:
+				continue
+			}
+
+			debugQuery(query, args)
+			if _, err := execContext(ctx, db, query, args...); err != nil {
+				return &Error{err, query, args}
+			}
+		}
+
+		res, err = deleteModel(ctx, db, info)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	Untrack(m)
+	return res, nil
+}
+
 type pkFieldInfo struct {
 	relationName string
 	name         string
 	field        reflect.Value
 }
 
-// Count models in database with search options
-func Count(db *sql.DB, m Model, opts *Options) (count int64, err error) {
+// Count models in database with search options, limit of options is ignored
+// unless it's 1, then count is 1 if any model matches and 0 otherwise
+func Count(db Executor, m Model, opts *Options) (int64, error) {
+	return CountContext(context.Background(), db, m, opts)
+}
+
+// CountContext counts models in database with search options and given context
+func CountContext(ctx context.Context, db Executor, m Model, opts *Options) (int64, error) {
+	return countModels(ctx, db, m, "", opts)
+}
+
+// CountDistinct counts distinct values of model column in database with search options
+func CountDistinct(db Executor, m Model, column string, opts *Options) (int64, error) {
+	return CountDistinctContext(context.Background(), db, m, column, opts)
+}
+
+// CountDistinctContext is the same as CountDistinct but with given context
+func CountDistinctContext(ctx context.Context, db Executor, m Model, column string, opts *Options) (int64, error) {
+	if column == "" {
+		return 0, errors.New("column to count distinct values is empty")
+	}
+	return countModels(ctx, db, m, column, opts)
+}
+
+// Counts models or distinct values of column if it's not empty
+func countModels(ctx context.Context, db Executor, m Model, column string, opts *Options) (count int64, err error) {
 	mInfo, err := getModelInfo(m)
 	if err != nil {
 		return
 	}
 
-	var (
-		query   strings.Builder
-		args    []interface{}
-		divider string
-	)
-
 	colInfo, err := getColumnInfo(mInfo.value.Type())
 	if err != nil {
 		return
 	}
 
-	if opts != nil && len(opts.RelatedTo) != 0 {
-		searchModels := map[reflect.Type][]Model{}
-		for _, sm := range opts.RelatedTo {
-			mt := reflect.TypeOf(sm)
-			if slice, ok := searchModels[mt]; ok {
-				slice = append(slice, sm)
-			} else {
-				searchModels[mt] = []Model{sm}
-			}
-		}
+	if err := overrideTable(mInfo, opts); err != nil {
+		return 0, err
+	}
+	if mInfo.table == "" {
+		return 0, ErrNoTable
+	}
+
+	expr := "count(*)"
+	if column != "" {
+		var found bool
 		for _, ci := range colInfo {
-			if slice, ok := searchModels[ci.RelationInfo.RelatedType]; ok {
-				switch ci.RelationInfo.Type {
-				case hasMany:
-					modelStructType := ci.RelationInfo.RelatedType.Elem()
-					relModelInfo, err := getModelInfo(reflect.New(modelStructType).Interface().(IModel))
-					if err != nil {
-						return 0, err
-					}
-					var (
-						joinQuery  strings.Builder
-						conditions []string
-					)
-					for _, field := range mInfo.fields {
-						if isPkField(field) {
-							joinQuery.WriteString(" left join " + relModelInfo.table + " on ")
-							for _, relField := range relModelInfo.fields {
-								if mInfo.value.Addr().Type().AssignableTo(relField.value.Type()) {
-									conditions = append(conditions, fmt.Sprintf(
-										"%s.%s = %s.%s", mInfo.table, field.column, relModelInfo.table, relField.column))
-								}
-								if isPkField(relField) {
-									for _, sm := range slice {
-										// add where conditions
-										val, err := getModelValue(sm)
-										if err != nil {
-											return 0, err
-										}
-										pFields, err := getPrimaryFieldsInfo(val)
-										if err != nil {
-											return 0, err
-										}
-										for _, pField := range pFields {
-											addWhereClause(opts, fmt.Sprintf("%s.%s", relModelInfo.table, pField.name), pField.field)
-										}
-									}
-								}
-							}
-						}
-					}
-					if len(conditions) != 0 {
-						joinQuery.WriteString(strings.Join(conditions, OR))
-						opts.joins = append(opts.joins, joinQuery.String())
-					}
-				case manyToMany:
-					modelStructType := ci.RelationInfo.RelatedType.Elem()
-					relModelInfo, err := getModelInfo(reflect.New(modelStructType).Interface().(IModel))
-					if err != nil {
-						return 0, err
-					}
-					var (
-						joinQuery  strings.Builder
-						conditions []string
-					)
-					for _, field := range mInfo.fields {
-						if isPkField(field) {
-							joinQuery.WriteString(" left join " + ci.RelationInfo.Table + " on ")
-							for _, relField := range relModelInfo.fields {
-								if isPkField(relField) {
-									conditions = append(conditions, fmt.Sprintf(
-										"%s.%s = %s.%s", mInfo.table, field.column, ci.RelationInfo.Table, field.reference.column))
-									for _, sm := range slice {
-										// add where conditions
-										val, err := getModelValue(sm)
-										if err != nil {
-											return 0, err
-										}
-										pFields, err := getPrimaryFieldsInfo(val)
-										if err != nil {
-											return 0, err
-										}
-										for _, pField := range pFields {
-											addWhereClause(opts, fmt.Sprintf("%s.%s", ci.RelationInfo.Table, pField.relationName), pField.field)
-										}
-									}
-								}
-							}
-						}
-					}
-					if len(conditions) != 0 {
-						joinQuery.WriteString(strings.Join(conditions, OR))
-						opts.joins = append(opts.joins, joinQuery.String())
-					}
-				}
+			if ci.Name == column && (ci.RelationInfo.Type == noRelation || ci.RelationInfo.Type == hasOne) {
+				found = true
+				break
 			}
 		}
+		if !found {
+			return 0, errors.Errorf("model %s does not have column %s", mInfo.table, column)
+		}
+		expr = fmt.Sprintf("count(distinct %s)", column)
 	}
 
-	query.WriteString("select count() from ")
-	query.WriteString(m.Table())
+	if opts, err = prepareQuery(mInfo, colInfo, opts); err != nil {
+		return 0, err
+	}
 
-	if opts != nil {
-		if len(opts.joins) != 0 {
-			query.WriteString(strings.Join(opts.joins, " "))
+	selected, colNames := selectColumns(mInfo, colInfo, opts)
+	opts = withColumnArgs(opts, expressionArgs(mInfo.value, selected, opts))
+	if column != "" && !containsColumn(colNames, mInfo.table, column) {
+		// column must be selected by subquery to be counted
+		colNames = append(colNames, fmt.Sprintf("%s.%s", mInfo.table, column))
+	}
+	q, args := buildCountQueryExpr(mInfo.table, colNames, opts, expr)
+	if column == "" && opts != nil && opts.Limit == 1 {
+		// only existence of matching row is checked, so the scan stops at
+		// the first one
+		q, args = buildFilteredQuery(mInfo.table, colNames, opts)
+		q = fmt.Sprintf("select count(*) from (%s limit 1)", q)
+	}
+	debugQuery(q, args)
+	if err := queryRowScan(ctx, db, q, args, &count); err != nil {
+		return 0, &Error{err, q, args}
+	}
+	return count, nil
+}
+
+// CountByGroup counts models with search options per distinct value of the
+// column, counts are keyed by values as returned by the driver (texts are
+// strings, NULL is nil key)
+func CountByGroup(db Executor, m Model, column string, opts *Options) (map[interface{}]int64, error) {
+	return CountByGroupContext(context.Background(), db, m, column, opts)
+}
+
+// CountByGroupContext is the same as CountByGroup but with given context
+func CountByGroupContext(ctx context.Context, db Executor, m Model, column string, opts *Options) (map[interface{}]int64, error) {
+	mInfo, err := getModelInfo(m)
+	if err != nil {
+		return nil, err
+	}
+	colInfo, err := getColumnInfo(mInfo.value.Type())
+	if err != nil {
+		return nil, err
+	}
+	if err := overrideTable(mInfo, opts); err != nil {
+		return nil, err
+	}
+	if mInfo.table == "" {
+		return nil, ErrNoTable
+	}
+	if !isStoredColumn(mInfo, column) {
+		return nil, errors.Errorf("model %s does not have column %s", mInfo.table, column)
+	}
+
+	if opts, err = prepareQuery(mInfo, colInfo, opts); err != nil {
+		return nil, err
+	}
+
+	selected, colNames := selectColumns(mInfo, colInfo, opts)
+	opts = withColumnArgs(opts, expressionArgs(mInfo.value, selected, opts))
+	if !containsColumn(colNames, mInfo.table, column) {
+		colNames = append(colNames, fmt.Sprintf("%s.%s", mInfo.table, column))
+	}
+	q, args := buildFilteredQuery(mInfo.table, colNames, opts)
+	q = fmt.Sprintf("select %s, count(*) from (%s) group by %s", column, q, column)
+	debugQuery(q, args)
+	rows, err := queryContext(ctx, db, q, args...)
+	if err != nil {
+		return nil, &Error{err, q, args}
+	}
+	defer rows.Close()
+
+	var counts = make(map[interface{}]int64)
+	for rows.Next() {
+		var (
+			value interface{}
+			count int64
+		)
+		if err := rows.Scan(&value, &count); err != nil {
+			return nil, err
 		}
-		if opts.Where != nil && len(opts.Where) > 0 {
-			query.WriteString(" where ")
-			if len(opts.Where) > 1 && opts.Divider == "" {
-				return 0, errors.New("empty divider with multiple conditions")
-			}
-			divider = opts.Divider
-			for f, v := range opts.Where {
-				if v != nil {
-					value := reflect.ValueOf(v)
-					switch value.Kind() {
-					case reflect.Slice:
-						if strings.Contains(f, ",") {
-							rowValueCount := len(strings.Split(f, ","))
-							for i := 0; i < value.Len()/rowValueCount; i++ {
-								query.WriteString("(" + f + ") = (" + strings.Trim(strings.Repeat("?,", rowValueCount), ",") + ")" + divider)
-							}
-							opts.Divider = OR
-						} else {
-							count := value.Len()
-							if opts.Limit != 0 && opts.Limit < count {
-								count = opts.Limit
-							}
-							query.WriteString(f + " in (" + strings.Trim(strings.Repeat("?,", count), ",") + ")" + divider)
-						}
-						for i := 0; i < value.Len(); i++ {
-							args = append(args, value.Index(i).Interface())
-						}
-					case reflect.String:
-						switch v.(type) {
-						case StrictString:
-							query.WriteString(f + " = ?" + divider)
-							args = append(args, v)
-						default:
-							query.WriteString(f + " like ?" + divider)
-							args = append(args, fmt.Sprintf("%%%s%%", v))
-						}
-					default:
-						switch v.(type) {
-						case Greater:
-							query.WriteString(f + " > ?" + divider)
-						case GreaterOrEqual:
-							query.WriteString(f + " >= ?" + divider)
-						case Less:
-							query.WriteString(f + " < ?" + divider)
-						case LessOrEqual:
-							query.WriteString(f + " <= ?" + divider)
-						case NotEqual:
-							query.WriteString(f + " != ?" + divider)
-						case BitwiseAND:
-							query.WriteString(f + "&? > 0" + divider)
-						case BitwiseANDStrict:
-							query.WriteString(f + "&? = ?" + divider)
-							args = append(args, v)
-						default:
-							query.WriteString(f + " = ?" + divider)
-						}
-						args = append(args, v)
-					}
-				} else {
-					query.WriteString(f + " is null" + divider)
-				}
-			}
+		if b, ok := value.([]byte); ok {
+			value = string(b)
 		}
+		counts[value] = count
 	}
+	return counts, rows.Err()
+}
 
-	row := db.QueryRow(strings.TrimSuffix(query.String(), divider), args...)
-	if err := row.Scan(&count); err != nil {
+// Checks if column is in the list of selected ones, possibly prefixed with table
+func containsColumn(columns []string, table, column string) bool {
+	for _, c := range columns {
+		if c == column || c == table+"."+column {
+			return true
+		}
+	}
+	return false
+}
+
+// CountRelated counts models of has many or many to many relation stored in the
+// field with given column name (e.g. "comments") without loading them, options
+// filter counted models
+func CountRelated(db Executor, parent Model, relation string, opts *Options) (int64, error) {
+	return CountRelatedContext(context.Background(), db, parent, relation, opts)
+}
+
+// CountRelatedContext is the same as CountRelated but with given context
+func CountRelatedContext(ctx context.Context, db Executor, parent Model, relation string, opts *Options) (int64, error) {
+	parentValue := reflect.ValueOf(parent)
+	if parentValue.Kind() != reflect.Ptr || parentValue.Elem().Kind() != reflect.Struct {
+		return 0, errors.Errorf("expected pointer to struct, got %T", parent)
+	}
+	colInfo, err := getColumnInfo(parentValue.Elem().Type())
+	if err != nil {
 		return 0, err
 	}
-	return count, nil
+	var ri *relationInfo
+	for i, ci := range colInfo {
+		if ci.Name == relation && (ci.RelationInfo.Type == hasMany || ci.RelationInfo.Type == manyToMany) {
+			ri = &colInfo[i].RelationInfo
+		}
+	}
+	if ri == nil {
+		return 0, errors.Errorf("model %s does not have has many or many to many relation %s", parent.Table(), relation)
+	}
+
+	pkFields, err := getPrimaryFieldsInfo(parentValue.Elem())
+	if err != nil {
+		return 0, err
+	}
+	if len(pkFields) == 0 {
+		return 0, errors.New("can't count related models: model does not have primary key")
+	}
+	for _, pk := range pkFields {
+		if isZeroField(pk.field) {
+			return 0, errors.New("can't count related models: model's primary key has zero value")
+		}
+	}
+
+	related := reflect.New(ri.RelatedType.Elem()).Interface().(Model)
+	relInfo, err := getModelInfo(related)
+	if err != nil {
+		return 0, err
+	}
+	if err := overrideTable(relInfo, opts); err != nil {
+		return 0, err
+	}
+
+	var (
+		filter string
+		args   []interface{}
+	)
+	if ri.Type == hasMany {
+		filter, args, err = hasManyFilter(relInfo, parentValue.Type(), pkFields)
+	} else {
+		filter, args, err = manyToManyFilter(relInfo, ri, pkFields)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	opts = opts.Clone()
+	if opts == nil {
+		opts = &Options{}
+	}
+	opts.filters = append(opts.filters, filter)
+	opts.filterArgs = append(opts.filterArgs, args...)
+	return countModels(ctx, db, related, "", opts)
+}
+
+// Returns columns of many to many mapping table referring each primary field
+// of the parent, they are listed by field setting of relation or set by ref
+// setting of primary fields, since mapping columns differ from key columns
+// (e.g. rowid)
+func getMappingColumns(ri *relationInfo, pkFields []pkFieldInfo) ([]string, error) {
+	if ri.FieldName != "" {
+		fields := strings.Split(ri.FieldName, ",")
+		if len(fields) != len(pkFields) {
+			return nil, errors.New("field count does not match count of primary fields")
+		}
+		return fields, nil
+	}
+	columns := make([]string, 0, len(pkFields))
+	for _, pk := range pkFields {
+		if pk.relationName == "" {
+			return nil, errors.Errorf("can't resolve mapping column of primary field %s, set ref or field setting", pk.name)
+		}
+		columns = append(columns, pk.relationName)
+	}
+	return columns, nil
+}
+
+// Returns columns of mapping table referring related models of many to many
+// relation, ref setting of the relation overrides ref settings of primary
+// fields of related model, so inverse relation can use the same table
+func getRelatedMappingColumns(ri *relationInfo, refs []string) ([]string, error) {
+	if ri.Ref == "" {
+		return refs, nil
+	}
+	if len(refs) != 1 {
+		return nil, errors.New("ref setting of many to many relation requires related model with single primary key")
+	}
+	return []string{ri.Ref}, nil
+}
+
+// Returns column of mapping table referring primary field of related model
+func relatedMappingColumn(ri *relationInfo, pk pkFieldInfo) string {
+	if ri.Ref != "" {
+		return ri.Ref
+	}
+	return pk.relationName
+}
+
+// Returns condition matching models of has many relation referring parent
+// with given primary key
+func hasManyFilter(relInfo *modelInfo, parentType reflect.Type, pkFields []pkFieldInfo) (string, []interface{}, error) {
+	if len(pkFields) != 1 {
+		return "", nil, errors.New("has many relation requires parent model with single primary key")
+	}
+	var (
+		keys []string
+		args []interface{}
+	)
+	for _, f := range relInfo.fields {
+		if isHasOne(f) && f.value.Type().AssignableTo(parentType) {
+			keys = append(keys, fmt.Sprintf("%s.%s = ?", relInfo.table, f.column))
+			args = append(args, pkFields[0].field.Interface())
+		}
+	}
+	if len(keys) == 0 {
+		return "", nil, errors.Errorf("related model %s does not refer parent model", relInfo.table)
+	}
+	return fmt.Sprintf("(%s)", strings.Join(keys, OR)), args, nil
+}
+
+// Returns condition matching models of many to many relation mapped to parent
+// with given primary key
+func manyToManyFilter(relInfo *modelInfo, ri *relationInfo, pkFields []pkFieldInfo) (string, []interface{}, error) {
+	var columns, refs, where []string
+	for _, f := range relInfo.fields {
+		if isPkField(f) {
+			columns = append(columns, fmt.Sprintf("%s.%s", relInfo.table, f.column))
+			refs = append(refs, f.reference.column)
+		}
+	}
+	if len(columns) == 0 {
+		return "", nil, errors.New("related model does not have primary key")
+	}
+	refs, err := getRelatedMappingColumns(ri, refs)
+	if err != nil {
+		return "", nil, err
+	}
+
+	mappingColumns, err := getMappingColumns(ri, pkFields)
+	if err != nil {
+		return "", nil, err
+	}
+	var args []interface{}
+	for i, pk := range pkFields {
+		where = append(where, fmt.Sprintf("%s = ?", mappingColumns[i]))
+		args = append(args, pk.field.Interface())
+	}
+	if ri.Condition != "" {
+		where = append(where, ri.Condition)
+	}
+	return fmt.Sprintf("(%s) in (select %s from %s where %s)",
+		strings.Join(columns, ","), strings.Join(refs, ","), ri.Table, strings.Join(where, AND)), args, nil
+}
+
+// CountInt is the same as Count but returns int
+func CountInt(db Executor, m Model, opts *Options) (int, error) {
+	count, err := Count(db, m, opts)
+	return int(count), err
 }
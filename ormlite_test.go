@@ -3,7 +3,14 @@ package ormlite
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -106,6 +113,37 @@ func (s *simpleModelFixture) TestCount() {
 	}
 }
 
+func (s *simpleModelFixture) TestCountWithoutDivider() {
+	opts := &Options{Where: Where{"id": GreaterOrEqual(1), "tagged_field": StrictString("22222")}}
+	count, err := Count(s.db, &simpleModel{}, opts)
+	require.NoError(s.T(), err)
+	assert.EqualValues(s.T(), 1, count)
+	assert.Equal(s.T(), "", opts.Divider, "options shouldn't be modified")
+
+	opts = &Options{Where: Where{"id,tagged_field": []interface{}{1, "test tagged", 3, "22222"}, "not_tagged_field": StrictString("1111")}, Divider: AND}
+	var mm []*simpleModel
+	require.NoError(s.T(), QuerySlice(s.db, opts, &mm))
+	if assert.Len(s.T(), mm, 1) {
+		assert.EqualValues(s.T(), 3, mm[0].ID)
+	}
+	assert.Equal(s.T(), AND, opts.Divider, "options shouldn't be modified")
+}
+
+func (s *simpleModelFixture) TestCountMinimalQuery() {
+	rec := &queryRecorder{Executor: s.db}
+	count, err := CountInt(rec, &simpleModel{}, DefaultOptions())
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), 3, count)
+	assert.Equal(s.T(), []string{"select count(*) from simple_model"}, rec.queries)
+
+	rec.queries = nil
+	count, err = CountInt(rec, &simpleModel{}, &Options{Where: Where{"id": 1}})
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), 1, count)
+	require.Len(s.T(), rec.queries, 1)
+	assert.Contains(s.T(), rec.queries[0], "where")
+}
+
 func (s *simpleModelFixture) TestSearchLike() {
 	var m simpleModel
 	if assert.NoError(s.T(), QueryStruct(s.db, &Options{Where: Where{"tagged_field": "2"}}, &m)) {
@@ -172,6 +210,33 @@ func (s *simpleModelFixture) TestLimit() {
 	assert.Equal(s.T(), 1, len(mm))
 }
 
+func (s *simpleModelFixture) TestNoRows() {
+	var mm []*simpleModel
+	require.NoError(s.T(), QuerySlice(s.db, &Options{NoRows: true}, &mm))
+	assert.Empty(s.T(), mm)
+
+	var count int
+	require.NoError(s.T(), QuerySliceCount(s.db, WithOffset(&Options{NoRows: true, Limit: 2}, 1), &mm, &count))
+	assert.Empty(s.T(), mm)
+	assert.NotZero(s.T(), count)
+
+	var m simpleModel
+	require.NoError(s.T(), QueryStruct(s.db, &Options{NoRows: true}, &m))
+	assert.Zero(s.T(), m.ID)
+
+	// negative limit doesn't limit rows as in sqlite
+	var all []*simpleModel
+	require.NoError(s.T(), QuerySlice(s.db, nil, &all))
+	require.NoError(s.T(), QuerySlice(s.db, WithOffset(WithLimit(DefaultOptions(), -1), 1), &mm))
+	assert.Len(s.T(), mm, len(all)-1)
+	mm = nil
+	require.NoError(s.T(), QuerySliceCount(s.db, WithLimit(DefaultOptions(), -1), &mm, &count))
+	assert.Len(s.T(), mm, len(all))
+	assert.Equal(s.T(), len(all), count)
+	require.NoError(s.T(), QueryStruct(s.db, &Options{Limit: -1, OnMultiple: LastRow}, &m))
+	assert.Equal(s.T(), all[len(all)-1].ID, m.ID)
+}
+
 func (s *simpleModelFixture) TestOffset() {
 	var mm []*simpleModel
 	assert.NoError(s.T(), QuerySlice(s.db, WithOffset(WithLimit(DefaultOptions(), 2), 1), &mm))
@@ -181,6 +246,68 @@ func (s *simpleModelFixture) TestOffset() {
 	}
 }
 
+func (s *simpleModelFixture) TestOffsetWithoutLimit() {
+	total, err := Count(s.db, &simpleModel{}, nil)
+	require.NoError(s.T(), err)
+
+	var mm []*simpleModel
+	require.NoError(s.T(), QuerySlice(s.db, WithOffset(WithOrder(DefaultOptions(), OrderBy{Field: "id", Order: "asc"}), 1), &mm))
+	assert.Len(s.T(), mm, int(total)-1)
+	for _, m := range mm {
+		assert.NotEqual(s.T(), int64(1), m.ID, "First row shouldn't be returned since offset")
+	}
+}
+
+func (s *simpleModelFixture) TestQuerySliceCountIgnoresLimit() {
+	total, err := Count(s.db, &simpleModel{}, nil)
+	require.NoError(s.T(), err)
+
+	var (
+		mm    []*simpleModel
+		count int
+	)
+	require.NoError(s.T(), QuerySliceCount(s.db, WithOffset(WithLimit(DefaultOptions(), 1), 1), &mm, &count))
+	assert.Len(s.T(), mm, 1)
+	assert.Equal(s.T(), int(total), count)
+
+	var tables int
+	require.NoError(s.T(), s.db.QueryRow("select count(*) from sqlite_temp_master").Scan(&tables))
+	assert.Zero(s.T(), tables, "counting shouldn't leave temp tables behind")
+}
+
+func (s *simpleModelFixture) TestCountLimitedToOne() {
+	total, err := Count(s.db, &simpleModel{}, nil)
+	require.NoError(s.T(), err)
+	require.True(s.T(), total > 1)
+
+	rec := &queryRecorder{Executor: s.db}
+	count, err := Count(rec, &simpleModel{}, &Options{Limit: 1})
+	require.NoError(s.T(), err)
+	assert.EqualValues(s.T(), 1, count)
+
+	count, err = Count(s.db, &simpleModel{}, &Options{Limit: 1, Where: Where{"id": -1}})
+	require.NoError(s.T(), err)
+	assert.Zero(s.T(), count)
+
+	// limited subquery is run as coroutine, so rows after the first one
+	// aren't scanned
+	require.Len(s.T(), rec.queries, 1)
+	assert.Contains(s.T(), rec.queries[0], "limit 1)")
+	rows, err := s.db.Query("explain query plan " + rec.queries[0])
+	require.NoError(s.T(), err)
+	var plan []string
+	for rows.Next() {
+		var (
+			id, parent, notUsed int
+			detail              string
+		)
+		require.NoError(s.T(), rows.Scan(&id, &parent, &notUsed, &detail))
+		plan = append(plan, detail)
+	}
+	require.NoError(s.T(), rows.Err())
+	assert.Contains(s.T(), plan, "CO-ROUTINE 1")
+}
+
 func (s *simpleModelFixture) TestOrderBy() {
 	var mm []*simpleModel
 	require.NoError(s.T(), QuerySlice(s.db, WithOrder(DefaultOptions(), OrderBy{Field: "rowid", Order: "desc"}), &mm))
@@ -195,10 +322,732 @@ func (s *simpleModelFixture) TestOrderByWithLimit() {
 	assert.NotEqual(s.T(), int64(2), mm[0].ID)
 }
 
+func (s *simpleModelFixture) TestCloneOptions() {
+	base := DefaultOptions()
+	byID := CloneWithWhere(base, Where{"id": 1})
+	limited := CloneWithLimit(base, 2)
+
+	assert.Nil(s.T(), base.Where)
+	assert.Zero(s.T(), base.Limit)
+	assert.Zero(s.T(), byID.Limit)
+
+	var mm []*simpleModel
+	require.NoError(s.T(), QuerySlice(s.db, byID, &mm))
+	assert.Len(s.T(), mm, 1)
+
+	mm = nil
+	require.NoError(s.T(), QuerySlice(s.db, limited, &mm))
+	assert.Len(s.T(), mm, 2)
+
+	clone := byID.Clone()
+	clone.Where["tagged_field"] = "test"
+	assert.Len(s.T(), byID.Where, 1)
+}
+
 func TestSimpleModel(t *testing.T) {
 	suite.Run(t, new(simpleModelFixture))
 }
 
+func TestWhereAnyOf(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(`
+		create table simple_model(id integer primary key, not_tagged_field text, tagged_field text);
+		insert into simple_model(not_tagged_field, tagged_field) values
+			('x', 'one'), ('y', 'two'), ('x', 'three'), ('x', 'four'), ('x', 'five');
+	`)
+	require.NoError(t, err)
+
+	var mm []*simpleModel
+	require.NoError(t, QuerySlice(db, &Options{
+		Where:   Where{"id": AnyOf(1, GreaterOrEqual(4), 2), "not_tagged_field": StrictString("x")},
+		Divider: AND,
+	}, &mm))
+	var ids []int64
+	for _, m := range mm {
+		ids = append(ids, m.ID)
+	}
+	assert.Equal(t, []int64{1, 4, 5}, ids)
+
+	count, err := Count(db, &simpleModel{}, &Options{Where: Where{"tagged_field": AnyOf("thr", StrictString("two"), nil)}})
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, count)
+}
+
+type trashParent struct {
+	ID       int64 `ormlite:"primary"`
+	Name     string
+	Children []*trashChild `ormlite:"has_many"`
+}
+
+func (*trashParent) Table() string { return "trash_parents" }
+
+type trashChild struct {
+	ID        int64        `ormlite:"primary"`
+	Parent    *trashParent `ormlite:"has_one,col=parent_id"`
+	Name      string
+	DeletedAt sql.NullTime `ormlite:"softdelete"`
+}
+
+func (*trashChild) Table() string { return "trash_children" }
+
+func TestSoftDelete(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(`
+		create table trash_parents(id integer primary key, name text);
+		create table trash_children(id integer primary key, parent_id int, name text, deleted_at timestamp);
+		insert into trash_parents(name) values ('parent'), ('other');
+		insert into trash_children(parent_id, name) values (1, 'a'), (1, 'b'), (1, 'c'), (2, 'd');
+	`)
+	require.NoError(t, err)
+
+	res, err := Delete(db, &trashChild{ID: 2})
+	require.NoError(t, err)
+	affected, err := res.RowsAffected()
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, affected)
+
+	var deletedAt sql.NullTime
+	require.NoError(t, db.QueryRow("select deleted_at from trash_children where id = 2").Scan(&deletedAt))
+	assert.True(t, deletedAt.Valid, "row should be marked instead of deleted")
+
+	res, err = Delete(db, &trashChild{ID: 2})
+	require.NoError(t, err)
+	affected, err = res.RowsAffected()
+	require.NoError(t, err)
+	assert.EqualValues(t, 0, affected, "row can be deleted only once")
+
+	var p trashParent
+	require.NoError(t, QueryStruct(db, &Options{Where: Where{"id": 1}, RelationDepth: 1}, &p))
+	assert.Len(t, p.Children, 2)
+
+	p = trashParent{}
+	require.NoError(t, QueryStruct(db, &Options{Where: Where{"id": 1}, RelationDepth: 1, WithTrashed: true}, &p))
+	assert.Len(t, p.Children, 3)
+
+	var pp []*trashParent
+	require.NoError(t, QuerySlice(db, DefaultOptions(), &pp))
+	require.Len(t, pp, 2)
+	assert.Len(t, pp[0].Children, 2)
+	assert.Len(t, pp[1].Children, 1)
+
+	pp = nil
+	require.NoError(t, QuerySlice(db, &Options{RelationDepth: 1, WithTrashed: true}, &pp))
+	require.Len(t, pp, 2)
+	assert.Len(t, pp[0].Children, 3)
+
+	count, err := Count(db, &trashChild{}, nil)
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, count)
+	count, err = Count(db, &trashChild{}, &Options{WithTrashed: true})
+	require.NoError(t, err)
+	assert.EqualValues(t, 4, count)
+
+	// trashed rows stay hidden whatever divider glues conditions
+	var cc []*trashChild
+	require.NoError(t, QuerySlice(db, &Options{Where: Where{"id": 2, "name": StrictString("b")}, Divider: OR}, &cc))
+	assert.Empty(t, cc)
+}
+
+func TestSharedOptionsConcurrentQueries(t *testing.T) {
+	// queries run on separate connections, so they aren't serialized by pool
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "shared.db"))
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(`
+		create table trash_parents(id integer primary key, name text);
+		create table trash_children(id integer primary key, parent_id int, name text, deleted_at timestamp);
+		insert into trash_parents(name) values ('first'), ('second');
+		insert into trash_children(parent_id, name, deleted_at) values (1, 'a', null), (2, 'b', current_timestamp);
+	`)
+	require.NoError(t, err)
+
+	// per query state is never written to shared options
+	parentOpts := &Options{Exists: map[string]Where{"children": {}}, RelationDepth: 1}
+	childOpts := &Options{Where: Where{"name": StrictString("a")}}
+	var (
+		wg    sync.WaitGroup
+		start = make(chan struct{})
+	)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			for k := 0; k < 20; k++ {
+				var pp []*trashParent
+				assert.NoError(t, QuerySlice(db, parentOpts, &pp))
+				assert.Len(t, pp, 1)
+				count, err := Count(db, &trashChild{}, childOpts)
+				assert.NoError(t, err)
+				assert.EqualValues(t, 1, count)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	assert.Empty(t, parentOpts.filters)
+	assert.Empty(t, childOpts.softDelete)
+}
+
+func TestWhereExists(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(`
+		create table trash_parents(id integer primary key, name text);
+		create table trash_children(id integer primary key, parent_id int, name text, deleted_at timestamp);
+		insert into trash_parents(name) values ('first'), ('second'), ('childless');
+		insert into trash_children(parent_id, name, deleted_at) values
+			(1, 'a', null), (1, 'b', current_timestamp), (2, 'b', null), (2, 'b', null);
+	`)
+	require.NoError(t, err)
+
+	names := func(opts *Options) []string {
+		var pp []*trashParent
+		require.NoError(t, QuerySlice(db, opts, &pp))
+		var names []string
+		for _, p := range pp {
+			names = append(names, p.Name)
+		}
+		return names
+	}
+
+	assert.Equal(t, []string{"second"},
+		names(&Options{Exists: map[string]Where{"children": {"name": StrictString("b")}}}))
+	assert.Equal(t, []string{"first", "second"},
+		names(&Options{Exists: map[string]Where{"children": {"name": StrictString("b")}}, WithTrashed: true}))
+	assert.Equal(t, []string{"first", "second"},
+		names(&Options{Exists: map[string]Where{"children": nil}}))
+	// exists filter is applied regardless of divider
+	assert.Equal(t, []string{"first"},
+		names(&Options{Where: Where{"name": "i", "id": 1}, Divider: OR, Exists: map[string]Where{"children": {"name": "a"}}}))
+
+	count, err := Count(db, &trashParent{}, &Options{Exists: map[string]Where{"children": nil}})
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, count)
+
+	_, err = Count(db, &trashParent{}, &Options{Exists: map[string]Where{"unknown": nil}})
+	assert.Error(t, err)
+}
+
+func TestOrderByMultipleColumns(t *testing.T) {
+	opts := WithOrders(&Options{Limit: 3}, OrderBy{Field: "not_tagged_field", Order: "asc"}, OrderBy{Field: "id", Order: "desc"})
+	assert.Equal(t, " order by not_tagged_field asc, id desc limit 3", orderLimitClause(opts))
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(`
+		create table simple_model(id integer primary key, not_tagged_field text, tagged_field text);
+		insert into simple_model(not_tagged_field) values ('b'), ('a'), ('b'), ('a');
+	`)
+	require.NoError(t, err)
+
+	var mm []*simpleModel
+	require.NoError(t, QuerySlice(db, opts.Clone(), &mm))
+	var ids []int64
+	for _, m := range mm {
+		ids = append(ids, m.ID)
+	}
+	assert.Equal(t, []int64{4, 2, 3}, ids)
+
+	assert.Error(t, QuerySlice(db, WithOrders(&Options{}, OrderBy{Field: "id", Order: "sideways"}), &mm))
+	assert.Error(t, QuerySlice(db, WithOrders(&Options{}, OrderBy{Field: "id"}, OrderBy{Field: "id; drop table simple_model"}), &mm))
+	assert.Error(t, QuerySlice(db, &Options{ThenBy: []OrderBy{{Field: "id"}}}, &mm))
+}
+
+type sparseAuthor struct {
+	ID    int64 `ormlite:"primary"`
+	Name  string
+	Bio   string
+	Books []*sparseBook `ormlite:"has_many"`
+}
+
+func (*sparseAuthor) Table() string { return "authors" }
+
+type sparseBook struct {
+	ID     int64 `ormlite:"primary"`
+	Title  string
+	Pages  int
+	Author *sparseAuthor `ormlite:"has_one,col=author_id"`
+}
+
+func (*sparseBook) Table() string { return "books" }
+
+func TestRelationColumns(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(`
+		create table authors(id integer primary key, name text, bio text);
+		create table books(id integer primary key, title text, pages int, author_id int);
+		insert into authors(name, bio) values ('john', 'long story'), ('pete', 'another story');
+		insert into books(title, pages, author_id) values ('first', 100, 1), ('second', 200, 1), ('third', 300, 2);
+	`)
+	require.NoError(t, err)
+
+	var b sparseBook
+	require.NoError(t, QueryStruct(db, &Options{
+		Where:           Where{"id": 1},
+		RelationDepth:   1,
+		RelationColumns: map[string]map[string]struct{}{"author_id": {"name": {}}},
+	}, &b))
+	assert.Equal(t, "first", b.Title)
+	if assert.NotNil(t, b.Author) {
+		assert.EqualValues(t, 1, b.Author.ID)
+		assert.Equal(t, "john", b.Author.Name)
+		assert.Empty(t, b.Author.Bio)
+	}
+
+	var aa []*sparseAuthor
+	require.NoError(t, QuerySlice(db, &Options{
+		RelationDepth:   1,
+		RelationColumns: map[string]map[string]struct{}{"books": {"title": {}}},
+	}, &aa))
+	require.Len(t, aa, 2)
+	assert.Equal(t, "long story", aa[0].Bio)
+	if assert.Len(t, aa[0].Books, 2) {
+		assert.Equal(t, "second", aa[0].Books[1].Title)
+		assert.Zero(t, aa[0].Books[1].Pages)
+	}
+	assert.Len(t, aa[1].Books, 1)
+}
+
+type scopedTag struct {
+	ID     int64 `ormlite:"primary,ref=tag_id"`
+	Name   string
+	Active bool
+}
+
+func (*scopedTag) Table() string { return "tags" }
+
+type scopedPost struct {
+	ID   int64        `ormlite:"primary,ref=post_id"`
+	Tags []*scopedTag `ormlite:"many_to_many,table=post_tags"`
+}
+
+func (*scopedPost) Table() string { return "posts" }
+
+func TestRelationWhere(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(`
+		create table authors(id integer primary key, name text, bio text);
+		create table books(id integer primary key, title text, pages int, author_id int);
+		insert into authors(name, bio) values ('john', 'long story'), ('pete', 'another story');
+		insert into books(title, pages, author_id) values ('first', 100, 1), ('second', 200, 1), ('third', 300, 2);
+		create table tags(id integer primary key, name text, active bool);
+		create table posts(id integer primary key);
+		create table post_tags(post_id int, tag_id int);
+		insert into tags(name, active) values ('go', 1), ('old', 0), ('sql', 1);
+		insert into posts(id) values (1);
+		insert into post_tags(post_id, tag_id) values (1, 1), (1, 2), (1, 3);
+	`)
+	require.NoError(t, err)
+
+	var aa []*sparseAuthor
+	require.NoError(t, QuerySlice(db, &Options{
+		RelationDepth: 1,
+		RelationWhere: map[string]Where{"books": {"pages": Greater(150)}},
+	}, &aa))
+	require.Len(t, aa, 2)
+	if assert.Len(t, aa[0].Books, 1) {
+		assert.Equal(t, "second", aa[0].Books[0].Title)
+	}
+	assert.Len(t, aa[1].Books, 1)
+
+	var a sparseAuthor
+	require.NoError(t, QueryStruct(db, &Options{
+		Where:         Where{"id": 1},
+		RelationDepth: 1,
+		RelationWhere: map[string]Where{"books": {"pages": Less(150)}},
+	}, &a))
+	if assert.Len(t, a.Books, 1) {
+		assert.Equal(t, "first", a.Books[0].Title)
+	}
+
+	// conditions are glued with AND regardless of divider
+	var p scopedPost
+	require.NoError(t, QueryStruct(db, &Options{
+		Where:         Where{"id": 1},
+		Divider:       OR,
+		RelationDepth: 1,
+		RelationWhere: map[string]Where{"tags": {"active": true}},
+	}, &p))
+	var names []string
+	for _, tag := range p.Tags {
+		names = append(names, tag.Name)
+	}
+	assert.Equal(t, []string{"go", "sql"}, names)
+
+	assert.Error(t, QueryStruct(db, &Options{
+		Where:         Where{"id": 1},
+		RelationDepth: 1,
+		RelationWhere: map[string]Where{"tags": {"missing": true}},
+	}, &p))
+}
+
+func TestWhereSliceWithLimit(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(`
+		create table simple_model(id integer primary key, not_tagged_field text, tagged_field text);
+		insert into simple_model(not_tagged_field) values ('a'), ('b'), ('c'), ('d'), ('e'), ('f');
+	`)
+	require.NoError(t, err)
+
+	var (
+		mm    []*simpleModel
+		count int
+		opts  = &Options{Where: Where{"id": []int64{6, 5, 4, 3, 2}}, Limit: 2, OrderBy: &OrderBy{Field: "id", Order: "desc"}}
+	)
+	require.NoError(t, QuerySliceCount(db, opts, &mm, &count))
+	assert.Equal(t, 5, count)
+	if assert.Len(t, mm, 2) {
+		assert.EqualValues(t, 6, mm[0].ID)
+		assert.EqualValues(t, 5, mm[1].ID)
+	}
+
+	mm = nil
+	opts = &Options{Where: Where{"id": []int64{1, 3, 5, 7, 9}}, Limit: 2, Offset: 1}
+	require.NoError(t, QuerySlice(db, opts, &mm))
+	if assert.Len(t, mm, 2) {
+		assert.EqualValues(t, 3, mm[0].ID)
+		assert.EqualValues(t, 5, mm[1].ID)
+	}
+}
+
+type compactAuthor struct {
+	ID      int64 `ormlite:"primary"`
+	Name    string
+	BookIDs []int64 `ormlite:"has_many,concat,table=books,field=author_id,ref=id"`
+	Tags    string  `ormlite:"many_to_many,concat,table=author_tags,field=author_id,ref=tag"`
+}
+
+func (*compactAuthor) Table() string { return "authors" }
+
+func TestConcatRelation(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(`
+		create table authors(id integer primary key, name text);
+		create table books(id integer primary key, title text, author_id int);
+		create table author_tags(author_id int, tag text);
+		insert into authors(name) values ('john'), ('pete');
+		insert into books(id, title, author_id) values (3, 'third', 1), (1, 'first', 1), (2, 'second', 2);
+		insert into author_tags(author_id, tag) values (1, 'sql'), (1, 'go');
+	`)
+	require.NoError(t, err)
+
+	var aa []*compactAuthor
+	require.NoError(t, QuerySlice(db, DefaultOptions(), &aa))
+	require.Len(t, aa, 2)
+	assert.Equal(t, []int64{1, 3}, aa[0].BookIDs)
+	assert.Equal(t, "go,sql", aa[0].Tags)
+	assert.Equal(t, []int64{2}, aa[1].BookIDs)
+	assert.Empty(t, aa[1].Tags)
+
+	var a compactAuthor
+	require.NoError(t, QueryStruct(db, &Options{Where: Where{"id": 2}, RelationDepth: 1}, &a))
+	assert.Equal(t, []int64{2}, a.BookIDs)
+
+	// keys are read only, so saving model doesn't touch related rows
+	a.Name, a.BookIDs = "peter", nil
+	require.NoError(t, Upsert(db, &a))
+	var count int
+	require.NoError(t, db.QueryRow("select count(*) from books where author_id = 2").Scan(&count))
+	assert.Equal(t, 1, count)
+}
+
+type partitionedEvent struct {
+	ID   int64 `ormlite:"primary"`
+	Name string
+}
+
+func (*partitionedEvent) Table() string { return "events" }
+
+func TestTableOverride(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(`
+		create table events_2023(id integer primary key, name text);
+		create table events_2024(id integer primary key, name text);
+	`)
+	require.NoError(t, err)
+
+	for _, e := range []struct {
+		table string
+		name  string
+	}{{"events_2023", "old"}, {"events_2024", "new"}, {"events_2024", "newer"}} {
+		require.NoError(t, Upsert(db, InTable(&partitionedEvent{Name: e.name}, e.table)))
+	}
+
+	var ee []*partitionedEvent
+	require.NoError(t, QuerySlice(db, &Options{Table: "events_2023"}, &ee))
+	if assert.Len(t, ee, 1) {
+		assert.Equal(t, "old", ee[0].Name)
+	}
+	ee = nil
+	require.NoError(t, QuerySlice(db, &Options{Table: "events_2024", Where: Where{"id": Greater(0)}}, &ee))
+	assert.Len(t, ee, 2)
+
+	var e partitionedEvent
+	require.NoError(t, QueryStruct(db, &Options{Table: "events_2024", Where: Where{"id": 2}}, &e))
+	assert.Equal(t, "newer", e.Name)
+
+	e.Name = "renamed"
+	require.NoError(t, Update(db, InTable(&e, "events_2024")))
+	var renamed partitionedEvent
+	require.NoError(t, QueryStruct(db, &Options{Table: "events_2024", Where: Where{"id": 2}}, &renamed))
+	assert.Equal(t, "renamed", renamed.Name)
+	_, err = Delete(db, InTable(&partitionedEvent{ID: 1}, "events_2024"))
+	require.NoError(t, err)
+
+	count, err := Count(db, &partitionedEvent{}, &Options{Table: "events_2024"})
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, count)
+	count, err = Count(db, &partitionedEvent{}, &Options{Table: "events_2023", Where: Where{"name": StrictString("old")}})
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, count)
+
+	assert.Error(t, QuerySlice(db, &Options{Table: "events; drop table events_2023"}, &ee))
+	assert.Error(t, Upsert(db, InTable(&partitionedEvent{}, "events_2023 --")))
+	assert.Error(t, QuerySlice(db, &Options{}, &ee), "model table doesn't exist")
+
+	// table can't be set by decoded options
+	var decoded Options
+	require.NoError(t, json.Unmarshal([]byte(`{"table": "sqlite_master"}`), &decoded))
+	assert.Empty(t, decoded.Table)
+}
+
+func TestWhereNullSafeEqual(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(`
+		create table simple_model(id integer primary key, not_tagged_field text, tagged_field text);
+		insert into simple_model(not_tagged_field, tagged_field) values ('a', null), ('b', 'x'), ('c', 'y');
+	`)
+	require.NoError(t, err)
+
+	find := func(value *string) []string {
+		var mm []*simpleModel
+		require.NoError(t, QuerySlice(db, &Options{Where: Where{"tagged_field": NullSafeEqual(value)}}, &mm))
+		var res []string
+		for _, m := range mm {
+			res = append(res, m.NotTaggedField)
+		}
+		return res
+	}
+
+	x := "x"
+	assert.Equal(t, []string{"a"}, find(nil))
+	assert.Equal(t, []string{"b"}, find(&x))
+
+	count, err := Count(db, &simpleModel{}, &Options{Where: Where{"tagged_field": NullSafeEqual(nil)}})
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, count)
+}
+
+func TestCountRelated(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(new(autoCreateRelatedFixture).Query())
+	require.NoError(t, err)
+	_, err = db.Exec(`
+		insert into main_model(name) values ('first'), ('second');
+		insert into has_many_model(related_id) values (1), (1), (1), (2);
+		insert into many_to_many_model(field) values ('a'), ('b'), ('c');
+		insert into mapping_table(m_id, m2_id) values (1, 1), (1, 2), (2, 3);
+	`)
+	require.NoError(t, err)
+
+	parent := &autoCreateRelatedModel{ID: 1}
+	count, err := CountRelated(db, parent, "related_has_many", nil)
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, count)
+
+	count, err = CountRelated(db, parent, "related_has_many", &Options{Where: Where{"id": Greater(1)}, Divider: OR})
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, count)
+
+	count, err = CountRelated(db, parent, "related_many_to_many", nil)
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, count)
+
+	count, err = CountRelated(db, parent, "related_many_to_many", &Options{Where: Where{"field": StrictString("b")}})
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, count)
+
+	_, err = CountRelated(db, parent, "name", nil)
+	assert.Error(t, err)
+	_, err = CountRelated(db, &autoCreateRelatedModel{}, "related_has_many", nil)
+	assert.Error(t, err)
+}
+
+type period struct {
+	ID      int64 `ormlite:"primary"`
+	StartAt int64
+	EndAt   int64
+}
+
+func (*period) Table() string { return "periods" }
+
+func TestWhereColumns(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(`
+		create table periods(id integer primary key, start_at int, end_at int);
+		insert into periods(start_at, end_at) values (1, 5), (7, 3), (4, 4), (2, 9);
+	`)
+	require.NoError(t, err)
+
+	var pp []*period
+	require.NoError(t, QuerySlice(db, &Options{Where: Where{"start_at": Less(Col("end_at"))}}, &pp))
+	var ids []int64
+	for _, p := range pp {
+		ids = append(ids, p.ID)
+	}
+	assert.Equal(t, []int64{1, 4}, ids)
+
+	count, err := Count(db, &period{}, &Options{Where: Where{"periods.start_at": Col("end_at")}})
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, count)
+
+	var p period
+	require.NoError(t, QueryStruct(db, &Options{Where: Where{"end_at": Less(Col("start_at"))}}, &p))
+	assert.EqualValues(t, 2, p.ID)
+
+	count, err = Count(db, &period{}, &Options{Where: Where{"start_at": AnyOf(GreaterOrEqual(Col("end_at")), 2)}})
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, count)
+	count, err = Count(db, &period{}, &Options{Where: Where{"start_at": NotEqual(Col("end_at")), "end_at": Greater(4)}})
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, count)
+
+	err = QuerySlice(db, &Options{Where: Where{"start_at": Less(Col("end_at; drop table periods"))}}, &pp)
+	assert.Error(t, err)
+	_, err = Count(db, &period{}, &Options{Where: Where{"unknown": Col("end_at")}})
+	assert.Error(t, err)
+	_, err = Count(db, &period{}, &Options{Where: Where{"unknown": LessOrEqual(Col("end_at"))}})
+	assert.Error(t, err)
+}
+
+type timedEvent struct {
+	ID        int64 `ormlite:"primary"`
+	Name      string
+	CreatedAt time.Time `ormlite:"time=rfc3339"`
+	Epoch     time.Time `ormlite:"time=unix"`
+}
+
+func (*timedEvent) Table() string { return "timed_events" }
+
+func TestWhereTimeRange(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(`create table timed_events(id integer primary key, name text, created_at text, epoch int)`)
+	require.NoError(t, err)
+
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i, name := range []string{"first", "second", "third", "fourth"} {
+		at := base.Add(time.Duration(i) * time.Hour)
+		require.NoError(t, Insert(db, &timedEvent{Name: name, CreatedAt: at, Epoch: at}))
+	}
+
+	var stored string
+	require.NoError(t, db.QueryRow("select created_at from timed_events where id = 2").Scan(&stored))
+	assert.Equal(t, "2024-03-01T13:00:00.000000000Z", stored)
+
+	names := func(opts *Options) []string {
+		var mm []*timedEvent
+		require.NoError(t, QuerySlice(db, opts, &mm))
+		var res []string
+		for _, m := range mm {
+			res = append(res, m.Name)
+		}
+		return res
+	}
+
+	// operand in other time zone is normalized to the stored format
+	local := time.FixedZone("UTC+3", 3*60*60)
+	assert.Equal(t, []string{"second", "third"}, names(&Options{Where: Where{
+		"created_at": Between{base.Add(time.Hour).In(local), base.Add(2 * time.Hour)}}}))
+	assert.Equal(t, []string{"third", "fourth"}, names(&Options{Where: Where{"epoch": After(base.Add(time.Hour))}}))
+	assert.Equal(t, []string{"first"}, names(&Options{Where: Where{"created_at": Before(base.Add(time.Hour))}}))
+
+	var m timedEvent
+	require.NoError(t, QueryStruct(db, &Options{Where: Where{"created_at": base.Add(3 * time.Hour)}}, &m))
+	assert.Equal(t, "fourth", m.Name)
+	assert.True(t, base.Add(3*time.Hour).Equal(m.CreatedAt))
+	assert.True(t, base.Add(3*time.Hour).Equal(m.Epoch))
+
+	// fractional seconds are kept and ordered
+	precise := base.Add(3*time.Hour + 500*time.Millisecond)
+	require.NoError(t, Insert(db, &timedEvent{Name: "fifth", CreatedAt: precise, Epoch: precise}))
+	assert.Equal(t, []string{"fifth"}, names(&Options{Where: Where{"created_at": After(base.Add(3 * time.Hour))}}))
+	m = timedEvent{}
+	require.NoError(t, QueryStruct(db, &Options{Where: Where{"name": "fifth"}}, &m))
+	assert.True(t, precise.Equal(m.CreatedAt))
+}
+
+type badTimeEvent struct {
+	ID        int64     `ormlite:"primary"`
+	CreatedAt time.Time `ormlite:"time=RFC3339"`
+}
+
+func (*badTimeEvent) Table() string { return "bad_time_events" }
+
+func TestUnknownTimeStorage(t *testing.T) {
+	_, err := getModelInfo(&badTimeEvent{})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "CreatedAt")
+	}
+}
+
+func TestSearchLikeEscaping(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(`
+		create table simple_model(id integer primary key, not_tagged_field text, tagged_field text);
+		insert into simple_model(not_tagged_field, tagged_field) values
+			('', '50% off'), ('', '500 off'), ('', 'a_b'), ('', 'axb'), ('', 'back\slash');
+	`)
+	require.NoError(t, err)
+
+	for search, expected := range map[string][]string{
+		"%":  {"50% off"},
+		"0%": {"50% off"},
+		"_":  {"a_b"},
+		`\`:  {`back\slash`},
+		"0 ": {"500 off"},
+	} {
+		var mm []*simpleModel
+		require.NoError(t, QuerySlice(db, &Options{Where: Where{"tagged_field": search}}, &mm))
+		var found []string
+		for _, m := range mm {
+			found = append(found, m.TaggedField)
+		}
+		assert.Equal(t, expected, found, "search for %q", search)
+	}
+}
+
 type modelWithCompoundPrimaryKey struct {
 	FirstID  int64 `ormlite:"primary,col=first_id,ref=first_id_ref"`
 	SecondID int64 `ormlite:"primary,col=second_id,ref=second_id_ref"`
@@ -248,6 +1097,34 @@ func (s *modelWithCompoundPrimaryKeyFixture) TestBRead() {
 	assert.Equal(s.T(), 3, len(mm))
 }
 
+func (s *modelWithCompoundPrimaryKeyFixture) TestCInTuples() {
+	var mm []*modelWithCompoundPrimaryKey
+	opts := &Options{Where: Where{"first_id,second_id": InTuples{{1, 2}, {2, 1}}}, OrderBy: &OrderBy{Field: "first_id", Order: "asc"}}
+	require.NoError(s.T(), QuerySlice(s.db, opts, &mm))
+	if assert.Len(s.T(), mm, 2) {
+		assert.Equal(s.T(), "1", mm[0].Field)
+		assert.Equal(s.T(), "3", mm[1].Field)
+	}
+
+	count, err := Count(s.db, &modelWithCompoundPrimaryKey{}, &Options{Where: Where{
+		"first_id,second_id": InTuples{{1, 2}, {2, 1}}, "field": StrictString("3")}})
+	require.NoError(s.T(), err)
+	assert.EqualValues(s.T(), 1, count)
+
+	count, err = Count(s.db, &modelWithCompoundPrimaryKey{}, &Options{Where: Where{"first_id,second_id": InTuples{}}})
+	require.NoError(s.T(), err)
+	assert.Zero(s.T(), count)
+
+	// tuples must have value of every key column
+	mm = nil
+	err = QuerySlice(s.db, &Options{Where: Where{"first_id,second_id": InTuples{{1, 2}, {2}}}}, &mm)
+	if assert.Error(s.T(), err) {
+		assert.Contains(s.T(), err.Error(), "tuple 1")
+	}
+	_, err = Count(s.db, &modelWithCompoundPrimaryKey{}, &Options{Where: Where{"first_id,second_id": InTuples{{1, 2, 3}}}})
+	assert.Error(s.T(), err)
+}
+
 func (s *modelWithCompoundPrimaryKeyFixture) TestCUpdate() {
 	assert.NoError(s.T(), Upsert(s.db, &modelWithCompoundPrimaryKey{1, 1, "4"}))
 	var m modelWithCompoundPrimaryKey
@@ -372,6 +1249,116 @@ func (s *hasOneRelationFixture) TestRelationalDepth() {
 	assert.Nil(s.T(), cms[0].Related.Related.Related)
 }
 
+func (s *hasOneRelationFixture) TestJoinRelations() {
+	rec := &queryRecorder{Executor: s.db}
+	var mm []*modelHasOne
+	require.NoError(s.T(), QuerySlice(rec, &Options{RelationDepth: 1, JoinRelations: true, OrderBy: &OrderBy{Field: "rowid"}}, &mm))
+	assert.Len(s.T(), rec.queries, 1, "related models are scanned from the same rows")
+	if assert.Len(s.T(), mm, 2) {
+		assert.Equal(s.T(), &relatedModel{ID: 1, Field: "test"}, mm[0].Related)
+		assert.Nil(s.T(), mm[1].Related)
+	}
+
+	// results are the same as of separate queries
+	var separate []*modelHasOne
+	require.NoError(s.T(), QuerySlice(s.db, &Options{RelationDepth: 1, OrderBy: &OrderBy{Field: "rowid"}}, &separate))
+	assert.Equal(s.T(), separate, mm)
+
+	// columns of related models are restricted like for separate queries
+	mm, rec.queries = nil, nil
+	opts := &Options{
+		RelationDepth:   1,
+		JoinRelations:   true,
+		Where:           Where{"rel_id": 1},
+		RelationColumns: map[string]map[string]struct{}{"rel_id": {}},
+	}
+	var count int
+	require.NoError(s.T(), QuerySliceCount(rec, opts, &mm, &count))
+	assert.Len(s.T(), rec.queries, 2, "count and select queries")
+	assert.Equal(s.T(), 1, count)
+	if assert.Len(s.T(), mm, 1) {
+		assert.Equal(s.T(), &relatedModel{ID: 1}, mm[0].Related)
+	}
+
+	// related models having relations are loaded with separate queries
+	var cms []*modelHasOneCycle
+	rec.queries = nil
+	require.NoError(s.T(), QuerySlice(rec, &Options{RelationDepth: 2, JoinRelations: true}, &cms))
+	assert.Len(s.T(), rec.queries, 3)
+	if assert.Len(s.T(), cms, 1) {
+		assert.NotNil(s.T(), cms[0].Related.Related)
+	}
+}
+
+type joinedAuthor struct {
+	ID   int64 `ormlite:"primary"`
+	Name string
+}
+
+func (*joinedAuthor) Table() string { return "joined_authors" }
+
+type joinedBook struct {
+	ID     int64 `ormlite:"primary"`
+	Name   string
+	Author *joinedAuthor `ormlite:"has_one,col=author_id"`
+}
+
+func (*joinedBook) Table() string { return "joined_books" }
+
+func TestJoinRelationsUnqualifiedColumns(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Exec(`
+		create table joined_authors(id integer primary key, name text);
+		create table joined_books(id integer primary key, name text, author_id int);
+		insert into joined_authors(name) values ('b'), ('a');
+		insert into joined_books(name, author_id) values ('a', 1), ('b', 2), ('c', 1);
+	`)
+	require.NoError(t, err)
+
+	// columns of the model aren't ambiguous with ones of joined table
+	var books []*joinedBook
+	require.NoError(t, QuerySlice(db, &Options{
+		RelationDepth: 1,
+		JoinRelations: true,
+		Where:         Where{"name": []string{"b", "c"}},
+		OrderBy:       &OrderBy{Field: "id", Order: "desc"},
+		GroupBy:       []string{"id"},
+	}, &books))
+	if assert.Len(t, books, 2) {
+		assert.Equal(t, &joinedBook{ID: 3, Name: "c", Author: &joinedAuthor{ID: 1, Name: "b"}}, books[0])
+		assert.Equal(t, &joinedBook{ID: 2, Name: "b", Author: &joinedAuthor{ID: 2, Name: "a"}}, books[1])
+	}
+
+	var count int
+	books = nil
+	require.NoError(t, QuerySliceCount(db, &Options{
+		RelationDepth: 1,
+		JoinRelations: true,
+		Where:         Where{"name": StrictString("a")},
+	}, &books, &count))
+	assert.Equal(t, 1, count)
+	if assert.Len(t, books, 1) {
+		assert.Equal(t, "b", books[0].Author.Name)
+	}
+}
+
+func (s *hasOneRelationFixture) TestMaxRelationDepth() {
+	var cm modelHasOneCycle
+	err := QueryStruct(s.db, &Options{RelationDepth: 1000}, &cm)
+	require.Error(s.T(), err)
+	assert.True(s.T(), IsRelationDepthExceeded(err))
+
+	var cms []*modelHasOneCycle
+	err = QuerySlice(s.db, &Options{RelationDepth: 1000}, &cms)
+	require.Error(s.T(), err)
+	assert.True(s.T(), IsRelationDepthExceeded(err))
+
+	require.NoError(s.T(), QueryStruct(s.db, &Options{RelationDepth: MaxRelationDepth}, &cm))
+}
+
 func (s *hasOneRelationFixture) TestWithIDRelatedModel() {
 	var m modelHasOneWithIDAndRef
 	assert.NoError(s.T(), QueryStructContext(
@@ -379,6 +1366,19 @@ func (s *hasOneRelationFixture) TestWithIDRelatedModel() {
 	assert.NotNil(s.T(), m.Related)
 }
 
+func (s *hasOneRelationFixture) TestWithoutRelations() {
+	var m modelHasOneCycle
+	require.NoError(s.T(), QueryStruct(s.db, WithoutRelations(WithWhere(DefaultOptions(), Where{"rowid": 1})), &m))
+	assert.Equal(s.T(), int64(1), m.ID)
+	assert.Nil(s.T(), m.Related)
+
+	var mm []*modelHasOneCycle
+	require.NoError(s.T(), QuerySlice(s.db, WithoutRelations(WithWhere(DefaultOptions(), Where{"rowid": 1})), &mm))
+	if assert.Len(s.T(), mm, 1) {
+		assert.Nil(s.T(), mm[0].Related)
+	}
+}
+
 func TestHasOneRelation(t *testing.T) {
 	suite.Run(t, new(hasOneRelationFixture))
 }
@@ -443,6 +1443,118 @@ func TestHasManyRelation(t *testing.T) {
 	suite.Run(t, new(hasManyModelFixture))
 }
 
+func TestHasManyBatchLoading(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`
+		create table has_many_model (name text);
+		create table relating_model (related_id int);
+	`)
+	require.NoError(t, err)
+
+	const parents = 50
+	for i := 1; i <= parents; i++ {
+		_, err = db.Exec(`insert into has_many_model (name) values (?)`, fmt.Sprintf("parent %d", i))
+		require.NoError(t, err)
+		for j := 0; j < i%3; j++ {
+			_, err = db.Exec(`insert into relating_model (related_id) values (?)`, i)
+			require.NoError(t, err)
+		}
+	}
+
+	rec := &queryRecorder{Executor: db}
+	var mm []*hasManyModel
+	require.NoError(t, QuerySlice(rec, DefaultOptions(), &mm))
+	require.Len(t, mm, parents)
+	for _, m := range mm {
+		assert.Len(t, m.Related, int(m.ID%3), "parent %d", m.ID)
+	}
+	// one query for parents and one for all of their related models
+	assert.Len(t, rec.queries, 2)
+
+	// limit restricts related models per parent, so they are loaded one by one
+	rec.queries = nil
+	mm = nil
+	require.NoError(t, QuerySlice(rec, WithLimit(DefaultOptions(), 5), &mm))
+	require.Len(t, mm, 5)
+	assert.Len(t, rec.queries, 6)
+}
+
+type orderedChild struct {
+	ID     int64          `ormlite:"primary"`
+	Parent *orderedParent `ormlite:"has_one,col=parent_id"`
+	Name   string
+}
+
+func (*orderedChild) Table() string { return "ordered_child" }
+
+type orderedParent struct {
+	ID       int64           `ormlite:"primary"`
+	Children []*orderedChild `ormlite:"has_many"`
+}
+
+func (*orderedParent) Table() string { return "ordered_parent" }
+
+func TestRelationOrder(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	// covering index returns children by name unless they are ordered
+	_, err = db.Exec(`
+		create table ordered_parent (id integer primary key);
+		create table ordered_child (id integer primary key, parent_id int, name text);
+		create index ordered_child_parent on ordered_child (parent_id, name);
+
+		insert into ordered_parent (id) values (1), (2);
+		insert into ordered_child (id, parent_id, name) values
+			(1, 1, 'd'), (2, 1, 'c'), (3, 2, 'z'), (4, 1, 'b'), (5, 1, 'a'), (6, 2, 'y');
+	`)
+	require.NoError(t, err)
+
+	ids := func(children []*orderedChild) (ids []int64) {
+		for _, c := range children {
+			ids = append(ids, c.ID)
+		}
+		return ids
+	}
+
+	for i := 0; i < 3; i++ {
+		var p orderedParent
+		require.NoError(t, QueryStruct(db, &Options{Where: Where{"id": 1}, RelationDepth: 1}, &p))
+		assert.Equal(t, []int64{1, 2, 4, 5}, ids(p.Children))
+	}
+
+	var pp []*orderedParent
+	require.NoError(t, QuerySlice(db, DefaultOptions(), &pp))
+	require.Len(t, pp, 2)
+	assert.Equal(t, []int64{1, 2, 4, 5}, ids(pp[0].Children))
+	assert.Equal(t, []int64{3, 6}, ids(pp[1].Children))
+
+	opts := &Options{RelationDepth: 1, RelationOrder: map[string][]OrderBy{
+		"children": {{Field: "name", Order: "desc"}, {Field: "id"}},
+	}}
+	pp = nil
+	require.NoError(t, QuerySlice(db, opts, &pp))
+	require.Len(t, pp, 2)
+	assert.Equal(t, []int64{1, 2, 4, 5}, ids(pp[0].Children))
+	assert.Equal(t, []int64{3, 6}, ids(pp[1].Children))
+
+	opts.RelationOrder["children"][0].Order = "asc"
+	pp = nil
+	require.NoError(t, QuerySlice(db, opts, &pp))
+	require.Len(t, pp, 2)
+	assert.Equal(t, []int64{5, 4, 2, 1}, ids(pp[0].Children))
+	assert.Equal(t, []int64{6, 3}, ids(pp[1].Children))
+
+	opts.RelationOrder["children"][0].Field = "name; drop table ordered_child"
+	assert.Error(t, QuerySlice(db, opts, &pp))
+}
+
 type relatingModelWithCustomPK struct {
 	ID    int64 `ormlite:"primary,ref=c_rel_id"`
 	Field string
@@ -685,10 +1797,106 @@ func (s *manyToManyRelationFixture) TestCompoundKeys() {
 	assert.NoError(s.T(), err)
 }
 
+func (s *manyToManyRelationFixture) TestCompoundKeysPaired() {
+	_, err := s.db.Exec(`
+		insert into mtm_model_with_id(id, name) values (10, 'paired');
+		insert into mtm_with_compound_pk(model_id, first_id_ref, second_id_ref) values (10, 1, 2), (10, 2, 1);
+	`)
+	require.NoError(s.T(), err)
+
+	// parts of keys are bound in pairs, so (1,1) isn't matched by crossing them
+	rec := &queryRecorder{Executor: s.db}
+	var m modelManyToManyWithCompoundPK
+	require.NoError(s.T(), QueryStruct(rec, &Options{Where: Where{"id": 10}, RelationDepth: 1}, &m))
+	assert.Equal(s.T(), []*modelWithCompoundPrimaryKey{{1, 2, "2"}, {2, 1, "3"}}, m.Related)
+	if assert.Len(s.T(), rec.queries, 3) {
+		assert.Contains(s.T(), rec.queries[2], "(first_id,second_id) in (values (?,?),(?,?))")
+	}
+}
+
 func TestManyToManyRelation(t *testing.T) {
 	suite.Run(t, new(manyToManyRelationFixture))
 }
 
+type shelfBook struct {
+	ID    int64 `ormlite:"primary,ref=book_id"`
+	Title string
+}
+
+func (*shelfBook) Table() string { return "shelf_book" }
+
+type shelf struct {
+	Library string       `ormlite:"primary,ref=library_code"`
+	ID      int64        `ormlite:"col=rowid,primary,ref=shelf_ref"`
+	Books   []*shelfBook `ormlite:"many_to_many,table=shelf_books"`
+}
+
+func (*shelf) Table() string { return "shelf" }
+
+// listedShelf lists mapping columns of its compound key in field setting
+type listedShelf struct {
+	Library string       `ormlite:"primary"`
+	ID      int64        `ormlite:"col=rowid,primary"`
+	Books   []*shelfBook `ormlite:"many_to_many,table=shelf_books,field=library_code,shelf_ref"`
+}
+
+func (*listedShelf) Table() string { return "shelf" }
+
+type unresolvedShelf struct {
+	ID    int64        `ormlite:"col=rowid,primary"`
+	Books []*shelfBook `ormlite:"many_to_many,table=shelf_books"`
+}
+
+func (*unresolvedShelf) Table() string { return "shelf" }
+
+func TestManyToManyRowidParent(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`
+		create table shelf (library text);
+		create table shelf_book (id integer primary key, title text);
+		create table shelf_books (library_code text, shelf_ref int, book_id int);
+
+		insert into shelf (rowid, library) values (1, 'north'), (2, 'south');
+		insert into shelf_book (id, title) values (1, 'first'), (2, 'second'), (3, 'third');
+		insert into shelf_books (library_code, shelf_ref, book_id) values
+			('north', 1, 1), ('north', 1, 2), ('north', 2, 3), ('south', 2, 2), ('south', 2, 3);
+	`)
+	require.NoError(t, err)
+
+	titles := func(books []*shelfBook) (titles []string) {
+		for _, b := range books {
+			titles = append(titles, b.Title)
+		}
+		return titles
+	}
+
+	var s shelf
+	require.NoError(t, QueryStruct(db, &Options{Where: Where{"rowid": 2}, RelationDepth: 1}, &s))
+	assert.Equal(t, "south", s.Library)
+	assert.Equal(t, []string{"second", "third"}, titles(s.Books))
+
+	var ss []*shelf
+	require.NoError(t, QuerySlice(db, &Options{RelationDepth: 1, OrderBy: &OrderBy{Field: "rowid"}}, &ss))
+	require.Len(t, ss, 2)
+	assert.Equal(t, []string{"first", "second"}, titles(ss[0].Books))
+	assert.Equal(t, []string{"second", "third"}, titles(ss[1].Books))
+
+	count, err := CountRelated(db, &shelf{Library: "north", ID: 1}, "books", nil)
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, count)
+
+	var ls listedShelf
+	require.NoError(t, QueryStruct(db, &Options{Where: Where{"rowid": 2}, RelationDepth: 1}, &ls))
+	assert.Equal(t, []string{"second", "third"}, titles(ls.Books))
+
+	// mapping column can't be the key column itself
+	assert.Error(t, QueryStruct(db, &Options{Where: Where{"rowid": 1}, RelationDepth: 1}, &unresolvedShelf{}))
+}
+
 type modelMultiTable struct {
 	One []*relatedModel `ormlite:"many_to_many,table=one"`
 	Two []*relatedModel `ormlite:"many_to_many,table=two"`
@@ -758,6 +1966,74 @@ func TestMultiTableModel(t *testing.T) {
 	suite.Run(t, new(modelMultiTableFixture))
 }
 
+type customerTotal struct {
+	Customer string  `ormlite:"primary"`
+	Total    float64 `ormlite:"col=total"`
+}
+
+func (*customerTotal) Table() string { return "customer_totals" }
+
+func TestViewModel(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(`
+		create table orders(id integer primary key, customer text, amount real);
+		insert into orders(customer, amount) values ('alice', 10), ('alice', 5), ('bob', 7);
+		create view customer_totals as select customer, sum(amount) as total from orders group by customer;
+	`)
+	require.NoError(t, err)
+
+	var totals []*customerTotal
+	require.NoError(t, QuerySlice(db, &Options{OrderBy: &OrderBy{Field: "customer", Order: "asc"}}, &totals))
+	if assert.Len(t, totals, 2) {
+		assert.Equal(t, customerTotal{"alice", 15}, *totals[0])
+		assert.Equal(t, customerTotal{"bob", 7}, *totals[1])
+	}
+
+	var bob customerTotal
+	require.NoError(t, QueryStruct(db, &Options{Where: Where{"customer": StrictString("bob")}}, &bob))
+	assert.EqualValues(t, 7, bob.Total)
+
+	count, err := Count(db, &customerTotal{}, &Options{Where: Where{"total": Greater(10)}})
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, count)
+
+	var multi []*modelMultiTable
+	assert.Equal(t, ErrNoTable, QuerySlice(db, nil, &multi))
+	_, err = Count(db, &modelMultiTable{}, nil)
+	assert.Equal(t, ErrNoTable, err)
+}
+
+func TestQueryFromSubquery(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(`
+		create table orders(id integer primary key, customer text, amount real);
+		insert into orders(customer, amount) values ('alice', 10), ('alice', 5), ('bob', 7), ('carol', 1);
+	`)
+	require.NoError(t, err)
+
+	opts := &Options{
+		From:    "select customer, sum(amount) as total from orders group by customer",
+		Where:   Where{"total": GreaterOrEqual(5)},
+		OrderBy: &OrderBy{Field: "total", Order: "desc"},
+	}
+	var totals []*customerTotal
+	var count int
+	require.NoError(t, QuerySliceCount(db, opts, &totals, &count))
+	assert.Equal(t, 2, count)
+	if assert.Len(t, totals, 2) {
+		assert.Equal(t, customerTotal{"alice", 15}, *totals[0])
+		assert.Equal(t, customerTotal{"bob", 7}, *totals[1])
+	}
+
+	var carol customerTotal
+	require.NoError(t, QueryStruct(db, &Options{From: opts.From, Where: Where{"customer": StrictString("carol")}}, &carol))
+	assert.EqualValues(t, 1, carol.Total)
+}
+
 type modelWithoutPK struct {
 	ID int64 `ormlite:"col=rowid"`
 }
@@ -768,17 +2044,183 @@ type modelWithZeroPK struct {
 	ID int64 `ormlite:"primary,col=rowid"`
 }
 
-func (*modelWithZeroPK) Table() string { return "" }
+func (*modelWithZeroPK) Table() string { return "" }
+
+func TestWrongModels(t *testing.T) {
+	t.Run("TestDeleteModelWithoutPK", func(t *testing.T) {
+		_, err := Delete(nil, &modelWithoutPK{1})
+		assert.Error(t, err)
+	})
+	t.Run("TestDeleteModelWithZeroPK", func(t *testing.T) {
+		_, err := Delete(nil, &modelWithZeroPK{})
+		assert.Error(t, err)
+	})
+}
+
+func TestDeleteCompoundKeyWithHasOne(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(`
+		create table related_model(id integer primary key, field text);
+		insert into related_model(field) values ('1'), ('2');
+		create table complex_model(first_id integer, second_id integer, name text, primary key(first_id, second_id));
+		insert into complex_model(first_id, second_id, name) values (1, 1, 'a'), (1, 2, 'b'), (2, 2, 'c');
+	`)
+	require.NoError(t, err)
+
+	res, err := Delete(db, &modelWithCompoundWithForeign{FirstID: 1, Related: &relatedModelFK{ID: 2}})
+	require.NoError(t, err)
+	affected, err := res.RowsAffected()
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, affected)
+
+	var names []string
+	rows, err := db.Query("select name from complex_model order by name")
+	require.NoError(t, err)
+	for rows.Next() {
+		var name string
+		require.NoError(t, rows.Scan(&name))
+		names = append(names, name)
+	}
+	require.NoError(t, rows.Err())
+	assert.Equal(t, []string{"a", "c"}, names)
+
+	_, err = Delete(db, &modelWithCompoundWithForeign{FirstID: 1})
+	assert.Error(t, err, "missing related model")
+	_, err = Delete(db, &modelWithCompoundWithForeign{FirstID: 1, Related: &relatedModelFK{}})
+	assert.Error(t, err, "related model without key")
+	_, err = Delete(db, &modelWithCompoundWithForeign{Related: &relatedModelFK{ID: 1}})
+	assert.Error(t, err, "zero key part")
+}
+
+type cascadeChild struct {
+	ID     int64          `ormlite:"primary"`
+	Parent *cascadeParent `ormlite:"has_one,col=parent_id"`
+}
+
+func (*cascadeChild) Table() string { return "cascade_child" }
+
+type cascadeParent struct {
+	ID       int64 `ormlite:"col=rowid,primary,ref=m_id"`
+	Name     string
+	Related  []*relatedModel `ormlite:"many_to_many,table=mtm,field=m_id"`
+	Children []*cascadeChild `ormlite:"has_many,cascade"`
+}
+
+func (*cascadeParent) Table() string { return "mtm_model" }
+
+func TestDeleteDeep(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(`
+		create table related_model (field text);
+		create table mtm_model (name text);
+		create table mtm (m_id int, rel_id int);
+		create table cascade_child (id integer primary key, parent_id int);
+
+		insert into related_model (field) values ('test 1'), ('test 2');
+		insert into mtm_model (name) values ('first'), ('second');
+		insert into mtm (m_id, rel_id) values (1, 1), (1, 2), (2, 1);
+		insert into cascade_child (parent_id) values (1), (1), (2);
+	`)
+	require.NoError(t, err)
+
+	count := func(query string) (n int) {
+		require.NoError(t, db.QueryRow(query).Scan(&n))
+		return n
+	}
+
+	// children are kept unless relation is tagged with cascade
+	res, err := DeleteDeep(db, &modelManyToMany{ID: 1})
+	require.NoError(t, err)
+	affected, err := res.RowsAffected()
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, affected)
+	assert.Zero(t, count("select count(*) from mtm where m_id = 1"))
+	assert.Equal(t, 1, count("select count(*) from mtm where m_id = 2"))
+	assert.Equal(t, 2, count("select count(*) from related_model"))
+	assert.Equal(t, 1, count("select count(*) from mtm_model"))
+	assert.Equal(t, 3, count("select count(*) from cascade_child"))
+
+	_, err = DeleteDeep(db, &cascadeParent{ID: 2})
+	require.NoError(t, err)
+	assert.Zero(t, count("select count(*) from mtm"))
+	assert.Zero(t, count("select count(*) from mtm_model"))
+	assert.Equal(t, 2, count("select count(*) from cascade_child"))
+	assert.Zero(t, count("select count(*) from cascade_child where parent_id = 2"))
+
+	_, err = DeleteDeep(db, &cascadeParent{})
+	assert.Error(t, err)
+}
+
+type softCascadeParent struct {
+	ID        int64 `ormlite:"col=rowid,primary,ref=m_id"`
+	Name      string
+	DeletedAt sql.NullTime        `ormlite:"softdelete"`
+	Related   []*relatedModel     `ormlite:"many_to_many,table=mtm,field=m_id"`
+	Children  []*softCascadeChild `ormlite:"has_many,cascade"`
+}
+
+func (*softCascadeParent) Table() string { return "mtm_model" }
+
+type softCascadeChild struct {
+	ID     int64              `ormlite:"primary"`
+	Parent *softCascadeParent `ormlite:"has_one,col=parent_id"`
+}
+
+func (*softCascadeChild) Table() string { return "cascade_child" }
+
+// relatedOwner is keyed by related model
+type relatedOwner struct {
+	Owner   *relatedModelFK `ormlite:"primary,col=owner_id,has_one"`
+	Related []*relatedModel `ormlite:"many_to_many,table=owner_mtm,field=owner_id"`
+}
+
+func (*relatedOwner) Table() string { return "owners" }
+
+func TestDeleteDeepSoftDeleted(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(`
+		create table related_model (field text);
+		create table mtm_model (name text, deleted_at datetime);
+		create table mtm (m_id int, rel_id int);
+		create table cascade_child (id integer primary key, parent_id int);
+		create table owners (owner_id int);
+		create table owner_mtm (owner_id int, rel_id int);
+
+		insert into related_model (field) values ('test 1'), ('test 2');
+		insert into mtm_model (name) values ('first');
+		insert into mtm (m_id, rel_id) values (1, 1), (1, 2);
+		insert into cascade_child (parent_id) values (1), (1);
+		insert into owners (owner_id) values (1), (2);
+		insert into owner_mtm (owner_id, rel_id) values (1, 1), (2, 1);
+	`)
+	require.NoError(t, err)
+
+	_, err = DeleteDeep(db, &softCascadeParent{ID: 1})
+	require.NoError(t, err)
 
-func TestWrongModels(t *testing.T) {
-	t.Run("TestDeleteModelWithoutPK", func(t *testing.T) {
-		_, err := Delete(nil, &modelWithoutPK{1})
-		assert.Error(t, err)
-	})
-	t.Run("TestDeleteModelWithZeroPK", func(t *testing.T) {
-		_, err := Delete(nil, &modelWithZeroPK{})
-		assert.Error(t, err)
-	})
+	var n int
+	require.NoError(t, db.QueryRow("select count(*) from mtm_model where deleted_at is not null").Scan(&n))
+	assert.Equal(t, 1, n)
+	require.NoError(t, db.QueryRow("select count(*) from mtm where m_id = 1").Scan(&n))
+	assert.Equal(t, 2, n, "mapping rows of soft deleted model are kept")
+	require.NoError(t, db.QueryRow("select count(*) from cascade_child").Scan(&n))
+	assert.Equal(t, 2, n, "children without soft delete column are kept")
+
+	// mapping rows are deleted by key of related model being primary key
+	_, err = DeleteDeep(db, &relatedOwner{Owner: &relatedModelFK{ID: 1}})
+	require.NoError(t, err)
+	require.NoError(t, db.QueryRow("select count(*) from owner_mtm").Scan(&n))
+	assert.Equal(t, 1, n)
+	require.NoError(t, db.QueryRow("select count(*) from owners").Scan(&n))
+	assert.Equal(t, 1, n)
 }
 
 type relatedModelFK struct {
@@ -1004,6 +2446,28 @@ func (s *testSearchByRelatedSuite) TestSearchByHasMany() {
 	if assert.NoError(s.T(), QuerySlice(s.db, &Options{RelatedTo: []IModel{&testSearchHasManyModel{ID: 2}, &testSearchHasManyModel{ID: 1}}}, &mm)) {
 		assert.Len(s.T(), mm, 2)
 	}
+
+	// conditions of related models aren't added to shared options
+	opts := &Options{RelatedTo: []IModel{&testSearchHasManyModel{ID: 2}}, Where: Where{"name": "Test 1"}, Divider: AND}
+	for i := 0; i < 2; i++ {
+		mm = nil
+		if assert.NoError(s.T(), QuerySlice(s.db, opts, &mm)) {
+			assert.Len(s.T(), mm, 1)
+		}
+		assert.Equal(s.T(), Where{"name": "Test 1"}, opts.Where)
+	}
+	opts = &Options{RelatedTo: []IModel{&testSearchHasManyModel{ID: 2}}}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var mm []*testSearchBaseModel
+			assert.NoError(s.T(), QuerySlice(s.db, opts, &mm))
+		}()
+	}
+	wg.Wait()
+	assert.Nil(s.T(), opts.Where)
 }
 
 func (s *testSearchByRelatedSuite) TestSearchByManyToMany() {
@@ -1015,21 +2479,127 @@ func (s *testSearchByRelatedSuite) TestSearchByManyToMany() {
 	if assert.NoError(s.T(), QuerySlice(s.db, &Options{RelatedTo: []IModel{&testSearchMTMModel{ID: 2}}}, &mm)) {
 		assert.Len(s.T(), mm, 1)
 	}
+	// model with zero key matches models having any related one
 	mm = nil
-	if assert.NoError(s.T(), QuerySlice(s.db, &Options{RelatedTo: []IModel{&testSearchMTMModel{}}}, &mm)) {
-		assert.Len(s.T(), mm, 1)
+	if assert.NoError(s.T(), QuerySlice(s.db, &Options{RelatedTo: []IModel{&testSearchMTMModel{}}, OrderBy: &OrderBy{Field: "id"}}, &mm)) {
+		if assert.Len(s.T(), mm, 2) {
+			assert.Equal(s.T(), "Test 1", mm[0].Name)
+			assert.Equal(s.T(), "Test 2", mm[1].Name)
+		}
+	}
+	mm = nil
+	if assert.NoError(s.T(), QuerySlice(s.db, &Options{RelatedTo: []IModel{&testSearchMTMModel{}, &testSearchMTMModel{ID: 2}}}, &mm)) {
+		assert.Len(s.T(), mm, 2)
+	}
+	count, err := Count(s.db, &testSearchBaseModel{}, &Options{RelatedTo: []IModel{&testSearchMTMModel{}}})
+	if assert.NoError(s.T(), err) {
+		assert.EqualValues(s.T(), 2, count, "models with several relations aren't duplicated")
+	}
+	mm = nil
+	if assert.NoError(s.T(), QuerySlice(s.db, &Options{RelatedTo: []IModel{&testSearchMTMModel{}, &testSearchHasManyModel{ID: 2}}}, &mm)) {
+		assert.Len(s.T(), mm, 2)
 	}
 
-	count, err := Count(s.db, &testSearchBaseModel{}, &Options{RelatedTo: []IModel{&testSearchMTMModel{ID: 2}}})
+	count, err = Count(s.db, &testSearchBaseModel{}, &Options{RelatedTo: []IModel{&testSearchMTMModel{ID: 2}}})
 	if assert.NoError(s.T(), err) {
 		assert.EqualValues(s.T(), 1, count)
 	}
 }
 
+func (s *testSearchByRelatedSuite) TestQuerySliceCountByRelated() {
+	var (
+		mm    []*testSearchBaseModel
+		count int
+	)
+	require.NoError(s.T(), QuerySliceCount(s.db, &Options{RelatedTo: []IModel{&testSearchMTMModel{ID: 1}}}, &mm, &count))
+	assert.Len(s.T(), mm, 2)
+	assert.Equal(s.T(), 2, count)
+
+	mm = nil
+	require.NoError(s.T(), QuerySliceCount(s.db, &Options{RelatedTo: []IModel{&testSearchMTMModel{ID: 1}}, Limit: 1}, &mm, &count))
+	assert.Len(s.T(), mm, 1)
+	assert.Equal(s.T(), 2, count)
+
+	mm = nil
+	require.NoError(s.T(), QuerySliceCount(s.db, &Options{RelatedTo: []IModel{&testSearchHasManyModel{ID: 2}}}, &mm, &count))
+	assert.Len(s.T(), mm, 2)
+	assert.Equal(s.T(), 2, count)
+	for _, m := range mm {
+		assert.NotZero(s.T(), m.ID)
+		assert.NotEmpty(s.T(), m.Name)
+	}
+}
+
+func (s *testSearchByRelatedSuite) TestWithRelated() {
+	var mm []*testSearchBaseModel
+	require.NoError(s.T(), QuerySlice(s.db, WithRelated(DefaultOptions(), &testSearchMTMModel{ID: 1}), &mm))
+	assert.Len(s.T(), mm, 2)
+}
+
 func TestSearchByRelated(t *testing.T) {
 	suite.Run(t, new(testSearchByRelatedSuite))
 }
 
+func TestRelatedExists(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`
+		create table base_model(id integer primary key, name text, has_one integer);
+		create table has_many_model(id integer primary key, bm1 integer, bm2 integer);
+		create table mtm_model(id integer primary key, name text);
+		create table relation_table(base_id integer, mtm_id integer);
+
+		insert into base_model(name) values ('first'), ('second'), ('third');
+		insert into mtm_model(name) values ('one'), ('two');
+		insert into has_many_model(bm1, bm2) values (1, 1), (1, 2);
+		-- first model is mapped to the same mtm model twice
+		insert into relation_table(base_id, mtm_id) values (1, 1), (1, 1), (1, 2), (2, 1);
+	`)
+	require.NoError(t, err)
+
+	related := []IModel{&testSearchMTMModel{ID: 1}}
+	joined, err := Count(db, &testSearchBaseModel{}, &Options{RelatedTo: related})
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, joined, "join duplicates rows of repeated mapping")
+	distinct, err := CountDistinct(db, &testSearchBaseModel{}, "id", &Options{RelatedTo: related})
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, distinct)
+
+	exists, err := Count(db, &testSearchBaseModel{}, &Options{RelatedTo: related, RelatedExists: true})
+	require.NoError(t, err)
+	assert.Equal(t, distinct, exists)
+
+	names := func(opts *Options) (names []string) {
+		var mm []*testSearchBaseModel
+		opts.RelatedExists = true
+		opts.OrderBy = &OrderBy{Field: "id"}
+		require.NoError(t, QuerySlice(db, opts, &mm))
+		for _, m := range mm {
+			names = append(names, m.Name)
+		}
+		return names
+	}
+	assert.Equal(t, []string{"first", "second"}, names(&Options{RelatedTo: related}))
+	// any of listed models of the same type
+	assert.Equal(t, []string{"first", "second"}, names(&Options{RelatedTo: []IModel{&testSearchMTMModel{ID: 2}, &testSearchMTMModel{ID: 1}}}))
+	// different types are all required
+	assert.Equal(t, []string{"second"}, names(&Options{
+		RelatedTo: []IModel{&testSearchMTMModel{ID: 1}, &testSearchHasManyModel{ID: 2}},
+		Where:     Where{"name": StrictString("second")},
+	}))
+	assert.Equal(t, []string{"first", "second"}, names(&Options{RelatedTo: []IModel{&testSearchHasManyModel{ID: 2}}}))
+	// model with zero key matches models having any related one
+	assert.Equal(t, []string{"first", "second"}, names(&Options{RelatedTo: []IModel{&testSearchMTMModel{}}}))
+	assert.Equal(t, []string{"second"}, names(&Options{RelatedTo: []IModel{&testSearchMTMModel{}, &testSearchMTMModel{ID: 2}}, Where: Where{"id": GreaterOrEqual(2)}}))
+	assert.Equal(t, []string{"first", "second"}, names(&Options{RelatedTo: []IModel{&testSearchHasManyModel{}}}))
+
+	var mm []*testSearchBaseModel
+	assert.Error(t, QuerySlice(db, &Options{RelatedTo: []IModel{&simpleModel{ID: 1}}, RelatedExists: true}, &mm))
+}
+
 type MTMModel struct {
 	ID       int64 `ormlite:"primary,ref=model_id"`
 	Name     string
@@ -1077,6 +2647,91 @@ func (s *testCustomFieldInMTMModel) TestParenthesis() {
 	}
 }
 
+type mtmTreeModel struct {
+	ID       int64 `ormlite:"primary,ref=model_id"`
+	Name     string
+	Children []*mtmTreeModel `ormlite:"many_to_many,table=rel_table,field=parent_id"`
+	Parents  []*mtmTreeModel `ormlite:"many_to_many,table=rel_table,field=model_id,ref=parent_id"`
+}
+
+func (*mtmTreeModel) Table() string { return "mtm_model" }
+
+func (s *testCustomFieldInMTMModel) TestInverseRelation() {
+	ids := func(mm []*mtmTreeModel) []int64 {
+		var ids []int64
+		for _, m := range mm {
+			ids = append(ids, m.ID)
+		}
+		return ids
+	}
+
+	var m mtmTreeModel
+	require.NoError(s.T(), QueryStruct(s.db, &Options{RelationDepth: 1, Where: Where{"id": 1}}, &m))
+	assert.Equal(s.T(), []int64{2, 3}, ids(m.Children))
+	assert.Equal(s.T(), []int64{4}, ids(m.Parents))
+
+	m = mtmTreeModel{}
+	require.NoError(s.T(), QueryStruct(s.db, &Options{RelationDepth: 1, Where: Where{"id": 2}}, &m))
+	assert.Empty(s.T(), m.Children)
+	assert.Equal(s.T(), []int64{1}, ids(m.Parents))
+
+	count, err := CountRelated(s.db, &mtmTreeModel{ID: 1}, "parents", nil)
+	require.NoError(s.T(), err)
+	assert.EqualValues(s.T(), 1, count)
+
+	// the last relation of the type is used to search related models
+	var mm []*mtmTreeModel
+	require.NoError(s.T(), QuerySlice(s.db, &Options{RelatedTo: []IModel{&mtmTreeModel{ID: 1}}, RelatedExists: true}, &mm))
+	assert.Equal(s.T(), []int64{2, 3}, ids(mm))
+
+	require.NoError(s.T(), AddRelation(s.db, &mtmTreeModel{ID: 3}, "parents", &mtmTreeModel{ID: 4}))
+	m = mtmTreeModel{}
+	require.NoError(s.T(), QueryStruct(s.db, &Options{RelationDepth: 1, Where: Where{"id": 4}}, &m))
+	assert.Equal(s.T(), []int64{1, 3}, ids(m.Children))
+	require.NoError(s.T(), RemoveRelation(s.db, &mtmTreeModel{ID: 3}, "parents", &mtmTreeModel{ID: 4}))
+}
+
+func (s *testCustomFieldInMTMModel) TestDefaultRelationDepth() {
+	SetDefaultRelationDepth(1)
+	defer SetDefaultRelationDepth(0)
+
+	var mm []*mtmTreeModel
+	require.NoError(s.T(), QuerySlice(s.db, nil, &mm))
+	if assert.Len(s.T(), mm, 4) {
+		assert.Len(s.T(), mm[0].Children, 2)
+		assert.Len(s.T(), mm[0].Parents, 1)
+		// relations of related models are out of default depth
+		assert.Empty(s.T(), mm[0].Parents[0].Children)
+	}
+
+	var m mtmTreeModel
+	require.NoError(s.T(), QueryStruct(s.db, nil, &m))
+	assert.Len(s.T(), m.Children, 2)
+
+	m = mtmTreeModel{}
+	require.NoError(s.T(), QueryStruct(s.db, &Options{Where: Where{"id": 4}}, &m))
+	assert.Len(s.T(), m.Children, 1)
+
+	var children []int
+	require.NoError(s.T(), QueryFunc(context.Background(), s.db, nil, &mtmTreeModel{}, func(m Model) error {
+		children = append(children, len(m.(*mtmTreeModel).Children))
+		return nil
+	}))
+	if assert.Len(s.T(), children, 4) {
+		assert.Equal(s.T(), 2, children[0])
+	}
+
+	m = mtmTreeModel{}
+	require.NoError(s.T(), QueryStruct(s.db, WithoutRelations(&Options{Where: Where{"id": 4}}), &m))
+	assert.Empty(s.T(), m.Children)
+
+	m = mtmTreeModel{}
+	require.NoError(s.T(), QueryStruct(s.db, &Options{RelationDepth: 2, Where: Where{"id": 4}}, &m))
+	if assert.Len(s.T(), m.Children, 1) {
+		assert.Len(s.T(), m.Children[0].Children, 2)
+	}
+}
+
 func TestCustomFieldInMTM(t *testing.T) {
 	suite.Run(t, new(testCustomFieldInMTMModel))
 }
@@ -1167,6 +2822,57 @@ func TestBitwiseQuerying(t *testing.T) {
 	}
 }
 
+func TestQueryStructOnMultiple(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(`
+		create table test(id integer primary key, attr int);
+		insert into test(attr) values (1), (2), (2), (2);
+	`)
+	require.NoError(t, err)
+
+	query := func(opts *Options) (testQuerySliceCountModel, error) {
+		var m testQuerySliceCountModel
+		rec := &queryRecorder{Executor: db}
+		err := QueryStruct(rec, opts, &m)
+		if assert.Len(t, rec.queries, 1) && opts != nil && opts.OnMultiple == LastRow {
+			assert.NotContains(t, rec.queries[0], "limit")
+		}
+		return m, err
+	}
+	order := &OrderBy{Field: "id"}
+
+	m, err := query(&Options{Where: Where{"attr": 2}, OrderBy: order})
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, m.ID, "first row is taken by default")
+
+	m, err = query(&Options{Where: Where{"attr": 2}, OrderBy: order, Offset: 1, OnMultiple: FirstRow})
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, m.ID)
+
+	m, err = query(&Options{Where: Where{"attr": 2}, OrderBy: order, OnMultiple: LastRow})
+	require.NoError(t, err)
+	assert.EqualValues(t, 4, m.ID)
+
+	_, err = query(&Options{Where: Where{"attr": 2}, OnMultiple: ErrorOnMultiple})
+	assert.Equal(t, ErrMultipleRows, err)
+
+	m, err = query(&Options{Where: Where{"attr": 1}, OnMultiple: ErrorOnMultiple})
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, m.ID)
+
+	m, err = query(nil)
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, m.ID)
+
+	// limit of options is kept
+	opts := &Options{Where: Where{"attr": 2}, Limit: 5}
+	_, err = query(opts)
+	require.NoError(t, err)
+	assert.Equal(t, 5, opts.Limit)
+}
+
 type testStrictStringQueryingModel struct {
 	ID   int64 `ormlite:"primary"`
 	Name string
@@ -1186,7 +2892,7 @@ func TestStrictStringQuerying(t *testing.T) {
 	require.NoError(t, err)
 
 	var m testStrictStringQueryingModel
-	if assert.NoError(t, QueryStruct(db, &Options{Where: Where{"name": "support"}}, &m)) {
+	if assert.NoError(t, QueryStruct(db, &Options{Where: Where{"name": "support"}, OnMultiple: LastRow}, &m)) {
 		assert.EqualValues(t, "subsupport", m.Name)
 	}
 
@@ -1241,6 +2947,79 @@ func TestQuerySliceCount(t *testing.T) {
 	}
 }
 
+func TestCountDistinct(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(`
+		create table test(id integer primary key, attr int);
+		insert into test(attr) values (1), (1), (2), (2), (3), (null);
+	`)
+	require.NoError(t, err)
+
+	count, err := CountDistinct(db, &testQuerySliceCountModel{}, "attr", nil)
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, count)
+
+	count, err = CountDistinct(db, &testQuerySliceCountModel{}, "attr", &Options{Where: Where{"id": LessOrEqual(3)}})
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, count)
+
+	count, err = CountDistinct(db, &testQuerySliceCountModel{}, "attr", &Options{
+		Where: Where{"id": Greater(2)}, Columns: map[string]struct{}{"id": {}}})
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, count)
+
+	_, err = CountDistinct(db, &testQuerySliceCountModel{}, "unknown", nil)
+	assert.Error(t, err)
+}
+
+func TestCountByGroup(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(`
+		create table test(id integer primary key, attr int);
+		insert into test(attr) values (1), (1), (2), (2), (2), (3), (null);
+	`)
+	require.NoError(t, err)
+
+	counts, err := CountByGroup(db, &testQuerySliceCountModel{}, "attr", nil)
+	require.NoError(t, err)
+	assert.Equal(t, map[interface{}]int64{int64(1): 2, int64(2): 3, int64(3): 1, nil: 1}, counts)
+
+	counts, err = CountByGroup(db, &testQuerySliceCountModel{}, "attr", &Options{
+		Where: Where{"id": Greater(2)}, Columns: map[string]struct{}{"id": {}}, Limit: 1})
+	require.NoError(t, err)
+	assert.Equal(t, map[interface{}]int64{int64(2): 3, int64(3): 1, nil: 1}, counts)
+
+	counts, err = CountByGroup(db, &simpleModel{}, "not_tagged_field", nil)
+	assert.Error(t, err, "table of simple model doesn't exist")
+	assert.Nil(t, counts)
+
+	_, err = CountByGroup(db, &testQuerySliceCountModel{}, "attr; drop table test", nil)
+	assert.Error(t, err)
+}
+
+func TestCountContextCancel(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(`create table test(id integer primary key, attr int)`)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// endless sequence keeps counting until the query is interrupted
+	opts := &Options{From: "with recursive seq(id) as (select 1 union all select id + 1 from seq) select id, 0 as attr from seq"}
+	start := time.Now()
+	_, err = CountContext(ctx, db, &testQuerySliceCountModel{}, opts)
+	require.Error(t, err)
+	assert.True(t, time.Since(start) < 5*time.Second, "count should return promptly")
+	assert.True(t, IsTimeout(err), "count should fail with timeout error, got %v", err)
+}
+
 type SelectedColumnsSuite struct {
 	suite.Suite
 	db *sql.DB
@@ -1316,6 +3095,291 @@ func (s *SelectedColumnsSuite) TestQuerySlice() {
 
 }
 
+func (s *SelectedColumnsSuite) TestOptionHelpers() {
+	var mm []*BigModel
+	opts := WithDivider(WithWhere(WithColumns(DefaultOptions(), "attr1", "attr3"), Where{"attr1": 1, "attr2": 4}), OR)
+	require.NoError(s.T(), QuerySlice(s.db, opts, &mm))
+	if assert.Len(s.T(), mm, 2) {
+		assert.EqualValues(s.T(), "first", mm[0].Attr3)
+		assert.EqualValues(s.T(), 0, mm[1].Attr2)
+		assert.EqualValues(s.T(), 3, mm[1].Attr1)
+	}
+
+	var m BigModel
+	require.NoError(s.T(), QueryStruct(s.db, WithRelationDepth(WithWhere(DefaultOptions(), Where{"id": 6}), 0), &m))
+	assert.Nil(s.T(), m.Related)
+	require.NoError(s.T(), QueryStruct(s.db, WithRelationDepth(WithWhere(DefaultOptions(), Where{"id": 6}), 1), &m))
+	if assert.NotNil(s.T(), m.Related) {
+		assert.Equal(s.T(), "Hello", m.Related.Field)
+	}
+}
+
 func TestSelectedColumns(t *testing.T) {
 	suite.Run(t, new(SelectedColumnsSuite))
 }
+
+func TestErrorArgsRedaction(t *testing.T) {
+	err := &Error{SQLError: errors.New("failed"), Query: "select ?", Args: []interface{}{"secret"}}
+	assert.Equal(t, "failed", err.Error())
+	assert.Contains(t, fmt.Sprintf("%+v", err), "secret")
+
+	SetArgRedactor(func(args []interface{}) []interface{} {
+		for i := range args {
+			args[i] = "***"
+		}
+		return args
+	})
+	defer SetArgRedactor(nil)
+
+	formatted := fmt.Sprintf("%+v", err)
+	assert.NotContains(t, formatted, "secret")
+	assert.Contains(t, formatted, "***")
+	assert.Equal(t, "secret", err.Args[0], "stored arguments should stay untouched")
+
+	var many []interface{}
+	for i := 0; i < 100; i++ {
+		many = append(many, i)
+	}
+	formatted = fmt.Sprintf("%+v", &Error{SQLError: errors.New("failed"), Args: many})
+	assert.Contains(t, formatted, "(80 more)")
+}
+
+func TestGroupByHaving(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+
+	_, err = db.Exec(`
+		create table test(id integer primary key , attr int);
+		insert into test(attr) values (1), (1), (1), (1), (2), (2), (2), (2), (2), (2), (3);
+	`)
+	require.NoError(t, err)
+
+	opts := &Options{GroupBy: []string{"attr"}, Having: Where{"count(*)": Greater(3)}}
+
+	var mm []*testQuerySliceCountModel
+	if assert.NoError(t, QuerySlice(db, opts, &mm)) {
+		assert.Len(t, mm, 2)
+	}
+
+	mm = nil
+	opts.Having = Where{"count(*)": Greater(4)}
+	if assert.NoError(t, QuerySlice(db, opts, &mm)) {
+		if assert.Len(t, mm, 1) {
+			assert.Equal(t, 2, mm[0].Attr)
+		}
+	}
+
+	mm = nil
+	opts.Having = Where{"max(id)": Greater(Col("attr"))}
+	if assert.NoError(t, QuerySlice(db, opts, &mm)) {
+		assert.Len(t, mm, 3)
+	}
+
+	// columns referenced by having conditions are rendered as is
+	opts.Having = Where{"count(*)": Greater(Col("x) or 1=1 --"))}
+	assert.Error(t, QuerySlice(db, opts, &mm))
+	_, err = Count(db, &testQuerySliceCountModel{}, opts)
+	assert.Error(t, err)
+
+	count, err := Count(db, &testQuerySliceCountModel{}, &Options{GroupBy: []string{"attr"}})
+	if assert.NoError(t, err) {
+		assert.EqualValues(t, 3, count)
+	}
+}
+
+func TestQuerySliceCountOnConn(t *testing.T) {
+	f, err := os.CreateTemp("", "ormlite")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	db, err := sql.Open("sqlite3", f.Name())
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(`
+		create table test(id integer primary key , attr int);
+		insert into test(attr) values (1), (1), (2);
+	`)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	var m []*testQuerySliceCountModel
+	var count int
+	if assert.NoError(t, QuerySliceCountContext(ctx, conn, &Options{Where: Where{"attr": 1}}, &m, &count)) {
+		assert.Len(t, m, 2)
+		assert.Equal(t, 2, count)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	require.NoError(t, err)
+	require.NoError(t, Insert(tx, &testQuerySliceCountModel{Attr: 3}))
+	require.NoError(t, tx.Rollback())
+	c, err := Count(db, &testQuerySliceCountModel{}, nil)
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, c)
+}
+
+func TestQuerySliceCountConnectionPool(t *testing.T) {
+	f, err := os.CreateTemp("", "ormlite")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	db, err := sql.Open("sqlite3", f.Name())
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(4)
+	// don't keep idle connections, so every query may get a new one
+	db.SetMaxIdleConns(0)
+
+	_, err = db.Exec(`
+		create table test(id integer primary key , attr int);
+		insert into test(attr) values (1), (1), (1), (2), (2);
+	`)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 40)
+	for i := 0; i < 40; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var (
+				m     []*testQuerySliceCountModel
+				count int
+			)
+			if err := QuerySliceCount(db, &Options{Where: Where{"attr": 1}}, &m, &count); err != nil {
+				errs <- err
+				return
+			}
+			if count != 3 || len(m) != 3 {
+				errs <- fmt.Errorf("unexpected result: count %d, rows %d", count, len(m))
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+}
+
+// cancellingExecutor cancels context after the first query row is read
+type cancellingExecutor struct {
+	Executor
+	cancel context.CancelFunc
+}
+
+func (e *cancellingExecutor) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	defer e.cancel()
+	return e.Executor.QueryRowContext(ctx, query, args...)
+}
+
+func TestQuerySliceCountCancelled(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(`
+		create table simple_model(id integer primary key, not_tagged_field text, tagged_field text);
+		insert into simple_model(not_tagged_field) values ('a'), ('b');
+	`)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var (
+		mm    []*simpleModel
+		count int
+	)
+	err = QuerySliceCountContext(ctx, &cancellingExecutor{db, cancel}, &Options{Where: Where{"id": Greater(0)}}, &mm, &count)
+	if assert.Error(t, err) {
+		assert.True(t, IsTimeout(err), "%v", err)
+	}
+	assert.Equal(t, 2, count)
+	assert.Empty(t, mm)
+
+	var tables int
+	require.NoError(t, db.QueryRow("select count(*) from sqlite_temp_master").Scan(&tables))
+	assert.Zero(t, tables, "count must not leave temporary tables")
+}
+
+// queryRecorder is an Executor recording all queries passed through it
+type queryRecorder struct {
+	Executor
+	mu      sync.Mutex
+	queries []string
+}
+
+func (r *queryRecorder) record(query string) {
+	r.mu.Lock()
+	r.queries = append(r.queries, query)
+	r.mu.Unlock()
+}
+
+func (r *queryRecorder) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	r.record(query)
+	return r.Executor.ExecContext(ctx, query, args...)
+}
+
+func (r *queryRecorder) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	r.record(query)
+	return r.Executor.QueryContext(ctx, query, args...)
+}
+
+func (r *queryRecorder) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	r.record(query)
+	return r.Executor.QueryRowContext(ctx, query, args...)
+}
+
+func BenchmarkQuerySliceCount(b *testing.B) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(b, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`
+		create table simple_model(id integer primary key, not_tagged_field text, tagged_field text);
+		with recursive seq(n) as (select 1 union all select n + 1 from seq where n < 10000)
+		insert into simple_model(not_tagged_field, tagged_field) select 'field ' || n, 'tagged ' || n from seq;
+	`)
+	require.NoError(b, err)
+
+	// slice grown by append is compared with the one sized by counted rows
+	b.Run("QuerySlice", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var mm []*simpleModel
+			if err := QuerySlice(db, nil, &mm); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("QuerySliceCount", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var (
+				mm    []*simpleModel
+				count int
+			)
+			if err := QuerySliceCount(db, nil, &mm, &count); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestScanOrder(t *testing.T) {
+	// columns are matched by names case insensitively, the rest keep positions
+	assert.Equal(t, []int{1, 0, 2}, scanOrder([]string{"b", "A", "x"}, []string{"a", "b", "c"}))
+	// extra columns are discarded
+	assert.Equal(t, []int{0, -1, 1}, scanOrder([]string{"a", "x", "b"}, []string{"a", "b"}))
+
+	var a, b int
+	dests := orderScanDest([]int{1, -1, 0}, []interface{}{&a, &b})
+	assert.Equal(t, &b, dests[0])
+	assert.Equal(t, &a, dests[2])
+	assert.IsType(t, new(interface{}), dests[1])
+}
@@ -0,0 +1,106 @@
+package ormlite
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/pkg/errors"
+)
+
+// PreloadRelation loads relation stored in the field with given name (struct
+// field or column name, e.g. "Related" or "related") for every model of already
+// queried slice, e.g. loaded with RelationDepth 0. Has many relations of models
+// with single primary key are loaded with one query per maxBatchKeys models,
+// others with one query per model. Options configure loaded models: Where and
+// Columns restrict them, OrderBy and ThenBy order them, Limit caps number of
+// them per model (has many relations are loaded per model then), RelationDepth
+// applies to their own relations.
+func PreloadRelation(db Executor, slice interface{}, relation string, opts *Options) error {
+	return PreloadRelationContext(context.Background(), db, slice, relation, opts)
+}
+
+// PreloadRelationContext is the same as PreloadRelation but with given context
+func PreloadRelationContext(ctx context.Context, db Executor, slice interface{}, relation string, opts *Options) error {
+	sv := reflect.ValueOf(slice)
+	if sv.Kind() == reflect.Ptr {
+		sv = sv.Elem()
+	}
+	if sv.Kind() != reflect.Slice || sv.Type().Elem().Kind() != reflect.Ptr ||
+		sv.Type().Elem().Elem().Kind() != reflect.Struct {
+		return errors.Errorf("expected slice of pointers to struct, got %T", slice)
+	}
+	modelType := sv.Type().Elem().Elem()
+	colInfo, err := getColumnInfo(modelType)
+	if err != nil {
+		return err
+	}
+	var ci *columnInfo
+	for i := range colInfo {
+		if colInfo[i].Name == relation || modelType.Field(colInfo[i].Index).Name == relation {
+			ci = &colInfo[i]
+		}
+	}
+	if ci == nil || ci.RelationInfo.Type == noRelation {
+		return errors.Errorf("model %s does not have relation %s", modelType.Name(), relation)
+	}
+	if ci.RelationInfo.Type == hasOne {
+		// referenced key is not kept in the model unless relation was loaded
+		return errors.Errorf("can't preload has one relation %s", relation)
+	}
+
+	var loaded Options
+	if opts = withDefaultRelationDepth(opts); opts != nil {
+		loaded = *opts
+	}
+	if err := checkRelationDepth(&loaded); err != nil {
+		return err
+	}
+	// loaders treat options as the ones of parent query
+	parentOpts := &Options{RelationDepth: loaded.RelationDepth + 1, WithTrashed: loaded.WithTrashed, Limit: loaded.Limit}
+	if loaded.Columns != nil {
+		parentOpts.RelationColumns = map[string]map[string]struct{}{ci.Name: loaded.Columns}
+	}
+	if loaded.Where != nil {
+		parentOpts.RelationWhere = map[string]Where{ci.Name: loaded.Where}
+	}
+	if loaded.OrderBy != nil {
+		parentOpts.RelationOrder = map[string][]OrderBy{ci.Name: append([]OrderBy{*loaded.OrderBy}, loaded.ThenBy...)}
+	}
+
+	for i := 0; i < sv.Len(); i++ {
+		if sv.Index(i).IsNil() {
+			return errors.Errorf("can't preload relation %s: model %d is nil", relation, i)
+		}
+		// relation is replaced, not appended to
+		fv := sv.Index(i).Elem().Field(ci.Index)
+		fv.Set(reflect.Zero(fv.Type()))
+	}
+
+	// limit restricts number of related models per model, so they can't be
+	// loaded at once
+	if ci.RelationInfo.Type == hasMany && sv.Len() != 0 && loaded.Limit <= 0 {
+		ok, err := loadHasManyRelationBatch(ctx, db, sv, ci.Index, parentOpts)
+		if err != nil || ok {
+			return err
+		}
+	}
+	for i := 0; i < sv.Len(); i++ {
+		modelValue := sv.Index(i).Elem()
+		pkFields, err := getPrimaryFieldsInfo(modelValue)
+		if err != nil {
+			return err
+		}
+		switch ci.RelationInfo.Type {
+		case hasMany:
+			err = loadHasManyRelation(ctx, db, ci.RelationInfo, modelValue.Field(ci.Index), pkFields, sv.Index(i).Type(), parentOpts)
+		case manyToMany:
+			err = loadManyToManyRelation(ctx, db, &ci.RelationInfo, modelValue.Field(ci.Index), pkFields, parentOpts)
+		case concatRelation:
+			err = loadConcatRelation(ctx, db, &ci.RelationInfo, modelValue.Field(ci.Index), pkFields)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
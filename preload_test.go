@@ -0,0 +1,69 @@
+package ormlite
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreloadRelation(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`
+		create table has_many_model (name text);
+		create table relating_model (related_id int);
+
+		insert into has_many_model (name) values ('first'), ('second'), ('third');
+		insert into relating_model (related_id) values (1), (1), (1), (2), (2);
+	`)
+	require.NoError(t, err)
+
+	var mm []*hasManyModel
+	require.NoError(t, QuerySlice(db, WithoutRelations(DefaultOptions()), &mm))
+	require.Len(t, mm, 3)
+	for _, m := range mm {
+		require.Empty(t, m.Related)
+	}
+
+	rec := &queryRecorder{Executor: db}
+	require.NoError(t, PreloadRelation(rec, &mm, "Related", nil))
+	assert.Len(t, rec.queries, 1)
+	assert.Len(t, mm[0].Related, 3)
+	assert.Len(t, mm[1].Related, 2)
+	assert.Empty(t, mm[2].Related)
+	// loaded models refer their parents only by key
+	if assert.NotEmpty(t, mm[0].Related) {
+		assert.Nil(t, mm[0].Related[0].Related)
+	}
+
+	// relation is replaced on repeated preloading, options filter loaded models
+	require.NoError(t, PreloadRelation(db, mm, "related", &Options{Where: Where{"rowid": AnyOf(1, 4)}}))
+	assert.Len(t, mm[0].Related, 1)
+	assert.Len(t, mm[1].Related, 1)
+	assert.Empty(t, mm[2].Related)
+
+	// limit is applied per model
+	rec = &queryRecorder{Executor: db}
+	require.NoError(t, PreloadRelation(rec, mm, "Related", &Options{Limit: 2, OrderBy: &OrderBy{Field: "rowid", Order: "desc"}}))
+	assert.Len(t, rec.queries, 3)
+	ids := func(rr []*relatingModel) (ids []int64) {
+		for _, r := range rr {
+			ids = append(ids, r.ID)
+		}
+		return ids
+	}
+	assert.Equal(t, []int64{3, 2}, ids(mm[0].Related))
+	assert.Equal(t, []int64{5, 4}, ids(mm[1].Related))
+	assert.Empty(t, mm[2].Related)
+
+	assert.Error(t, PreloadRelation(db, &mm, "name", nil))
+	assert.Error(t, PreloadRelation(db, &mm, "missing", nil))
+	assert.Error(t, PreloadRelation(db, mm[0], "Related", nil))
+	var rr []*relatingModel
+	assert.Error(t, PreloadRelation(db, &rr, "Related", nil))
+}
@@ -1 +1,576 @@
 package ormlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Returns indexes of struct fields keyed by their column names
+func getStructColumns(t reflect.Type) map[string]int {
+	var columns = make(map[string]int)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !isExportedField(field) || field.Tag.Get(packageTagName) == "-" {
+			continue
+		}
+		columns[getFieldColumnName(field)] = i
+	}
+	return columns
+}
+
+// ScanRows scans rows into a struct or a slice of structs (or pointers to them)
+// that are not required to be models. Columns are mapped to the fields by `col`
+// setting or snake case representation of the field name, columns that have no
+// matching field are skipped. If dst is a pointer to struct only the first row
+// is scanned and sql.ErrNoRows is returned when there are no rows at all.
+// Rows are closed after scanning.
+func ScanRows(rows *sql.Rows, dst interface{}) error {
+	defer rows.Close()
+
+	dv := reflect.ValueOf(dst)
+	if dv.Kind() != reflect.Ptr || dv.IsNil() {
+		return errors.Errorf("expected pointer to struct or slice, got %T", dst)
+	}
+	dv = dv.Elem()
+
+	var (
+		isSlice  = dv.Kind() == reflect.Slice
+		elemType = dv.Type()
+		isPtr    bool
+	)
+	if isSlice {
+		elemType = elemType.Elem()
+		if elemType.Kind() == reflect.Ptr {
+			isPtr = true
+			elemType = elemType.Elem()
+		}
+	}
+	if elemType.Kind() != reflect.Struct {
+		return errors.Errorf("expected pointer to struct or slice of structs, got %T", dst)
+	}
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	fields := getStructColumns(elemType)
+
+	for rows.Next() {
+		var (
+			elem = reflect.New(elemType)
+			ptrs = make([]interface{}, len(columns))
+		)
+		for i, col := range columns {
+			if idx, ok := fields[col]; ok {
+				ptrs[i] = scanDest(elem.Elem().Field(idx))
+			} else {
+				ptrs[i] = new(interface{})
+			}
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return err
+		}
+		if !isSlice {
+			dv.Set(elem.Elem())
+			return rows.Close()
+		}
+		if isPtr {
+			dv.Set(reflect.Append(dv, elem))
+		} else {
+			dv.Set(reflect.Append(dv, elem.Elem()))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if !isSlice {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// QueryRaw is the same as QueryRawContext with default timeout
+func QueryRaw(db Executor, dst interface{}, query string, args ...interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+	return QueryRawContext(ctx, db, dst, query, args...)
+}
+
+// QueryRawContext runs raw sql query and scans its rows into dst like ScanRows
+// does. Arguments are either positional or named with sql.Named, e.g.
+// QueryRaw(db, &users, "select * from users where age > @age", sql.Named("age", 18)),
+// they can't be mixed.
+func QueryRawContext(ctx context.Context, db Executor, dst interface{}, query string, args ...interface{}) error {
+	if err := checkNamedArgs(args); err != nil {
+		return err
+	}
+	query = normalizeNamedParams(query, args)
+	debugQuery(query, args)
+	rows, err := queryContext(ctx, db, query, args...)
+	if err != nil {
+		return &Error{err, query, args}
+	}
+	defer rows.Close()
+	return ScanRows(rows.Rows, dst)
+}
+
+// Replaces @name and $name parameters of named arguments with :name ones
+// outside of quoted strings and identifiers, since the driver binds named
+// arguments by :name only
+func normalizeNamedParams(query string, args []interface{}) string {
+	names := make(map[string]bool)
+	for _, a := range args {
+		if na, ok := a.(sql.NamedArg); ok {
+			names[na.Name] = true
+		}
+	}
+	if len(names) == 0 {
+		return query
+	}
+	var (
+		b     strings.Builder
+		quote byte
+	)
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '@' || c == '$':
+			j := i + 1
+			for j < len(query) && (query[j] == '_' || isAlphaNumeric(query[j])) {
+				j++
+			}
+			if names[query[i+1:j]] {
+				c = ':'
+			}
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+func isAlphaNumeric(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// Checks that arguments are either all named or all positional
+func checkNamedArgs(args []interface{}) error {
+	var named int
+	for _, a := range args {
+		if _, ok := a.(sql.NamedArg); ok {
+			named++
+		}
+	}
+	if named != 0 && named != len(args) {
+		return errors.New("named and positional arguments can't be mixed")
+	}
+	return nil
+}
+
+// UnionPart describes one of the queries composed by QueryUnion
+type UnionPart struct {
+	Model   Model
+	Options *Options
+}
+
+// QueryUnion is the same as QueryUnionContext with default timeout
+func QueryUnion(db Executor, dst interface{}, all bool, parts ...UnionPart) error {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+	return QueryUnionContext(ctx, db, dst, all, parts...)
+}
+
+// QueryUnionContext combines results of queries described by parts with
+// `union` (or `union all` if all is true) and scans them into dst, which must be
+// a pointer to slice of models. Every part must select the same model type and
+// the same set of columns. Relations are not loaded and RelatedTo options are
+// not supported.
+func QueryUnionContext(ctx context.Context, db Executor, dst interface{}, all bool, parts ...UnionPart) error {
+	if len(parts) == 0 {
+		return errors.New("union requires at least one query")
+	}
+	dv := reflect.ValueOf(dst)
+	if dv.Kind() != reflect.Ptr || dv.Elem().Kind() != reflect.Slice {
+		return errors.Errorf("expected pointer to slice of models, got %T", dst)
+	}
+	slicePtr := dv.Elem()
+	if !slicePtr.Type().Elem().Implements(reflect.TypeOf((*Model)(nil)).Elem()) {
+		return errors.New("slice contain type that does not implement Model interface")
+	}
+	modelType := slicePtr.Type().Elem().Elem()
+	info, err := getModelInfo(reflect.New(modelType).Interface())
+	if err != nil {
+		return err
+	}
+	colInfo, err := getColumnInfo(modelType)
+	if err != nil {
+		return err
+	}
+
+	var (
+		queries  []string
+		args     []interface{}
+		selected []columnInfo
+		columns  []string
+	)
+	for i, part := range parts {
+		if reflect.TypeOf(part.Model) != slicePtr.Type().Elem() {
+			return errors.Errorf("union part %d selects %T instead of %v", i, part.Model, slicePtr.Type().Elem())
+		}
+		// parts may read rows from different tables
+		partInfo := *info
+		if err := overrideTable(&partInfo, part.Options); err != nil {
+			return err
+		}
+		ci, names := selectColumns(&partInfo, colInfo, part.Options)
+		if i == 0 {
+			selected, columns = ci, names
+		} else if strings.Join(names, ",") != strings.Join(columns, ",") {
+			return errors.Errorf("union part %d selects different columns: %v, expected: %v", i, names, columns)
+		}
+		if err := validateOrders(part.Options); err != nil {
+			return err
+		}
+		opts, err := prepareQuery(&partInfo, colInfo, part.Options)
+		if err != nil {
+			return err
+		}
+		q, a := buildSelectQuery(partInfo.table, names, opts)
+		// wrap every part to allow them having their own order and limit
+		queries = append(queries, "select * from ("+q+")")
+		args = append(args, expressionArgs(reflect.ValueOf(part.Model).Elem(), ci, opts)...)
+		args = append(args, a...)
+	}
+
+	glue := " union "
+	if all {
+		glue = " union all "
+	}
+	q := strings.Join(queries, glue)
+	debugQuery(q, args)
+	rows, err := queryContext(ctx, db, q, args...)
+	if err != nil {
+		return &Error{err, q, args}
+	}
+	defer rows.Close()
+	_, err = scanSliceRows(rows, slicePtr, modelType, selected)
+	return err
+}
+
+// QueryFunc scans rows one by one into new models of the same type as given one
+// and passes them to fn without retaining, so any number of rows can be processed.
+// Relations are loaded for each row according to options. Iteration stops when
+// fn returns an error, which is returned as is. If options have Assembler,
+// models are assembled by it and given model is not used.
+func QueryFunc(ctx context.Context, db Executor, opts *Options, model Model, fn func(Model) error) error {
+	opts = withDefaultRelationDepth(opts)
+	if opts != nil && opts.Assembler != nil {
+		db, release, err := pinConnection(ctx, db)
+		if err != nil {
+			return err
+		}
+		defer release()
+		return queryAssembledRows(ctx, db, opts, nil, func(entry reflect.Value, colInfo []columnInfo) error {
+			if err := loadAssembledRelations(ctx, db, opts, []reflect.Value{entry}, [][]columnInfo{colInfo}); err != nil {
+				return err
+			}
+			return fn(entry.Interface().(Model))
+		})
+	}
+
+	modelType := reflect.TypeOf(model)
+	if modelType.Kind() != reflect.Ptr || modelType.Elem().Kind() != reflect.Struct {
+		return errors.Errorf("expected pointer to struct, got %T", model)
+	}
+	modelType = modelType.Elem()
+
+	mInfo, err := getModelInfo(model)
+	if err != nil {
+		return err
+	}
+	if err := overrideTable(mInfo, opts); err != nil {
+		return err
+	}
+	if mInfo.table == "" {
+		return ErrNoTable
+	}
+	colInfo, err := getColumnInfo(modelType)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get column info for type: %v", modelType)
+	}
+	colInfo, colNames := selectColumns(mInfo, colInfo, opts)
+
+	if opts, err = prepareQuery(mInfo, colInfo, opts); err != nil {
+		return err
+	}
+	opts = withColumnArgs(opts, expressionArgs(reflect.ValueOf(model).Elem(), colInfo, opts))
+
+	db, release, err := pinConnection(ctx, db)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	rows, err := queryWithOptions(ctx, db, mInfo.table, colNames, opts, nil)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	scan, err := newModelScan(rows, modelType, colInfo, nil)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		entry, entryColInfo, err := scan.row(rows)
+		if err != nil {
+			return err
+		}
+		single := reflect.Append(reflect.New(reflect.SliceOf(entry.Type())).Elem(), entry)
+		if err := loadRelationsForSlice(ctx, db, opts, single, [][]columnInfo{entryColInfo}); err != nil {
+			return err
+		}
+		if err := fn(entry.Interface().(Model)); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// LatestPerGroup is the same as LatestPerGroupContext with default timeout
+func LatestPerGroup(db Executor, m Model, groupCol, orderCol string, opts *Options, dst interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+	return LatestPerGroupContext(ctx, db, m, groupCol, orderCol, opts, dst)
+}
+
+// LatestPerGroupContext loads into dst (pointer to slice of models of the same
+// type as m) one row per distinct value of groupCol, the one having the greatest
+// value of orderCol among rows matching options, e.g. latest status of every
+// user. Conditions of options select rows before they are ranked, other options
+// are applied to the selected rows like in QuerySlice.
+func LatestPerGroupContext(ctx context.Context, db Executor, m Model, groupCol, orderCol string, opts *Options, dst interface{}) error {
+	dv := reflect.ValueOf(dst)
+	if dv.Kind() != reflect.Ptr || dv.Elem().Kind() != reflect.Slice || dv.Elem().Type().Elem() != reflect.TypeOf(m) {
+		return errors.Errorf("expected pointer to slice of %T, got %T", m, dst)
+	}
+	info, err := getModelInfo(m)
+	if err != nil {
+		return err
+	}
+	if err := overrideTable(info, opts); err != nil {
+		return err
+	}
+	if info.table == "" {
+		return ErrNoTable
+	}
+	for _, col := range []string{groupCol, orderCol} {
+		if !isStoredColumn(info, col) {
+			return errors.Errorf("model %s does not have column %s", info.value.Type().Name(), col)
+		}
+	}
+	colInfo, err := getColumnInfo(info.value.Type())
+	if err != nil {
+		return err
+	}
+
+	// rows are filtered before they are ranked, so every group gets its
+	// latest matching row
+	ranked, err := prepareQuery(info, colInfo, cloneOptions(opts))
+	if err != nil {
+		return err
+	}
+	ranked.GroupBy, ranked.Having = nil, nil
+	// rows are ranked within groups by window function, so ties are broken
+	// arbitrarily but only one row of the group is selected
+	q, args := buildFilteredQuery(info.table, []string{
+		info.table + ".*",
+		fmt.Sprintf("row_number() over (partition by %[1]s.%[2]s order by %[1]s.%[3]s desc) as ormlite_rank",
+			info.table, groupCol, orderCol),
+	}, ranked)
+
+	opts = cloneOptions(opts)
+	opts.Where, opts.Exists = nil, nil
+	opts.RelatedTo, opts.RelatedExists = nil, false
+	opts.From = fmt.Sprintf("select * from (%s) where ormlite_rank = 1", q)
+	opts.fromArgs = args
+	return QuerySliceContext(ctx, db, opts, dst)
+}
+
+// Checks if the column is stored in model's table
+func isStoredColumn(info *modelInfo, column string) bool {
+	for _, f := range info.fields {
+		if isOmittedField(f) || isExpressionField(f) || isReferenceField(f) && !isHasOne(f) {
+			continue
+		}
+		if f.column == column {
+			return true
+		}
+	}
+	return false
+}
+
+// ExportCSV writes rows of the model's table to w as CSV with a header of
+// selected column names, Options select and filter rows like in QuerySlice.
+// Relations are not loaded and NULL values are written as empty fields.
+func ExportCSV(ctx context.Context, db Executor, m Model, opts *Options, w io.Writer) error {
+	mInfo, err := getModelInfo(m)
+	if err != nil {
+		return err
+	}
+	if err := overrideTable(mInfo, opts); err != nil {
+		return err
+	}
+	if mInfo.table == "" {
+		return ErrNoTable
+	}
+	colInfo, err := getColumnInfo(mInfo.value.Type())
+	if err != nil {
+		return errors.Wrapf(err, "failed to get column info for type: %v", mInfo.value.Type())
+	}
+	colInfo, colNames := selectColumns(mInfo, colInfo, opts)
+
+	if opts, err = prepareQuery(mInfo, colInfo, opts); err != nil {
+		return err
+	}
+	opts = withColumnArgs(opts, expressionArgs(mInfo.value, colInfo, opts))
+
+	rows, err := queryWithOptions(ctx, db, mInfo.table, colNames, opts, nil)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(w)
+	if err := cw.Write(columns); err != nil {
+		return err
+	}
+	var (
+		values = make([]interface{}, len(columns))
+		ptrs   = make([]interface{}, len(columns))
+		record = make([]string, len(columns))
+	)
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return err
+		}
+		for i, v := range values {
+			record[i] = formatCSVValue(v)
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Formats scanned value as CSV field
+func formatCSVValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(v)
+}
+
+// Returns connection of the pool to run queries on. Unlike QuerySlice, which
+// closes rows before loading relations, QueryFunc loads them while rows are
+// still open, so without pinning every relation query would need a second
+// connection of the pool and block forever if pool is limited to one
+func pinConnection(ctx context.Context, db Executor) (Executor, func(), error) {
+	pool, ok := db.(*sql.DB)
+	if !ok {
+		return db, func() {}, nil
+	}
+	conn, err := pool.Conn(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return conn, func() { conn.Close() }, nil
+}
+
+// QueryMap is the same as QueryMapContext with default timeout
+func QueryMap(db Executor, opts *Options, dst interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+	return QueryMapContext(ctx, db, opts, dst)
+}
+
+// QueryMapContext loads models like QuerySliceContext but stores them into the
+// map pointed by dst (e.g. *map[int64]*Model) keyed by primary key. Compound
+// keys are joined with comma and require map to have string keys.
+func QueryMapContext(ctx context.Context, db Executor, opts *Options, dst interface{}) error {
+	mapPtr := reflect.ValueOf(dst)
+	if mapPtr.Kind() != reflect.Ptr || mapPtr.Elem().Kind() != reflect.Map {
+		return errors.Errorf("expected pointer to map, got %T", dst)
+	}
+	mapValue := mapPtr.Elem()
+
+	slicePtr := reflect.New(reflect.SliceOf(mapValue.Type().Elem()))
+	if err := QuerySliceContext(ctx, db, opts, slicePtr.Interface()); err != nil {
+		return err
+	}
+
+	if mapValue.IsNil() {
+		mapValue.Set(reflect.MakeMap(mapValue.Type()))
+	}
+	slice := slicePtr.Elem()
+	for i := 0; i < slice.Len(); i++ {
+		pkFields, err := getPrimaryFieldsInfo(slice.Index(i).Elem())
+		if err != nil {
+			return err
+		}
+		key, err := primaryMapKey(pkFields, mapValue.Type().Key())
+		if err != nil {
+			return err
+		}
+		mapValue.SetMapIndex(key, slice.Index(i))
+	}
+	return nil
+}
+
+// Converts primary key values to the map key of given type
+func primaryMapKey(pkFields []pkFieldInfo, keyType reflect.Type) (reflect.Value, error) {
+	if len(pkFields) == 0 {
+		return reflect.Value{}, errors.New("model does not have primary key")
+	}
+	if keyType.Kind() == reflect.String {
+		parts := make([]string, len(pkFields))
+		for i, pk := range pkFields {
+			parts[i] = fmt.Sprint(pk.field.Interface())
+		}
+		return reflect.ValueOf(strings.Join(parts, ",")).Convert(keyType), nil
+	}
+	if len(pkFields) == 1 && pkFields[0].field.Type().ConvertibleTo(keyType) {
+		return pkFields[0].field.Convert(keyType), nil
+	}
+	return reflect.Value{}, errors.Errorf("can't use primary key as %v map key", keyType)
+}
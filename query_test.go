@@ -0,0 +1,344 @@
+package ormlite
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScanRows(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	_, err = db.Exec(`
+		create table test(id integer primary key, attr int);
+		insert into test(attr) values (1), (1), (2), (2), (2);
+	`)
+	require.NoError(t, err)
+
+	type group struct {
+		Attr int
+		Cnt  int
+	}
+
+	rows, err := db.Query("select attr, count(*) as cnt from test group by attr order by attr")
+	require.NoError(t, err)
+	var groups []group
+	if assert.NoError(t, ScanRows(rows, &groups)) {
+		assert.Equal(t, []group{{1, 2}, {2, 3}}, groups)
+	}
+
+	rows, err = db.Query("select max(attr) as attr, count(*) as total from test")
+	require.NoError(t, err)
+	var single struct {
+		Attr  int
+		Total int `ormlite:"col=total"`
+	}
+	if assert.NoError(t, ScanRows(rows, &single)) {
+		assert.Equal(t, 2, single.Attr)
+		assert.Equal(t, 5, single.Total)
+	}
+
+	rows, err = db.Query("select attr from test where id = 100")
+	require.NoError(t, err)
+	assert.Equal(t, sql.ErrNoRows, ScanRows(rows, &single))
+}
+
+func TestQueryRaw(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(`
+		create table simple_model(id integer primary key, not_tagged_field text, tagged_field text);
+		insert into simple_model(not_tagged_field, tagged_field) values ('a', 'x'), ('b', 'y'), ('c', 'x');
+	`)
+	require.NoError(t, err)
+
+	var mm []simpleModel
+	require.NoError(t, QueryRaw(db, &mm,
+		"select * from simple_model where tagged_field = @tag and id > :min order by id",
+		sql.Named("tag", "x"), sql.Named("min", 1)))
+	if assert.Len(t, mm, 1) {
+		assert.Equal(t, "c", mm[0].NotTaggedField)
+	}
+
+	var m simpleModel
+	require.NoError(t, QueryRaw(db, &m, "select * from simple_model where id = ?", 2))
+	assert.Equal(t, simpleModel{ID: 2, NotTaggedField: "b", TaggedField: "y"}, m)
+
+	// parameters in quoted strings are kept
+	mm = nil
+	require.NoError(t, QueryRaw(db, &mm,
+		"select id, '@tag' as not_tagged_field, tagged_field from simple_model where tagged_field = $tag", sql.Named("tag", "y")))
+	if assert.Len(t, mm, 1) {
+		assert.Equal(t, simpleModel{ID: 2, NotTaggedField: "@tag", TaggedField: "y"}, mm[0])
+	}
+
+	err = QueryRaw(db, &mm, "select * from simple_model where tagged_field = @tag and id > ?", sql.Named("tag", "x"), 1)
+	assert.Error(t, err)
+}
+
+func TestQueryUnion(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	_, err = db.Exec(`
+		create table simple_model(id integer primary key, not_tagged_field text, tagged_field text);
+		insert into simple_model(not_tagged_field, tagged_field) values
+			('first', 'a'), ('second', 'b'), ('third', 'c'), ('fourth', 'a');
+	`)
+	require.NoError(t, err)
+
+	var mm []*simpleModel
+	err = QueryUnion(db, &mm, false,
+		UnionPart{&simpleModel{}, &Options{Where: Where{"tagged_field": StrictString("a")}}},
+		UnionPart{&simpleModel{}, &Options{Where: Where{"id": Less(3)}}},
+	)
+	if assert.NoError(t, err) {
+		var ids []int64
+		for _, m := range mm {
+			ids = append(ids, m.ID)
+		}
+		assert.ElementsMatch(t, []int64{1, 2, 4}, ids)
+	}
+
+	mm = nil
+	err = QueryUnion(db, &mm, true,
+		UnionPart{&simpleModel{}, &Options{Where: Where{"tagged_field": StrictString("a")}}},
+		UnionPart{&simpleModel{}, &Options{Where: Where{"id": Less(3)}}},
+	)
+	if assert.NoError(t, err) {
+		assert.Len(t, mm, 4)
+	}
+
+	err = QueryUnion(db, &mm, true,
+		UnionPart{&simpleModel{}, nil},
+		UnionPart{&simpleModel{}, &Options{Columns: map[string]struct{}{"tagged_field": {}}}},
+	)
+	assert.Error(t, err)
+}
+
+func TestQueryUnionPreparesParts(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(`
+		create table trash_children(id integer primary key, parent_id int, name text, deleted_at timestamp);
+		insert into trash_children(parent_id, name, deleted_at) values
+			(1, 'a', null), (1, 'b', current_timestamp), (2, 'c', null);
+	`)
+	require.NoError(t, err)
+
+	var cc []*trashChild
+	err = QueryUnion(db, &cc, false,
+		UnionPart{&trashChild{}, &Options{Where: Where{"name": StrictString("b")}}},
+		UnionPart{&trashChild{}, &Options{Where: Where{"id": Greater(2)}}},
+	)
+	if assert.NoError(t, err) && assert.Len(t, cc, 1) {
+		assert.Equal(t, "c", cc[0].Name)
+	}
+
+	cc = nil
+	err = QueryUnion(db, &cc, false,
+		UnionPart{&trashChild{}, &Options{Where: Where{"name": StrictString("b")}, WithTrashed: true}},
+		UnionPart{&trashChild{}, nil},
+	)
+	if assert.NoError(t, err) {
+		assert.Len(t, cc, 3)
+	}
+
+	err = QueryUnion(db, &cc, false,
+		UnionPart{&trashChild{}, nil},
+		UnionPart{&trashChild{}, &Options{Where: Where{"name": Col("name) or 1=1 --")}}},
+	)
+	assert.Error(t, err)
+}
+
+func TestQueryFunc(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	// relations are loaded while rows are open, so they share the connection
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(`
+		create table big_model(id integer primary key, attr1 int, attr2 int, attr3 string, attr4 float, rel_id int);
+		create table related_model(id integer primary key, field text);
+		insert into related_model(field) values ('related');
+		insert into big_model(attr1, attr2, attr3, attr4, rel_id) values (1, 0, '', 0, null), (2, 0, '', 0, 1), (3, 0, '', 0, null);
+	`)
+	require.NoError(t, err)
+
+	var (
+		sum     int
+		related int
+	)
+	require.NoError(t, QueryFunc(context.Background(), db, DefaultOptions(), &BigModel{}, func(m Model) error {
+		bm := m.(*BigModel)
+		sum += bm.Attr1
+		if bm.Related != nil {
+			assert.Equal(t, "related", bm.Related.Field)
+			related++
+		}
+		return nil
+	}))
+	assert.Equal(t, 6, sum)
+	assert.Equal(t, 1, related)
+
+	stop := errors.New("stop")
+	var calls int
+	err = QueryFunc(context.Background(), db, nil, &BigModel{}, func(m Model) error {
+		calls++
+		return stop
+	})
+	assert.Equal(t, stop, err)
+	assert.Equal(t, 1, calls)
+}
+
+type statusEntry struct {
+	ID        int64 `ormlite:"primary"`
+	Attr      string
+	Status    string
+	CreatedAt int64
+}
+
+func (*statusEntry) Table() string { return "status_entry" }
+
+func TestLatestPerGroup(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(`
+		create table status_entry(id integer primary key, attr text, status text, created_at int);
+		insert into status_entry(attr, status, created_at) values
+			('a', 'new', 1), ('b', 'new', 2), ('a', 'active', 3), ('c', 'new', 4), ('b', 'closed', 5), ('a', 'closed', 2);
+	`)
+	require.NoError(t, err)
+
+	var latest []*statusEntry
+	require.NoError(t, LatestPerGroup(db, &statusEntry{}, "attr", "created_at", &Options{OrderBy: &OrderBy{Field: "attr"}}, &latest))
+	assert.Equal(t, []*statusEntry{
+		{ID: 3, Attr: "a", Status: "active", CreatedAt: 3},
+		{ID: 5, Attr: "b", Status: "closed", CreatedAt: 5},
+		{ID: 4, Attr: "c", Status: "new", CreatedAt: 4},
+	}, latest)
+
+	// conditions select rows before they are ranked, so groups which latest
+	// row doesn't match get their latest matching one
+	latest = nil
+	opts := &Options{Where: Where{"status": StrictString("new")}, OrderBy: &OrderBy{Field: "attr"}}
+	require.NoError(t, LatestPerGroup(db, &statusEntry{}, "attr", "created_at", opts, &latest))
+	assert.Equal(t, []*statusEntry{
+		{ID: 1, Attr: "a", Status: "new", CreatedAt: 1},
+		{ID: 2, Attr: "b", Status: "new", CreatedAt: 2},
+		{ID: 4, Attr: "c", Status: "new", CreatedAt: 4},
+	}, latest)
+	assert.Empty(t, opts.From)
+
+	// soft deleted rows are never ranked
+	_, err = db.Exec(`
+		create table trash_children(id integer primary key, parent_id int, name text, deleted_at timestamp);
+		insert into trash_children(parent_id, name, deleted_at) values
+			(1, 'a', null), (1, 'b', current_timestamp), (2, 'c', null);
+	`)
+	require.NoError(t, err)
+	var children []*trashChild
+	require.NoError(t, LatestPerGroup(db, &trashChild{}, "parent_id", "id", &Options{OrderBy: &OrderBy{Field: "id"}}, &children))
+	if assert.Len(t, children, 2) {
+		assert.Equal(t, "a", children[0].Name)
+		assert.Equal(t, "c", children[1].Name)
+	}
+
+	assert.Error(t, LatestPerGroup(db, &statusEntry{}, "attr; drop table status_entry", "created_at", nil, &latest))
+	assert.Error(t, LatestPerGroup(db, &statusEntry{}, "attr", "created_at", nil, &[]*simpleModel{}))
+}
+
+func TestExportCSV(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(`
+		create table simple_model(id integer primary key, not_tagged_field text, tagged_field text);
+		insert into simple_model(not_tagged_field, tagged_field) values ('test', 'test, tagged'), ('asdad', null), ('1111', '22222');
+	`)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, ExportCSV(context.Background(), db, &simpleModel{}, nil, &buf))
+	assert.Equal(t, "id,not_tagged_field,tagged_field\n"+
+		"1,test,\"test, tagged\"\n"+
+		"2,asdad,\n"+
+		"3,1111,22222\n", buf.String())
+
+	buf.Reset()
+	opts := &Options{
+		Where:   Where{"id": Greater(1)},
+		Columns: map[string]struct{}{"tagged_field": {}},
+		OrderBy: &OrderBy{Field: "id", Order: "desc"},
+	}
+	require.NoError(t, ExportCSV(context.Background(), db, &simpleModel{}, opts, &buf))
+	assert.Equal(t, "id,tagged_field\n3,22222\n2,\n", buf.String())
+}
+
+func TestQueryMap(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(`
+		create table simple_model(id integer primary key, not_tagged_field text, tagged_field text);
+		insert into simple_model(not_tagged_field, tagged_field) values ('1', 'a'), ('2', 'b'), ('3', 'c');
+		create table model_with_compound_primary_key(first_id int, second_id int, field text, primary key(first_id, second_id));
+		insert into model_with_compound_primary_key values (1, 1, 'first'), (1, 2, 'second');
+	`)
+	require.NoError(t, err)
+
+	var mm map[int64]*simpleModel
+	require.NoError(t, QueryMap(db, DefaultOptions(), &mm))
+	if assert.Len(t, mm, 3) {
+		for id, m := range mm {
+			assert.Equal(t, id, m.ID)
+		}
+		assert.Equal(t, "b", mm[2].TaggedField)
+	}
+
+	var cm map[string]*modelWithCompoundPrimaryKey
+	require.NoError(t, QueryMap(db, DefaultOptions(), &cm))
+	if assert.Len(t, cm, 2) {
+		assert.Equal(t, "second", cm["1,2"].Field)
+	}
+
+	var wrong map[float32]*modelWithCompoundPrimaryKey
+	assert.Error(t, QueryMap(db, DefaultOptions(), &wrong))
+}
+
+func TestScanNullAggregate(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(`
+		create table simple_model(id integer primary key, not_tagged_field text, tagged_field text);
+		insert into simple_model(not_tagged_field) values ('1');
+	`)
+	require.NoError(t, err)
+
+	type total struct {
+		Sum   int64
+		Avg   float64
+		Label string
+	}
+	var res total
+	rows, err := db.Query("select sum(id) as sum, avg(id) as avg, max(tagged_field) as label from simple_model where id > 100")
+	require.NoError(t, err)
+	require.NoError(t, ScanRows(rows, &res))
+	assert.Equal(t, total{}, res)
+
+	var m simpleModel
+	require.NoError(t, QueryStruct(db, &Options{Where: Where{"id": 1}}, &m))
+	assert.Equal(t, "", m.TaggedField, "NULL column should be scanned as zero value")
+
+	var mm []*simpleModel
+	require.NoError(t, QuerySlice(db, nil, &mm))
+	assert.Len(t, mm, 1)
+}
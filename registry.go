@@ -0,0 +1,66 @@
+package ormlite
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+var registry = struct {
+	sync.RWMutex
+	models map[string]Model
+}{models: make(map[string]Model)}
+
+// Register adds models to the global registry keyed by their table names,
+// registering several models with the same table is an error
+func Register(models ...Model) error {
+	registry.Lock()
+	defer registry.Unlock()
+
+	batch := make(map[string]Model, len(models))
+	for _, m := range models {
+		if _, err := getModelInfo(m); err != nil {
+			return errors.Wrap(err, "can't register model")
+		}
+		table := m.Table()
+		if _, ok := registry.models[table]; ok {
+			return errors.Errorf("model with table %s is already registered", table)
+		}
+		if _, ok := batch[table]; ok {
+			return errors.Errorf("model with table %s is already registered", table)
+		}
+		batch[table] = m
+	}
+	for table, m := range batch {
+		registry.models[table] = m
+	}
+	return nil
+}
+
+// Unregister removes models from the global registry
+func Unregister(models ...Model) {
+	registry.Lock()
+	for _, m := range models {
+		delete(registry.models, m.Table())
+	}
+	registry.Unlock()
+}
+
+// RegisteredModels returns registered models ordered by table name
+func RegisteredModels() []Model {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	tables := make([]string, 0, len(registry.models))
+	for table := range registry.models {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	models := make([]Model, 0, len(tables))
+	for _, table := range tables {
+		models = append(models, registry.models[table])
+	}
+	return models
+}
@@ -0,0 +1,24 @@
+package ormlite
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	models := []Model{&simpleModel{}, &modelWithUniqueGroups{}, &BigModel{}}
+	require.NoError(t, Register(models...))
+	defer Unregister(models...)
+
+	var tables []string
+	for _, m := range RegisteredModels() {
+		tables = append(tables, m.Table())
+	}
+	assert.Equal(t, []string{"big_model", "simple_model", "unique_groups"}, tables)
+
+	assert.Error(t, Register(&simpleModel{}), "duplicate table should be an error")
+	assert.Error(t, Register(&relatedModel{}, &relatedModel{}), "duplicate in one batch should be an error")
+	assert.Len(t, RegisteredModels(), 3, "failed registration shouldn't change the registry")
+}
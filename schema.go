@@ -0,0 +1,90 @@
+package ormlite
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+type indexInfo struct {
+	name    string
+	columns []string
+	where   string
+}
+
+// Collects indexes declared by model fields, fields tagged with bare index
+// setting get their own index, named ones (index=name) are grouped in order
+// of fields
+func getModelIndexes(info *modelInfo) ([]*indexInfo, error) {
+	var (
+		indexes []*indexInfo
+		named   = make(map[string]*indexInfo)
+	)
+	for i := 0; i < info.value.NumField(); i++ {
+		f := info.value.Type().Field(i)
+		if !isExportedField(f) {
+			continue
+		}
+		tag := f.Tag.Get(packageTagName)
+		name := lookForSetting(tag, "index")
+		if name == "" {
+			continue
+		}
+		if _, ok := info.value.Field(i).Interface().(Expression); ok {
+			return nil, errors.Errorf("expression field %s can't be indexed", f.Name)
+		}
+		column := getFieldColumnName(f)
+		if name == "index" {
+			name = fmt.Sprintf("%s_%s_idx", info.table, column)
+		}
+		if err := validateTableName(name); err != nil {
+			return nil, errors.Wrapf(err, "invalid index of field %s", f.Name)
+		}
+		idx, ok := named[name]
+		if !ok {
+			idx = &indexInfo{name: name}
+			named[name] = idx
+			indexes = append(indexes, idx)
+		}
+		idx.columns = append(idx.columns, column)
+		if where := lookForExprSetting(tag, "where"); where != "" {
+			if idx.where != "" && idx.where != where {
+				return nil, errors.Errorf("index %s has different conditions", name)
+			}
+			idx.where = where
+		}
+	}
+	return indexes, nil
+}
+
+// CreateIndexes creates indexes declared by `index` setting of model fields
+// unless they exist, `index=name` groups several fields into one index and
+// `where=condition` makes it partial, e.g. `ormlite:"index,where=deleted_at is null"`
+func CreateIndexes(db Executor, m Model) error {
+	return CreateIndexesContext(context.Background(), db, m)
+}
+
+// CreateIndexesContext is the same as CreateIndexes but with given context
+func CreateIndexesContext(ctx context.Context, db Executor, m Model) error {
+	info, err := getModelInfo(m)
+	if err != nil {
+		return err
+	}
+	indexes, err := getModelIndexes(info)
+	if err != nil {
+		return err
+	}
+	for _, idx := range indexes {
+		query := fmt.Sprintf("create index if not exists %s on %s (%s)", idx.name, info.table, strings.Join(idx.columns, ","))
+		if idx.where != "" {
+			query += " where " + idx.where
+		}
+		debugQuery(query, nil)
+		if _, err := execContext(ctx, db, query); err != nil {
+			return &Error{err, query, nil}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package ormlite
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type indexedModel struct {
+	ID        int64        `ormlite:"primary"`
+	Email     string       `ormlite:"index,where=deleted_at is null"`
+	FirstName string       `ormlite:"index=indexed_model_name"`
+	LastName  string       `ormlite:"index=indexed_model_name"`
+	DeletedAt sql.NullTime `ormlite:"softdelete"`
+}
+
+func (*indexedModel) Table() string { return "indexed_model" }
+
+type statusIndexedModel struct {
+	ID     int64 `ormlite:"primary"`
+	Status int   `ormlite:"index=status_idx,where=status in (1, 2),notnull"`
+}
+
+func (*statusIndexedModel) Table() string { return "status_indexed_model" }
+
+type invalidIndexModel struct {
+	ID   int64  `ormlite:"primary"`
+	Name string `ormlite:"index=bad name"`
+}
+
+func (*invalidIndexModel) Table() string { return "invalid_index_model" }
+
+func TestCreateIndexes(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`create table indexed_model (id integer primary key, email text, first_name text, last_name text, deleted_at datetime)`)
+	require.NoError(t, err)
+
+	require.NoError(t, CreateIndexes(db, &indexedModel{}))
+	// existing indexes are kept
+	require.NoError(t, CreateIndexes(db, &indexedModel{}))
+
+	indexes := make(map[string]string)
+	rows, err := db.Query(`select name, sql from sqlite_master where type = 'index' and tbl_name = 'indexed_model'`)
+	require.NoError(t, err)
+	for rows.Next() {
+		var name, sql string
+		require.NoError(t, rows.Scan(&name, &sql))
+		indexes[name] = sql
+	}
+	require.NoError(t, rows.Err())
+	assert.Equal(t, map[string]string{
+		"indexed_model_email_idx": "CREATE INDEX indexed_model_email_idx on indexed_model (email) where deleted_at is null",
+		"indexed_model_name":      "CREATE INDEX indexed_model_name on indexed_model (first_name,last_name)",
+	}, indexes)
+
+	var plan string
+	require.NoError(t, db.QueryRow(`explain query plan select id from indexed_model where email = 'a' and deleted_at is null`).
+		Scan(new(int), new(int), new(int), &plan))
+	assert.Contains(t, plan, "indexed_model_email_idx")
+
+	// condition containing commas isn't truncated
+	_, err = db.Exec(`create table status_indexed_model (id integer primary key, status int not null)`)
+	require.NoError(t, err)
+	require.NoError(t, CreateIndexes(db, &statusIndexedModel{}))
+	var index string
+	require.NoError(t, db.QueryRow(`select sql from sqlite_master where name = 'status_idx'`).Scan(&index))
+	assert.Equal(t, "CREATE INDEX status_idx on status_indexed_model (status) where status in (1, 2)", index)
+	assert.True(t, IsFieldRequired(Insert(db, &statusIndexedModel{})))
+
+	assert.Error(t, CreateIndexes(db, &invalidIndexModel{}))
+}
@@ -0,0 +1,103 @@
+package ormlite
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+)
+
+// Tracer starts spans around database calls and relation loads. Op is "query"
+// or "exec" for database calls made with the query and "load <column>" for
+// loads of relation field with the column name, query of load is empty.
+// Returned context is used by the call, so spans of queries loading relation
+// are nested in the span of the load, finish is called with error of the call,
+// queries finish their spans once rows are read or closed.
+type Tracer interface {
+	StartSpan(ctx context.Context, op, query string) (context.Context, func(err error))
+}
+
+type noopTracer struct{}
+
+func (noopTracer) StartSpan(ctx context.Context, _, _ string) (context.Context, func(err error)) {
+	return ctx, func(error) {}
+}
+
+var tracer = struct {
+	sync.RWMutex
+	Tracer
+}{Tracer: noopTracer{}}
+
+// SetTracer sets tracer used to start spans of all queries, nil restores
+// default one which does nothing
+func SetTracer(t Tracer) {
+	if t == nil {
+		t = noopTracer{}
+	}
+	tracer.Lock()
+	tracer.Tracer = t
+	tracer.Unlock()
+}
+
+func startSpan(ctx context.Context, op, query string) (context.Context, func(err error)) {
+	tracer.RLock()
+	t := tracer.Tracer
+	tracer.RUnlock()
+	return t.StartSpan(ctx, op, query)
+}
+
+// tracedRows finishes span of the query once rows are read or closed, since
+// rows are fetched by the query while they are iterated
+type tracedRows struct {
+	*sql.Rows
+	finish func(err error)
+	once   sync.Once
+}
+
+// Next prepares the next row like sql.Rows does finishing span of the query
+// when there are no more rows
+func (r *tracedRows) Next() bool {
+	if r.Rows.Next() {
+		return true
+	}
+	r.end()
+	return false
+}
+
+// Close closes rows like sql.Rows does finishing span of the query
+func (r *tracedRows) Close() error {
+	err := r.Rows.Close()
+	r.end()
+	return err
+}
+
+func (r *tracedRows) end() {
+	r.once.Do(func() { r.finish(r.Rows.Err()) })
+}
+
+// Starts query returning rows which finish its span when they are read or
+// closed, so rows must be closed if they are not read till the end
+func queryContext(ctx context.Context, db Executor, query string, args ...interface{}) (*tracedRows, error) {
+	ctx, finish := startSpan(ctx, "query", query)
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		finish(err)
+		return nil, err
+	}
+	return &tracedRows{Rows: rows, finish: finish}, nil
+}
+
+func execContext(ctx context.Context, db Executor, query string, args ...interface{}) (sql.Result, error) {
+	ctx, finish := startSpan(ctx, "exec", query)
+	res, err := db.ExecContext(ctx, query, args...)
+	finish(err)
+	return res, err
+}
+
+// Queries a single row scanning it into dest, span of the query is finished
+// with error of the scan since errors of row are deferred till then
+func queryRowScan(ctx context.Context, db Executor, query string, args []interface{}, dest ...interface{}) error {
+	ctx, finish := startSpan(ctx, "query", query)
+	err := db.QueryRowContext(ctx, query, args...).Scan(dest...)
+	finish(err)
+	return err
+}
@@ -0,0 +1,132 @@
+package ormlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSpan struct {
+	op, query string
+	parent    *fakeSpan
+	finished  bool
+	err       error
+}
+
+type fakeSpanKey struct{}
+
+// fakeTracer records spans with their parents taken from context
+type fakeTracer struct {
+	mu    sync.Mutex
+	spans []*fakeSpan
+}
+
+func (t *fakeTracer) StartSpan(ctx context.Context, op, query string) (context.Context, func(err error)) {
+	parent, _ := ctx.Value(fakeSpanKey{}).(*fakeSpan)
+	s := &fakeSpan{op: op, query: query, parent: parent}
+	t.mu.Lock()
+	t.spans = append(t.spans, s)
+	t.mu.Unlock()
+	return context.WithValue(ctx, fakeSpanKey{}, s), func(err error) {
+		s.finished, s.err = true, err
+	}
+}
+
+func TestTracer(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`
+		create table mtm_model (id integer primary key, name text);
+		create table rel_table (parent_id integer, model_id integer);
+		insert into mtm_model(name) values ('root'), ('child'), ('grandchild');
+		insert into rel_table (parent_id, model_id) values (1, 2), (2, 3);
+	`)
+	require.NoError(t, err)
+
+	tracer := &fakeTracer{}
+	SetTracer(tracer)
+	defer SetTracer(nil)
+
+	var m mtmTreeModel
+	require.NoError(t, QueryStruct(db, &Options{RelationDepth: 2, Where: Where{"id": 1}}, &m))
+	if assert.Len(t, m.Children, 1) {
+		assert.Len(t, m.Children[0].Children, 1)
+	}
+
+	var (
+		loads     []string
+		nested    *fakeSpan
+		rootQuery bool
+	)
+	for _, s := range tracer.spans {
+		assert.True(t, s.finished, "span %s isn't finished", s.op)
+		assert.NoError(t, s.err)
+		switch {
+		case s.op == "query" && s.parent == nil:
+			rootQuery = strings.HasPrefix(s.query, "select")
+		case s.op == "query":
+			assert.True(t, strings.HasPrefix(s.parent.op, "load "), "queries are nested in relation loads")
+		case s.parent == nil:
+			loads = append(loads, s.op)
+		case s.op == "load children":
+			nested = s
+		}
+	}
+	assert.True(t, rootQuery)
+	assert.ElementsMatch(t, []string{"load children", "load parents"}, loads)
+	if assert.NotNil(t, nested, "load of children's children is nested") {
+		assert.Equal(t, "load children", nested.parent.op)
+	}
+
+	// errors of calls finish spans
+	tracer.spans = nil
+	assert.Error(t, QueryStruct(db, &Options{Where: Where{"id": 1}, Table: "missing"}, &mtmTreeModel{}))
+	if assert.NotEmpty(t, tracer.spans) {
+		assert.Error(t, tracer.spans[0].err)
+	}
+
+	// span of the query lasts while its rows are read
+	tracer.spans = nil
+	var calls int
+	require.NoError(t, QueryFunc(context.Background(), db, &Options{}, &mtmTreeModel{}, func(Model) error {
+		calls++
+		if assert.Len(t, tracer.spans, 1) {
+			assert.False(t, tracer.spans[0].finished, "rows are still read")
+		}
+		return nil
+	}))
+	assert.Equal(t, 3, calls)
+	if assert.Len(t, tracer.spans, 1) {
+		assert.True(t, tracer.spans[0].finished)
+	}
+
+	// rows left unread finish their spans
+	for _, opts := range []*Options{
+		{OnMultiple: ErrorOnMultiple},
+		{From: "select 'x' as id, name from mtm_model"},
+	} {
+		tracer.spans = nil
+		require.Error(t, QueryStruct(db, opts, &mtmTreeModel{}))
+		if assert.Len(t, tracer.spans, 1) {
+			assert.True(t, tracer.spans[0].finished)
+		}
+	}
+
+	// span is finished when iteration is stopped
+	tracer.spans = nil
+	require.Error(t, QueryFunc(context.Background(), db, &Options{}, &mtmTreeModel{}, func(Model) error {
+		return errors.New("stop")
+	}))
+	if assert.Len(t, tracer.spans, 1) {
+		assert.True(t, tracer.spans[0].finished)
+	}
+}
@@ -0,0 +1,138 @@
+package ormlite
+
+import (
+	"context"
+	"database/sql/driver"
+	"reflect"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// snapshots are keyed by pointers to models, they are kept until model is
+// untracked or deleted
+var tracked = struct {
+	sync.Mutex
+	snapshots map[Model]map[string]interface{}
+}{snapshots: make(map[Model]map[string]interface{})}
+
+// Checks if model is a non nil pointer, so it can be a key of snapshots
+func isTrackable(m Model) bool {
+	v := reflect.ValueOf(m)
+	return v.Kind() == reflect.Ptr && !v.IsNil()
+}
+
+// Track remembers current column values of the model, so UpdateChanged will
+// be able to update only columns that were changed since then. Model must be
+// a pointer, snapshot is kept until Untrack is called or model is deleted
+func Track(m Model) error {
+	if !isTrackable(m) {
+		return errors.Errorf("can't track %T: model must be a non nil pointer", m)
+	}
+	data, err := columnSnapshot(m)
+	if err != nil {
+		return err
+	}
+	tracked.Lock()
+	tracked.snapshots[m] = data
+	tracked.Unlock()
+	return nil
+}
+
+// Returns column values of the model converted to driver values, so
+// snapshot doesn't share maps, slices and pointers with the model and their
+// in place changes are detected. JSON fields are kept encoded and bytes are
+// copied
+func columnSnapshot(m Model) (map[string]interface{}, error) {
+	info, err := getModelInfo(m)
+	if err != nil {
+		return nil, err
+	}
+	var data = make(map[string]interface{})
+	for _, field := range info.fields {
+		if isOmittedField(field) || isExpressionField(field) ||
+			isReferenceField(field) && !isHasOne(field) {
+			continue
+		}
+		value, err := driver.DefaultParameterConverter.ConvertValue(fieldArg(field))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to snapshot field %s", field.column)
+		}
+		if b, ok := value.([]byte); ok && b != nil {
+			value = append([]byte{}, b...)
+		}
+		data[field.column] = value
+	}
+	return data, nil
+}
+
+// Untrack forgets model snapshot taken by Track
+func Untrack(m Model) {
+	if !isTrackable(m) {
+		return
+	}
+	tracked.Lock()
+	delete(tracked.snapshots, m)
+	tracked.Unlock()
+}
+
+// Returns columns which values differ from the tracked snapshot, if model
+// wasn't tracked nil is returned
+func changedColumns(m Model) (map[string]struct{}, error) {
+	if !isTrackable(m) {
+		return nil, nil
+	}
+	tracked.Lock()
+	snapshot, ok := tracked.snapshots[m]
+	tracked.Unlock()
+	if !ok {
+		return nil, nil
+	}
+	data, err := columnSnapshot(m)
+	if err != nil {
+		return nil, err
+	}
+	var changed = make(map[string]struct{})
+	for col, value := range data {
+		if old, ok := snapshot[col]; !ok || !reflect.DeepEqual(old, value) {
+			changed[col] = struct{}{}
+		}
+	}
+	return changed, nil
+}
+
+// UpdateChangedContext updates only columns that were changed since model was
+// tracked, if nothing was changed no query is executed. Models that were not
+// tracked are updated completely.
+func UpdateChangedContext(ctx context.Context, db Executor, m Model) error {
+	mInfo, err := getModelInfo(m)
+	if err != nil {
+		return err
+	}
+	changed, err := changedColumns(m)
+	if err != nil {
+		return err
+	}
+	if changed != nil {
+		for _, field := range mInfo.fields {
+			if isPkField(field) || isReadonlyField(field) {
+				delete(changed, field.column)
+			}
+		}
+		if len(changed) == 0 {
+			return nil
+		}
+	}
+	if err := new(inserter).updateColumns(ctx, db, mInfo, changed, false); err != nil {
+		return err
+	}
+	if changed != nil {
+		return Track(m)
+	}
+	return nil
+}
+
+// UpdateChanged is the same as UpdateChangedContext with background context
+func UpdateChanged(db Executor, m Model) error {
+	return UpdateChangedContext(context.Background(), db, m)
+}
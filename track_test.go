@@ -0,0 +1,132 @@
+package ormlite
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateChanged(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+
+	_, err = db.Exec(`
+		create table simple_model(id integer primary key, not_tagged_field text, tagged_field text);
+		insert into simple_model(not_tagged_field, tagged_field) values ('not tagged', 'tagged');
+	`)
+	require.NoError(t, err)
+
+	var m simpleModel
+	require.NoError(t, QueryStruct(db, &Options{Where: Where{"id": 1}}, &m))
+	require.NoError(t, Track(&m))
+	defer Untrack(&m)
+
+	m.TaggedField = "changed"
+	changed, err := changedColumns(&m)
+	require.NoError(t, err)
+	info, err := getModelInfo(&m)
+	require.NoError(t, err)
+	q, args := buildUpdateQuery(info, changed)
+	assert.Equal(t, "update simple_model set tagged_field = ? where id = ?", q)
+	assert.Equal(t, []interface{}{"changed", int64(1)}, args)
+
+	// concurrent change of other column must survive the update
+	_, err = db.Exec("update simple_model set not_tagged_field = 'concurrent' where id = 1")
+	require.NoError(t, err)
+	require.NoError(t, UpdateChanged(db, &m))
+
+	var m1 simpleModel
+	require.NoError(t, QueryStruct(db, &Options{Where: Where{"id": 1}}, &m1))
+	assert.Equal(t, "concurrent", m1.NotTaggedField)
+	assert.Equal(t, "changed", m1.TaggedField)
+
+	// nothing changed since last update, so it's a no-op
+	_, err = db.Exec("delete from simple_model")
+	require.NoError(t, err)
+	assert.NoError(t, UpdateChanged(db, &m))
+}
+
+type trackedModel struct {
+	ID   int64 `ormlite:"primary"`
+	Meta map[string]string
+	Blob []byte
+	Name *string
+}
+
+func (*trackedModel) Table() string { return "tracked_model" }
+
+func TestUpdateChangedInPlace(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Exec(`
+		create table tracked_model(id integer primary key, meta text, blob blob, name text);
+		insert into tracked_model(meta, blob, name) values ('{"k":"a"}', x'00', 'first');
+	`)
+	require.NoError(t, err)
+
+	var m trackedModel
+	require.NoError(t, QueryStruct(db, &Options{Where: Where{"id": 1}}, &m))
+	require.NoError(t, Track(&m))
+	defer Untrack(&m)
+
+	// maps, slices and pointers are changed in place
+	m.Meta["k"] = "b"
+	m.Blob[0] = 1
+	*m.Name = "second"
+	changed, err := changedColumns(&m)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]struct{}{"meta": {}, "blob": {}, "name": {}}, changed)
+	require.NoError(t, UpdateChanged(db, &m))
+
+	var m1 trackedModel
+	require.NoError(t, QueryStruct(db, &Options{Where: Where{"id": 1}}, &m1))
+	assert.Equal(t, map[string]string{"k": "b"}, m1.Meta)
+	assert.Equal(t, []byte{1}, m1.Blob)
+	if assert.NotNil(t, m1.Name) {
+		assert.Equal(t, "second", *m1.Name)
+	}
+
+	m.Meta["k"] = "c"
+	changed, err = changedColumns(&m)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]struct{}{"meta": {}}, changed)
+}
+
+func TestTrackReleasesSnapshots(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Exec(`
+		create table simple_model(id integer primary key, not_tagged_field text, tagged_field text);
+		insert into simple_model(not_tagged_field, tagged_field) values ('not tagged', 'tagged');
+	`)
+	require.NoError(t, err)
+
+	// snapshots are keyed by pointers, so models passed by value are rejected
+	assert.Error(t, Track(simpleModelValue{}))
+	changed, err := changedColumns(simpleModelValue{})
+	require.NoError(t, err)
+	assert.Nil(t, changed)
+
+	m := &simpleModel{ID: 1}
+	require.NoError(t, Track(m))
+	Untrack(m)
+	assert.NotContains(t, tracked.snapshots, Model(m))
+
+	require.NoError(t, Track(m))
+	_, err = Delete(db, m)
+	require.NoError(t, err)
+	assert.NotContains(t, tracked.snapshots, Model(m), "deleted model is untracked")
+}
+
+// simpleModelValue implements Model by value and isn't comparable
+type simpleModelValue struct {
+	Tags []string
+}
+
+func (simpleModelValue) Table() string { return "simple_model" }
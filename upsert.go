@@ -3,6 +3,8 @@ package ormlite
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	stderrors "errors"
 	"fmt"
 	"github.com/mattn/go-sqlite3"
 	"github.com/pkg/errors"
@@ -13,26 +15,87 @@ import (
 type inserter struct {
 	depth          int
 	updateConflict bool
+	// conflicting rows are left untouched instead of being updated
+	ignoreConflict bool
+	// explicit conflict target of upsert query
+	target []string
+	// only model's own row is inserted without syncing relations
+	shallow bool
+	// conflicting row is updated partially, only with columns listed in
+	// partialColumns or non-zero ones if the list is empty
+	partial        bool
+	partialColumns []string
 }
 
-func UpsertContext(ctx context.Context, db *sql.DB, m Model) error {
+func UpsertContext(ctx context.Context, db Executor, m Model) error {
 	return insert(ctx, db, m, true)
 }
 
 // Upsert does the same think as UpsertContext with default background context
-func Upsert(db *sql.DB, m Model) error {
+func Upsert(db Executor, m Model) error {
 	return UpsertContext(context.Background(), db, m)
 }
 
-func InsertContext(ctx context.Context, db *sql.DB, m Model) error {
+func InsertContext(ctx context.Context, db Executor, m Model) error {
 	return insert(ctx, db, m, false)
 }
 
+// UpsertOnContext acts like UpsertContext but uses given columns as a conflict target
+func UpsertOnContext(ctx context.Context, db Executor, m Model, target ...string) error {
+	i := &inserter{updateConflict: true, target: target}
+	return i.insert(ctx, db, m)
+}
+
+// UpsertOn does the same as UpsertOnContext with default background context
+func UpsertOn(db Executor, m Model, target ...string) error {
+	return UpsertOnContext(context.Background(), db, m, target...)
+}
+
+// UpsertPartialContext acts like UpsertContext but updates conflicting row only with
+// given columns, if there are no columns non-zero model fields are used, so
+// other columns of existing row keep their values
+func UpsertPartialContext(ctx context.Context, db Executor, m Model, columns ...string) error {
+	i := &inserter{updateConflict: true, partial: true, partialColumns: columns}
+	return i.insert(ctx, db, m)
+}
+
+// UpsertPartial does the same as UpsertPartialContext with default background context
+func UpsertPartial(db Executor, m Model, columns ...string) error {
+	return UpsertPartialContext(context.Background(), db, m, columns...)
+}
+
+// UpsertIgnoreContext acts like UpsertContext but leaves conflicting row untouched,
+// model primary key is set to the one of existing row
+func UpsertIgnoreContext(ctx context.Context, db Executor, m Model, target ...string) error {
+	i := &inserter{ignoreConflict: true, target: target}
+	return i.insert(ctx, db, m)
+}
+
+// UpsertIgnore does the same as UpsertIgnoreContext with default background context
+func UpsertIgnore(db Executor, m Model, target ...string) error {
+	return UpsertIgnoreContext(context.Background(), db, m, target...)
+}
+
 // Insert acts like Upsert but don't update conflicting entities
-func Insert(db *sql.DB, m Model) error {
+func Insert(db Executor, m Model) error {
 	return InsertContext(context.Background(), db, m)
 }
 
+// InsertShallowContext acts like InsertContext but inserts only model's own row,
+// relations are not synced, so persisting them is the caller's responsibility.
+// Has one relations keys are still written.
+func InsertShallowContext(ctx context.Context, db Executor, m Model) error {
+	i := &inserter{shallow: true}
+	return i.insert(ctx, db, m)
+}
+
+// InsertShallow does the same as InsertShallowContext with default background context
+func InsertShallow(db Executor, m Model) error {
+	return InsertShallowContext(context.Background(), db, m)
+}
+
+// Converts keys to the array usable as map key, values are normalized to the
+// driver types, so keys of models match keys scanned from the database
 func sliceAsArray(s []interface{}) interface{} {
 	arr := reflect.New(reflect.ArrayOf(len(s), reflect.TypeOf(s).Elem())).Elem()
 	for i, j := range s {
@@ -40,7 +103,16 @@ func sliceAsArray(s []interface{}) interface{} {
 		if v.Kind() == reflect.Ptr {
 			v = v.Elem()
 		}
-		arr.Index(i).Set(v)
+		value := v.Interface()
+		if dv, err := driver.DefaultParameterConverter.ConvertValue(value); err == nil {
+			value = dv
+		}
+		if b, ok := value.([]byte); ok {
+			value = string(b)
+		}
+		if value != nil {
+			arr.Index(i).Set(reflect.ValueOf(value))
+		}
 	}
 	return arr.Interface()
 }
@@ -52,14 +124,12 @@ func buildJoinQuery(info *modelInfo, field modelField) (string, []interface{}, e
 		args           []interface{}
 		whereString    string
 	)
-	ri, err := getModelInfo(field.value)
+	refColumns, err := getRelationRefColumns(field)
 	if err != nil {
 		return "", nil, err
 	}
-	for _, f := range ri.fields {
-		if isPkField(f) {
-			columns = append(columns, field.reference.table+"."+f.reference.column)
-		}
+	for _, c := range refColumns {
+		columns = append(columns, field.reference.table+"."+c)
 	}
 	for _, f := range info.fields {
 		if isPkField(f) {
@@ -78,7 +148,9 @@ func buildJoinQuery(info *modelInfo, field modelField) (string, []interface{}, e
 		query, strings.Join(columns, ","), field.reference.table, whereString), args, nil
 }
 
-func buildUpdateQuery(info *modelInfo) (string, []interface{}) {
+// Builds update query for the model, if only is not nil just listed columns
+// will be updated
+func buildUpdateQuery(info *modelInfo, only map[string]struct{}) (string, []interface{}) {
 	var (
 		query          = "update %s set %s where %s"
 		where, columns []string
@@ -92,15 +164,19 @@ func buildUpdateQuery(info *modelInfo) (string, []interface{}) {
 		}
 		if isPkField(f) {
 			where = append(where, fmt.Sprintf("%s = ?", f.column))
-			ids = append(ids, f.value.Interface())
+			ids = append(ids, fieldArg(f))
 			continue
 		}
-		columns = append(columns, fmt.Sprintf("%s = ?", f.column))
-		if isHasOne(f) {
-			args = append(args, getRefModelPk(f))
-		} else {
-			args = append(args, f.value.Interface())
+		if isReadonlyField(f) {
+			continue
+		}
+		if only != nil {
+			if _, ok := only[f.column]; !ok {
+				continue
+			}
 		}
+		columns = append(columns, fmt.Sprintf("%s = ?", f.column))
+		args = append(args, fieldArg(f))
 	}
 
 	args = append(args, ids...)
@@ -109,24 +185,114 @@ func buildUpdateQuery(info *modelInfo) (string, []interface{}) {
 		query, info.table, strings.Join(columns, ","), strings.Join(where, AND)), args
 }
 
+// Returns columns of the upsert conflict target. Without named unique groups
+// all primary and unique columns are used together, otherwise it's either
+// primary key or the first unique group having all values set.
+func (ins *inserter) conflictColumns(info *modelInfo, indexes []string) []string {
+	if len(ins.target) != 0 {
+		return ins.target
+	}
+	var (
+		pk, order []string
+		groups    = map[string][]string{}
+		complete  = map[string]bool{}
+		named     bool
+	)
+	for _, f := range info.fields {
+		// has one relations may be parts of compound key, they are stored in
+		// their own columns
+		if isPkField(f) && (!isReferenceField(f) || isHasOne(f)) && !isZeroKey(f) {
+			pk = append(pk, f.column)
+		}
+		if !isUniqueField(f) {
+			continue
+		}
+		if f.uniqueGroup != "" {
+			named = true
+		}
+		if _, ok := groups[f.uniqueGroup]; !ok {
+			order = append(order, f.uniqueGroup)
+			complete[f.uniqueGroup] = true
+		}
+		groups[f.uniqueGroup] = append(groups[f.uniqueGroup], f.column)
+		if isZeroField(f.value) {
+			complete[f.uniqueGroup] = false
+		}
+	}
+	if !named {
+		return indexes
+	}
+	if len(pk) != 0 {
+		return pk
+	}
+	for _, g := range order {
+		if complete[g] {
+			return groups[g]
+		}
+	}
+	return nil
+}
+
+// Returns columns updated by partial upsert or nil if upsert isn't partial
+func (ins *inserter) updatedColumns(info *modelInfo) map[string]struct{} {
+	if !ins.partial {
+		return nil
+	}
+	var only = make(map[string]struct{})
+	if len(ins.partialColumns) != 0 {
+		for _, c := range ins.partialColumns {
+			only[c] = struct{}{}
+		}
+		return only
+	}
+	for _, f := range info.fields {
+		if isHasOne(f) && !f.value.IsNil() || !isHasOne(f) && !isZeroField(f.value) {
+			only[f.column] = struct{}{}
+		}
+	}
+	return only
+}
+
 func (ins *inserter) buildUpsertQuery(info *modelInfo) (string, []interface{}) {
 	var (
 		query        = "insert into %s(%s) values(%s) %s"
 		conflictTmpl = "on conflict(%s) do update set %s"
 		conflictStmt string
 		updateFields []string
+		updateArgs   []interface{}
+		only         = ins.updatedColumns(info)
 	)
 	columns, indexes, args := getModelColumns(info.fields)
-	for _, f := range columns {
+	expressions := make(map[string]string)
+	for _, f := range info.fields {
+		if f.onConflict != "" {
+			expressions[f.column] = f.onConflict
+		}
+	}
+	for i, f := range columns {
+		if only != nil {
+			if _, ok := only[f]; !ok {
+				continue
+			}
+		}
+		if expr, ok := expressions[f]; ok {
+			updateFields = append(updateFields, fmt.Sprintf("%s = %s", f, expr))
+			continue
+		}
 		updateFields = append(updateFields, fmt.Sprintf("%s = ?", f))
+		updateArgs = append(updateArgs, args[i])
 	}
 
-	if ins.updateConflict {
-		if len(indexes) != 0 {
+	if ins.ignoreConflict || ins.updateConflict && len(updateFields) == 0 {
+		if target := ins.conflictColumns(info, indexes); len(target) != 0 {
+			conflictStmt = fmt.Sprintf("on conflict(%s) do nothing", strings.Join(target, ","))
+		}
+	} else if ins.updateConflict {
+		if target := ins.conflictColumns(info, indexes); len(target) != 0 {
 			conflictStmt = fmt.Sprintf(
-				conflictTmpl, strings.Join(indexes, ","), strings.Join(updateFields, ","))
-			// wee need to double args since we use them twice
-			args = append(args, args...)
+				conflictTmpl, strings.Join(target, ","), strings.Join(updateFields, ","))
+			// updated values are bound once again after inserted ones
+			args = append(args, updateArgs...)
 		}
 	}
 
@@ -135,54 +301,90 @@ func (ins *inserter) buildUpsertQuery(info *modelInfo) (string, []interface{}) {
 		strings.Trim(strings.Repeat("?,", len(columns)), ","), conflictStmt), args
 }
 
-func buildSearchQuery(info *modelInfo) (string, []interface{}) {
+// Builds query searching model primary key by given columns, if columns are
+// empty all model columns are used
+func buildSearchQuery(info *modelInfo, columns []string) (string, []interface{}) {
 	var (
-		query       = "select id from %s where %s"
+		query       = "select %s from %s where %s"
+		pk          = "rowid"
 		whereFields []string
+		args        []interface{}
 	)
-	columns, _, args := getModelColumns(info.fields)
-	for _, f := range columns {
-		whereFields = append(whereFields, fmt.Sprintf("%s = ?", f))
+	modelColumns, _, modelArgs := getModelColumns(info.fields)
+	values := make(map[string]interface{}, len(modelColumns))
+	for i, c := range modelColumns {
+		values[c] = modelArgs[i]
 	}
-	return fmt.Sprintf(query, info.table, strings.Join(whereFields, ",")), args
+	if len(columns) == 0 {
+		columns = modelColumns
+	}
+	for _, f := range info.fields {
+		if isPkField(f) && !isReferenceField(f) {
+			pk = f.column
+		}
+	}
+	for _, c := range columns {
+		whereFields = append(whereFields, fmt.Sprintf("%s = ?", c))
+		args = append(args, values[c])
+	}
+	return fmt.Sprintf(query, pk, info.table, strings.Join(whereFields, AND)), args
 }
 
-func buildInsertRelationQuery(field modelField, info *modelInfo, values []interface{}, columns []string) (string, []interface{}) {
+// Builds query inserting several rows to the mapping table of many to many
+// relation, each row contains primary key values of related model
+func buildInsertRelationQuery(field modelField, info *modelInfo, rows [][]interface{}, columns []string) (string, []interface{}) {
 	var (
-		query = "insert into %s(%s) values (%s)"
+		query       = "insert %sinto %s(%s) values %s"
+		rowValues   []interface{}
+		values      []string
+		args        []interface{}
+		cond, value = extractConditionValue(field.reference.condition)
 	)
 
-	cond, condValue := extractConditionValue(field.reference.condition)
+	columns = append([]string(nil), columns...)
 	if cond != "" {
 		columns = append(columns, cond)
-		values = append(values, condValue)
+		rowValues = append(rowValues, value)
 	}
-
 	for _, f := range info.fields {
 		if isPkField(f) {
 			columns = append(columns, f.reference.column)
-			values = append(values, f.value.Interface())
+			rowValues = append(rowValues, f.value.Interface())
 		}
 	}
-	return fmt.Sprintf(query, field.reference.table, strings.Join(columns, ","),
-		strings.Trim(strings.Repeat("?,", len(columns)), ",")), values
+
+	placeholders := fmt.Sprintf("(%s)", strings.Trim(strings.Repeat("?,", len(columns)), ","))
+	for _, row := range rows {
+		values = append(values, placeholders)
+		args = append(args, row...)
+		args = append(args, rowValues...)
+	}
+	var ignore string
+	if field.reference.ignore {
+		ignore = "or ignore "
+	}
+	return fmt.Sprintf(query, ignore, field.reference.table, strings.Join(columns, ","), strings.Join(values, ",")), args
 }
 
-func buildDeleteRelationQuery(field modelField, info *modelInfo, keys interface{}, columns []string) (string, []interface{}) {
+// Builds query deleting several rows from the mapping table of many to many
+// relation by primary key values of related models
+func buildDeleteRelationQuery(field modelField, info *modelInfo, keys []interface{}, columns []string) (string, []interface{}) {
 	var (
 		args  []interface{}
 		where []string
+		rows  []string
 		query = "delete from %s where %s"
-		kVal  = reflect.ValueOf(keys)
 	)
 
-	for _, col := range columns {
-		where = append(where, fmt.Sprintf("%s = ?", col))
-	}
-
-	for i := 0; i < kVal.Len(); i++ {
-		args = append(args, kVal.Index(i).Interface())
+	// sqlite requires subquery on the right side of row value in operator
+	for _, k := range keys {
+		kVal := reflect.ValueOf(k)
+		for i := 0; i < kVal.Len(); i++ {
+			args = append(args, kVal.Index(i).Interface())
+		}
+		rows = append(rows, fmt.Sprintf("(%s)", strings.Trim(strings.Repeat("?,", kVal.Len()), ",")))
 	}
+	where = append(where, fmt.Sprintf("(%s) in (values %s)", strings.Join(columns, ","), strings.Join(rows, ",")))
 
 	for _, f := range info.fields {
 		if isPkField(f) {
@@ -199,7 +401,27 @@ func buildDeleteRelationQuery(field modelField, info *modelInfo, keys interface{
 	return fmt.Sprintf(query, field.reference.table, strings.Join(where, AND)), args
 }
 
-func (ins *inserter) syncRelations(ctx context.Context, db *sql.DB, info *modelInfo) error {
+// Runs fn in a transaction started on db, if db can't start it (e.g. it's
+// a transaction already) fn is run with db as is
+func withTransaction(ctx context.Context, db Executor, fn func(Executor) error) error {
+	beginner, ok := db.(interface {
+		BeginTx(context.Context, *sql.TxOptions) (*sql.Tx, error)
+	})
+	if !ok {
+		return fn(db)
+	}
+	tx, err := beginner.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (ins *inserter) syncRelations(ctx context.Context, db Executor, info *modelInfo) error {
 	if ins.depth > 0 {
 		return nil // don't update relations deeper than 1
 	}
@@ -236,16 +458,17 @@ func getRelationMapping(value reflect.Value) ([][]interface{}, error) {
 	return r, nil
 }
 
-func getStoredRelations(ctx context.Context, db *sql.DB, field modelField, info *modelInfo) ([]string, map[interface{}]bool, error) {
+func getStoredRelations(ctx context.Context, db Executor, field modelField, info *modelInfo) ([]string, map[interface{}]bool, error) {
 	q, a, err := buildJoinQuery(info, field)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	rows, err := db.QueryContext(ctx, q, a...)
+	rows, err := queryContext(ctx, db, q, a...)
 	if err != nil {
 		return nil, nil, &Error{err, q, a}
 	}
+	defer rows.Close()
 
 	cols, err := rows.Columns()
 	var result = map[interface{}]bool{}
@@ -263,7 +486,7 @@ func getStoredRelations(ctx context.Context, db *sql.DB, field modelField, info
 	return cols, result, nil
 }
 
-func (ins *inserter) syncManyToManyRelation(ctx context.Context, db *sql.DB, field modelField, info *modelInfo) error {
+func (ins *inserter) syncManyToManyRelation(ctx context.Context, db Executor, field modelField, info *modelInfo) error {
 	refValues, err := getRelationMapping(field.value)
 	if err != nil {
 		return err
@@ -274,37 +497,231 @@ func (ins *inserter) syncManyToManyRelation(ctx context.Context, db *sql.DB, fie
 		return err
 	}
 	// mark existing relations in mapping
+	var added [][]interface{}
 	for _, keys := range refValues {
 		if _, ok := mapping[sliceAsArray(keys)]; !ok {
 			// missing relation we need to add it
-			q, a := buildInsertRelationQuery(field, info, keys, refColumns)
-
-			if res, err := db.ExecContext(ctx, q, a...); err != nil {
-				return &Error{err, q, a}
-			} else {
-				if ra, err := res.RowsAffected(); err != nil || ra == 0 {
-					return errors.New("insert query din't affect any row")
-				}
-			}
+			added = append(added, keys)
 		}
 		mapping[sliceAsArray(keys)] = true
 	}
+	var removed []interface{}
 	for keys, exists := range mapping {
 		if !exists {
+			removed = append(removed, keys)
+		}
+	}
+	if len(added) == 0 && len(removed) == 0 {
+		return nil
+	}
+	return writeRelationEdges(ctx, db, field, info, refColumns, added, removed)
+}
+
+// Deletes removed and inserts added edges of many to many relation in a single
+// transaction, so relation is not left partially updated, edges are keys of
+// related models ordered as refColumns
+func writeRelationEdges(ctx context.Context, db Executor, field modelField, info *modelInfo, refColumns []string,
+	added [][]interface{}, removed []interface{}) error {
+	return withTransaction(ctx, db, func(db Executor) error {
+		// every query binds parent keys and condition value besides related keys
+		var parentArgs = 1
+		for _, f := range info.fields {
+			if isPkField(f) {
+				parentArgs++
+			}
+		}
+
+		// rows might be already deleted concurrently, so deletes affecting
+		// no rows are fine
+		batch := (maxQueryArgs - parentArgs) / len(refColumns)
+		for len(removed) != 0 {
+			keys := removed
+			if len(keys) > batch {
+				keys = keys[:batch]
+			}
+			removed = removed[len(keys):]
+
 			q, a := buildDeleteRelationQuery(field, info, keys, refColumns)
-			if res, err := db.ExecContext(ctx, q, a...); err != nil {
+			debugQuery(q, a)
+			if _, err := execContext(ctx, db, q, a...); err != nil {
 				return &Error{err, q, a}
-			} else {
-				if ra, err := res.RowsAffected(); err != nil || ra == 0 {
-					return errors.New("delete query din't affect any row")
-				}
 			}
 		}
+		// inserted rows repeat parent keys and condition value
+		batch = maxQueryArgs / (len(refColumns) + parentArgs)
+		for len(added) != 0 {
+			rows := added
+			if len(rows) > batch {
+				rows = rows[:batch]
+			}
+			added = added[len(rows):]
+
+			q, a := buildInsertRelationQuery(field, info, rows, refColumns)
+			debugQuery(q, a)
+			res, err := execContext(ctx, db, q, a...)
+			if err != nil {
+				return &Error{err, q, a}
+			}
+			// ignored edges are already stored, so it's fine to insert nothing
+			if field.reference.ignore {
+				continue
+			}
+			if ra, err := res.RowsAffected(); err != nil || ra == 0 {
+				return errors.New("insert query din't affect any row")
+			}
+		}
+		return nil
+	})
+}
+
+// AddRelation stores edges of many to many relation (given by field or column
+// name) between parent and children without touching other edges of parent,
+// edges which are already stored are skipped
+func AddRelation(db Executor, parent Model, relation string, children ...Model) error {
+	return AddRelationContext(context.Background(), db, parent, relation, children...)
+}
+
+// AddRelationContext is the same as AddRelation but with given context
+func AddRelationContext(ctx context.Context, db Executor, parent Model, relation string, children ...Model) error {
+	info, field, err := getRelationEdgesInfo(parent, relation)
+	if err != nil {
+		return err
 	}
-	return nil
+	keys, err := getChildrenKeys(field, children)
+	if err != nil {
+		return err
+	}
+	// stored edges are read by the transaction writing new ones, so edges
+	// added concurrently are never duplicated
+	return withTransaction(ctx, db, func(db Executor) error {
+		refColumns, stored, err := getStoredRelations(ctx, db, field, info)
+		if err != nil {
+			return err
+		}
+		var added [][]interface{}
+		for _, k := range keys {
+			if _, ok := stored[sliceAsArray(k)]; !ok {
+				added = append(added, k)
+				stored[sliceAsArray(k)] = true
+			}
+		}
+		if len(added) == 0 {
+			return nil
+		}
+		return writeRelationEdges(ctx, db, field, info, refColumns, added, nil)
+	})
+}
+
+// RemoveRelation deletes edges of many to many relation (given by field or
+// column name) between parent and children without touching other edges of
+// parent, missing edges are ignored
+func RemoveRelation(db Executor, parent Model, relation string, children ...Model) error {
+	return RemoveRelationContext(context.Background(), db, parent, relation, children...)
+}
+
+// RemoveRelationContext is the same as RemoveRelation but with given context
+func RemoveRelationContext(ctx context.Context, db Executor, parent Model, relation string, children ...Model) error {
+	info, field, err := getRelationEdgesInfo(parent, relation)
+	if err != nil {
+		return err
+	}
+	keys, err := getChildrenKeys(field, children)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	refColumns, err := getRelationRefColumns(field)
+	if err != nil {
+		return err
+	}
+	removed := make([]interface{}, len(keys))
+	for i, k := range keys {
+		removed[i] = sliceAsArray(k)
+	}
+	return writeRelationEdges(ctx, db, field, info, refColumns, nil, removed)
+}
+
+// Returns info of parent model and its many to many relation field given by
+// field or column name, parent must have primary key value
+func getRelationEdgesInfo(parent Model, relation string) (*modelInfo, modelField, error) {
+	info, err := getModelInfo(parent)
+	if err != nil {
+		return nil, modelField{}, err
+	}
+	column := relation
+	if f, ok := info.value.Type().FieldByName(relation); ok {
+		column = getFieldColumnName(f)
+	}
+	var (
+		field modelField
+		found bool
+	)
+	for _, f := range info.fields {
+		if f.column == column && isManyToMany(f) {
+			field, found = f, true
+		}
+	}
+	if !found {
+		return nil, field, errors.Errorf("model %s does not have many to many relation %s", info.table, relation)
+	}
+	if field.reference.view {
+		return nil, field, errors.Errorf("relation %s is read from view", relation)
+	}
+	if pkIsNull(info) {
+		return nil, field, errors.New("can't change relation of model without primary key value")
+	}
+	return info, field, nil
+}
+
+// Returns primary key values of children which have to be models of
+// relation field type with primary key value
+func getChildrenKeys(field modelField, children []Model) ([][]interface{}, error) {
+	var keys [][]interface{}
+	for _, child := range children {
+		if reflect.TypeOf(child) != field.value.Type().Elem() {
+			return nil, errors.Errorf("expected %v, got %T", field.value.Type().Elem(), child)
+		}
+		childInfo, err := getModelInfo(child)
+		if err != nil {
+			return nil, err
+		}
+		if pkIsNull(childInfo) {
+			return nil, errors.New("related model doesn't have primary key value")
+		}
+		k, err := getModelPkKeys(child)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
 }
 
-func (ins *inserter) syncHasOneRelation(ctx context.Context, db *sql.DB, field modelField) error {
+// Returns columns of mapping table referring related models in order of
+// their primary fields, ref setting of the relation overrides them
+func getRelationRefColumns(field modelField) ([]string, error) {
+	related, err := getModelInfo(field.value)
+	if err != nil {
+		return nil, err
+	}
+	var columns []string
+	for _, f := range related.fields {
+		if isPkField(f) {
+			columns = append(columns, f.reference.column)
+		}
+	}
+	if field.reference.ref != "" {
+		if len(columns) != 1 {
+			return nil, errors.New("ref setting of many to many relation requires related model with single primary key")
+		}
+		return []string{field.reference.ref}, nil
+	}
+	return columns, nil
+}
+
+func (ins *inserter) syncHasOneRelation(ctx context.Context, db Executor, field modelField) error {
 	if !field.value.IsValid() || field.value.IsNil() {
 		return nil
 	}
@@ -312,14 +729,15 @@ func (ins *inserter) syncHasOneRelation(ctx context.Context, db *sql.DB, field m
 	if err != nil {
 		return errors.Wrap(err, "can't sync has one relation")
 	}
-	// don't insert related model if it already exists
-	if !pkIsNull(info) {
+	// don't insert related model if it already exists or it's not allowed,
+	// so key of unsaved model is written as NULL
+	if !pkIsNull(info) || field.reference.noCreate {
 		return nil
 	}
 	return ins.insert(ctx, db, field.value.Interface().(IModel))
 }
 
-func (ins *inserter) syncHasManyRelation(ctx context.Context, db *sql.DB, field modelField, model *modelInfo) error {
+func (ins *inserter) syncHasManyRelation(ctx context.Context, db Executor, field modelField, model *modelInfo) error {
 	if !field.value.IsValid() || field.value.IsNil() {
 		return nil
 	}
@@ -343,26 +761,47 @@ items:
 			}
 		}
 
-		if err := ins.insert(ctx, db, ri.value.Addr().Interface().(IModel)); err != nil {
+		// conflict target and partial columns name columns of the parent,
+		// children are upserted by their own keys
+		child := *ins
+		child.target, child.partialColumns = nil, nil
+		if err := child.insert(ctx, db, ri.value.Addr().Interface().(IModel)); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func insert(ctx context.Context, db *sql.DB, m IModel, update bool) error {
+func insert(ctx context.Context, db Executor, m IModel, update bool) error {
 	i := &inserter{updateConflict: update}
 	return i.insert(ctx, db, m)
 }
 
-func (ins *inserter) insert(ctx context.Context, db *sql.DB, m IModel) error {
+func (ins *inserter) insert(ctx context.Context, db Executor, m IModel) error {
 	mInfo, err := getModelInfo(m)
 	if err != nil {
 		return err
 	}
 
+	// target is checked before anything is written
+	for _, c := range ins.target {
+		if !isStoredColumn(mInfo, c) {
+			return errors.Errorf("conflict target %s is not a column of model %s", c, mInfo.value.Type().Name())
+		}
+	}
+
+	if pkIsNull(mInfo) {
+		if err := applyDefaults(mInfo); err != nil {
+			return err
+		}
+	}
+
+	if err := ins.checkRequiredFields(ctx, db, mInfo); err != nil {
+		return err
+	}
+
 	for _, field := range mInfo.fields {
-		if isHasOne(field) {
+		if isHasOne(field) && !ins.shallow {
 			if err := new(inserter).syncHasOneRelation(ctx, db, field); err != nil {
 				return err
 			}
@@ -372,46 +811,155 @@ func (ins *inserter) insert(ctx context.Context, db *sql.DB, m IModel) error {
 	q, a := ins.buildUpsertQuery(mInfo)
 	if len(a) > 0 {
 		// we need to perform update query only for models that have fields
-		result, err := db.ExecContext(ctx, q, a...)
-		if err != nil {
-			return &Error{err, q, a}
+		if !pkIsNull(mInfo) {
+			// primary key provided by the caller is kept as is, so there is
+			// nothing to resolve
+			if _, err := execContext(ctx, db, q, a...); err != nil {
+				return &Error{err, q, a}
+			}
+			goto Relations
 		}
 
-		id, err := result.LastInsertId()
+		var target []string
+		_, keys, _ := getModelColumns(mInfo.fields)
+		if ins.updateConflict || ins.ignoreConflict {
+			target = ins.conflictColumns(mInfo, keys)
+		}
+		// upsert may run several statements before the key of conflicting row
+		// is read, so they are run in a transaction to get the key of the row
+		// they have written
+		var id int64
+		err := withTransaction(ctx, db, func(db Executor) (err error) {
+			id, err = ins.upsertPk(ctx, db, mInfo, target, keys, q, a)
+			return err
+		})
 		if err != nil {
 			return err
 		}
 
-		if id == 0 && pkIsNull(mInfo) {
-			// model was upserted, so we need to know it's id
-			q, a := buildSearchQuery(mInfo)
-			rows, err := db.QueryContext(ctx, q, a...)
-			if err != nil {
-				return &Error{err, q, a}
-			}
-			for rows.Next() {
-				if err := rows.Scan(&id); err != nil {
-					return err
-				}
-			}
-		}
-
 		if err := setModelPk(mInfo, id); err != nil {
 			return err
 		}
 	}
 
+Relations:
+	if ins.shallow {
+		return nil
+	}
 	return ins.syncRelations(ctx, db, mInfo)
 }
 
-func (ins *inserter) update(ctx context.Context, db *sql.DB, m Model, deep bool) error {
+// Executes upsert query returning primary key of inserted or conflicting row
+func (ins *inserter) upsertPk(ctx context.Context, db Executor, info *modelInfo, target, keys []string, q string, a []interface{}) (int64, error) {
+	id, inserted, err := ins.execUpsert(ctx, db, info, target, q, a)
+	if err != nil {
+		return 0, err
+	}
+	if inserted && id != 0 {
+		return id, nil
+	}
+	// conflicting row is looked up by conflict target, stored row may differ
+	// from the model, so only unique columns are matched unless model doesn't
+	// have any
+	if len(target) == 0 {
+		target = keys
+	}
+	q, a = buildSearchQuery(info, target)
+	rows, err := queryContext(ctx, db, q, a...)
+	if err != nil {
+		return 0, &Error{err, q, a}
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := rows.Scan(&id); err != nil {
+			return 0, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, &Error{err, q, a}
+	}
+	return id, nil
+}
+
+// Executes upsert query returning last inserted id and whether the row was
+// inserted. Last inserted id is not changed when conflicting row is updated,
+// so upsert having conflict target inserts the row skipping conflict first
+// and runs the query only if the row exists, it costs an extra statement for
+// every conflicting row
+func (ins *inserter) execUpsert(ctx context.Context, db Executor, info *modelInfo, target []string, q string, a []interface{}) (int64, bool, error) {
+	if ins.updateConflict && len(target) != 0 {
+		ignore := *ins
+		ignore.updateConflict, ignore.ignoreConflict = false, true
+		iq, ia := ignore.buildUpsertQuery(info)
+		id, inserted, err := ignore.execUpsert(ctx, db, info, target, iq, ia)
+		if err != nil || inserted || iq == q {
+			return id, inserted, err
+		}
+		if _, err := execContext(ctx, db, q, a...); err != nil {
+			return 0, false, &Error{err, q, a}
+		}
+		return 0, false, nil
+	}
+
+	result, err := execContext(ctx, db, q, a...)
+	if err != nil {
+		return 0, false, &Error{err, q, a}
+	}
+	if ins.ignoreConflict && len(target) != 0 {
+		affected, err := result.RowsAffected()
+		if err != nil || affected == 0 {
+			return 0, false, err
+		}
+	}
+	id, err := result.LastInsertId()
+	return id, err == nil, err
+}
+
+// Checks that fields tagged as notnull have values, partial upsert of
+// existing row requires only updated ones, but every column is inserted if
+// there is no conflicting row
+func (ins *inserter) checkRequiredFields(ctx context.Context, db Executor, info *modelInfo) error {
+	only := ins.updatedColumns(info)
+	if err := checkRequiredFields(info, only); err != nil || only == nil {
+		return err
+	}
+	err := checkRequiredFields(info, nil)
+	if err == nil {
+		return nil
+	}
+	_, keys, _ := getModelColumns(info.fields)
+	target := ins.conflictColumns(info, keys)
+	if len(target) == 0 {
+		return err
+	}
+	var id interface{}
+	q, a := buildSearchQuery(info, target)
+	switch scanErr := queryRowScan(ctx, db, q, a, &id); scanErr {
+	case nil:
+		return nil
+	case sql.ErrNoRows:
+		return err
+	default:
+		return &Error{scanErr, q, a}
+	}
+}
+
+func (ins *inserter) update(ctx context.Context, db Executor, m Model, deep bool) error {
 	mInfo, err := getModelInfo(m)
 	if err != nil {
 		return err
 	}
 
-	q, a := buildUpdateQuery(mInfo)
-	res, err := db.ExecContext(ctx, q, a...)
+	return ins.updateColumns(ctx, db, mInfo, nil, deep)
+}
+
+func (ins *inserter) updateColumns(ctx context.Context, db Executor, mInfo *modelInfo, only map[string]struct{}, deep bool) error {
+	if err := checkRequiredFields(mInfo, only); err != nil {
+		return err
+	}
+
+	q, a := buildUpdateQuery(mInfo, only)
+	res, err := execContext(ctx, db, q, a...)
 	if err != nil {
 		return &Error{err, q, a}
 	}
@@ -431,17 +979,17 @@ func (ins *inserter) update(ctx context.Context, db *sql.DB, m Model, deep bool)
 }
 
 // UpdateContext updates model by it's primary keys
-func UpdateContext(ctx context.Context, db *sql.DB, m Model, deep bool) error {
+func UpdateContext(ctx context.Context, db Executor, m Model, deep bool) error {
 	return new(inserter).update(ctx, db, m, deep)
 }
 
 // Update updates model by it's primary keys with background context
-func Update(db *sql.DB, m Model) error {
+func Update(db Executor, m Model) error {
 	return UpdateContext(context.Background(), db, m, false)
 }
 
 // UpdateDeep is the same as Update but also updates model's relations
-func UpdateDeep(db *sql.DB, m Model) error {
+func UpdateDeep(db Executor, m Model) error {
 	return UpdateContext(context.Background(), db, m, true)
 }
 
@@ -458,6 +1006,27 @@ func IsNotFound(err error) bool {
 	return err == ErrNoRowsAffected
 }
 
+// IsTimeout checks if error was caused by cancelled or expired context rather
+// than by the query itself
+func IsTimeout(err error) bool {
+	if e, ok := err.(*Error); ok {
+		err = e.SQLError
+	}
+	err = errors.Cause(err)
+	if stderrors.Is(err, context.DeadlineExceeded) || stderrors.Is(err, context.Canceled) {
+		return true
+	}
+	if inner, ok := err.(sqlite3.Error); ok {
+		return inner.Code == sqlite3.ErrInterrupt
+	}
+	return false
+}
+
+// IsFieldRequired checks if error was caused by missing value of a notnull field
+func IsFieldRequired(err error) bool {
+	return errors.Cause(err) == ErrFieldRequired
+}
+
 func IsFKError(err error) bool {
 	if e, ok := err.(*Error); ok {
 		if inner, ok := e.SQLError.(sqlite3.Error); ok {
@@ -475,3 +1044,70 @@ func IsNotNullError(err error) bool {
 	}
 	return false
 }
+
+// ConstraintKind describes kind of violated constraint
+type ConstraintKind string
+
+const (
+	ConstraintUnique     ConstraintKind = "unique"
+	ConstraintNotNull    ConstraintKind = "not null"
+	ConstraintForeignKey ConstraintKind = "foreign key"
+	ConstraintCheck      ConstraintKind = "check"
+	ConstraintPrimaryKey ConstraintKind = "primary key"
+)
+
+// ConstraintError describes which constraint was violated by the query, Table and
+// Column are empty if database didn't report them (e.g. for foreign keys)
+type ConstraintError struct {
+	Kind    ConstraintKind
+	Table   string
+	Column  string
+	Columns []string
+	err     *Error
+}
+
+// Error implements error interface
+func (e *ConstraintError) Error() string { return e.err.Error() }
+
+// AsConstraintError extracts information about violated constraint from the error
+func AsConstraintError(err error) (*ConstraintError, bool) {
+	e, ok := err.(*Error)
+	if !ok {
+		return nil, false
+	}
+	inner, ok := e.SQLError.(sqlite3.Error)
+	if !ok || inner.Code != sqlite3.ErrConstraint {
+		return nil, false
+	}
+	var ce = ConstraintError{err: e}
+	switch inner.ExtendedCode {
+	case sqlite3.ErrConstraintUnique:
+		ce.Kind = ConstraintUnique
+	case sqlite3.ErrConstraintNotNull:
+		ce.Kind = ConstraintNotNull
+	case sqlite3.ErrConstraintForeignKey:
+		ce.Kind = ConstraintForeignKey
+	case sqlite3.ErrConstraintCheck:
+		ce.Kind = ConstraintCheck
+	case sqlite3.ErrConstraintPrimaryKey:
+		ce.Kind = ConstraintPrimaryKey
+	default:
+		return nil, false
+	}
+	// message looks like "UNIQUE constraint failed: table.a, table.b"
+	msg := inner.Error()
+	if i := strings.Index(msg, "constraint failed: "); i != -1 {
+		for _, target := range strings.Split(msg[i+len("constraint failed: "):], ", ") {
+			if parts := strings.SplitN(target, ".", 2); len(parts) == 2 {
+				ce.Table = parts[0]
+				ce.Columns = append(ce.Columns, parts[1])
+			} else {
+				ce.Columns = append(ce.Columns, target)
+			}
+		}
+		if len(ce.Columns) != 0 {
+			ce.Column = ce.Columns[0]
+		}
+	}
+	return &ce, true
+}
@@ -8,7 +8,9 @@ import (
 	"github.com/stretchr/testify/suite"
 	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 type baseModelFixture struct {
@@ -183,6 +185,120 @@ func TestAutoCreate(t *testing.T) {
 	suite.Run(t, new(autoCreateRelatedFixture))
 }
 
+type noCreateRelatedModel struct {
+	ID            int64 `ormlite:"primary"`
+	Name          string
+	RelatedHasOne *baseModel `ormlite:"has_one,col=related_to,nocreate"`
+}
+
+func (*noCreateRelatedModel) Table() string { return "main_model" }
+
+func TestHasOneNoCreate(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(new(autoCreateRelatedFixture).Query())
+	require.NoError(t, err)
+
+	m := noCreateRelatedModel{Name: "optional", RelatedHasOne: &baseModel{Field: "unsaved"}}
+	require.NoError(t, Upsert(db, &m))
+	assert.NotZero(t, m.ID)
+	assert.Zero(t, m.RelatedHasOne.ID)
+
+	var count int
+	require.NoError(t, db.QueryRow("select count(*) from base_model").Scan(&count))
+	assert.Zero(t, count, "related model shouldn't be created")
+
+	var related sql.NullInt64
+	require.NoError(t, db.QueryRow("select related_to from main_model where id = ?", m.ID).Scan(&related))
+	assert.False(t, related.Valid, "key of unsaved related model should be NULL")
+
+	// saved related models are still referenced
+	_, err = db.Exec("insert into base_model(id, field) values (3, 'saved')")
+	require.NoError(t, err)
+	m.RelatedHasOne = &baseModel{ID: 3}
+	require.NoError(t, Upsert(db, &m))
+	require.NoError(t, db.QueryRow("select related_to from main_model where id = ?", m.ID).Scan(&related))
+	assert.EqualValues(t, 3, related.Int64)
+}
+
+type compoundForeignWithCode struct {
+	FirstID int64           `ormlite:"primary,col=first_id,ref=first_id"`
+	Related *relatedModelFK `ormlite:"primary,col=second_id,ref=second_id,has_one"`
+	Code    string          `ormlite:"unique=code"`
+	Name    string
+}
+
+func (*compoundForeignWithCode) Table() string { return "complex_model" }
+
+func TestUpsertCompoundKeyWithHasOne(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(`
+		create table related_model(id integer primary key, field text);
+		insert into related_model(field) values ('1'), ('2');
+		create table complex_model(
+			first_id integer, second_id integer, code text unique, name text, primary key(first_id, second_id));
+	`)
+	require.NoError(t, err)
+
+	countRows := func() (count int) {
+		require.NoError(t, db.QueryRow("select count(*) from complex_model").Scan(&count))
+		return
+	}
+
+	require.NoError(t, Upsert(db, &modelWithCompoundWithForeign{FirstID: 1, Related: &relatedModelFK{ID: 2}, Name: "a"}))
+	require.NoError(t, Upsert(db, &modelWithCompoundWithForeign{FirstID: 1, Related: &relatedModelFK{ID: 2}, Name: "b"}))
+	assert.Equal(t, 1, countRows())
+
+	// named unique groups make primary key the conflict target, has one part included
+	m := &compoundForeignWithCode{FirstID: 1, Related: &relatedModelFK{ID: 2}, Code: "x", Name: "c"}
+	require.NoError(t, Upsert(db, m))
+	assert.Equal(t, 1, countRows())
+
+	var name, code string
+	require.NoError(t, db.QueryRow("select name, code from complex_model where first_id = 1 and second_id = 2").Scan(&name, &code))
+	assert.Equal(t, "c", name)
+	assert.Equal(t, "x", code)
+
+	require.NoError(t, Upsert(db, &compoundForeignWithCode{FirstID: 1, Related: &relatedModelFK{ID: 1}, Code: "y"}))
+	assert.Equal(t, 2, countRows())
+
+	m.Name = "d"
+	require.NoError(t, Update(db, m))
+	require.NoError(t, db.QueryRow("select name from complex_model where first_id = 1 and second_id = 2").Scan(&name))
+	assert.Equal(t, "d", name)
+}
+
+func TestInsertShallow(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(new(autoCreateRelatedFixture).Query())
+	require.NoError(t, err)
+
+	m := autoCreateRelatedModel{
+		Name:              "shallow",
+		RelatedHasOne:     &baseModel{ID: 7},
+		RelatedHasMany:    []*autoCreateRelatedHasManyModel{{}},
+		RelatedManyToMany: []*autoCreateRelatedManyToManyModel{{ID: 1}, {Field: "new"}},
+	}
+	require.NoError(t, InsertShallow(db, &m))
+	assert.NotZero(t, m.ID)
+
+	for _, table := range []string{"base_model", "has_many_model", "many_to_many_model", "mapping_table"} {
+		var count int
+		require.NoError(t, db.QueryRow("select count(*) from "+table).Scan(&count))
+		assert.Zero(t, count, "shallow insert shouldn't touch %s", table)
+	}
+
+	var related int64
+	require.NoError(t, db.QueryRow("select related_to from main_model where id = ?", m.ID).Scan(&related))
+	assert.EqualValues(t, 7, related, "has one key should be written")
+}
+
 type uniqueFieldFixture struct {
 	suite.Suite
 	db *sql.DB
@@ -229,6 +345,194 @@ func TestUniqueField(t *testing.T) {
 	suite.Run(t, new(uniqueFieldFixture))
 }
 
+type modelWithUniqueGroups struct {
+	ID     int64  `ormlite:"primary"`
+	Email  string `ormlite:"unique=email"`
+	Handle string `ormlite:"unique=handle"`
+	Name   string
+}
+
+func (*modelWithUniqueGroups) Table() string { return "unique_groups" }
+
+func TestUpsertUniqueGroups(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(`
+		create table unique_groups(id integer primary key, email text unique, handle text unique, name text);
+		insert into unique_groups(email, handle, name) values ('a@test', 'a', 'first'), ('b@test', 'b', 'second');
+	`)
+	require.NoError(t, err)
+
+	m := modelWithUniqueGroups{Email: "b@test", Handle: "bee", Name: "updated"}
+	require.NoError(t, Upsert(db, &m))
+	assert.EqualValues(t, 2, m.ID, "row conflicting by email should be updated")
+
+	var stored modelWithUniqueGroups
+	require.NoError(t, QueryStruct(db, WithWhere(DefaultOptions(), Where{"id": 2}), &stored))
+	assert.Equal(t, "bee", stored.Handle)
+	assert.Equal(t, "updated", stored.Name)
+
+	m = modelWithUniqueGroups{Email: "new@test", Handle: "a", Name: "by handle"}
+	require.NoError(t, UpsertOn(db, &m, "handle"))
+	assert.EqualValues(t, 1, m.ID, "row conflicting by handle should be updated")
+
+	count, err := Count(db, &modelWithUniqueGroups{}, nil)
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, count)
+}
+
+type modelWithUniqueFields struct {
+	ID     int64  `ormlite:"primary"`
+	Email  string `ormlite:"unique"`
+	Handle string `ormlite:"unique"`
+	Name   string
+}
+
+func (*modelWithUniqueFields) Table() string { return "unique_groups" }
+
+func TestUpsertOnSingleColumn(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(`
+		create table unique_groups(id integer primary key, email text unique, handle text unique, name text);
+		insert into unique_groups(email, handle, name) values ('a@test', 'a', 'first'), ('b@test', 'b', 'second');
+	`)
+	require.NoError(t, err)
+
+	// unnamed unique fields form a single target, which doesn't match any constraint
+	m := modelWithUniqueFields{Email: "b@test", Handle: "bee", Name: "updated"}
+	assert.Error(t, Upsert(db, &m))
+
+	m = modelWithUniqueFields{Email: "b@test", Handle: "bee", Name: "updated"}
+	require.NoError(t, UpsertOn(db, &m, "email"))
+	assert.EqualValues(t, 2, m.ID)
+
+	var stored modelWithUniqueFields
+	require.NoError(t, QueryStruct(db, &Options{Where: Where{"id": 2}}, &stored))
+	assert.Equal(t, modelWithUniqueFields{ID: 2, Email: "b@test", Handle: "bee", Name: "updated"}, stored)
+
+	count, err := Count(db, &modelWithUniqueFields{}, nil)
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, count)
+
+	assert.Error(t, UpsertOn(db, &modelWithUniqueFields{Email: "c@test"}, "mail"))
+}
+
+type upsertParent struct {
+	ID       int64          `ormlite:"primary"`
+	Email    string         `ormlite:"unique"`
+	Children []*upsertChild `ormlite:"has_many"`
+}
+
+func (*upsertParent) Table() string { return "upsert_parents" }
+
+type upsertChild struct {
+	ID     int64 `ormlite:"primary"`
+	Name   string
+	Parent *upsertParent `ormlite:"has_one,col=parent_id"`
+}
+
+func (*upsertChild) Table() string { return "upsert_children" }
+
+func TestUpsertOnWithRelations(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(`
+		create table upsert_parents(id integer primary key, email text unique);
+		create table upsert_children(id integer primary key, name text, parent_id int);
+	`)
+	require.NoError(t, err)
+
+	count := func(m Model) int64 {
+		n, err := Count(db, m, nil)
+		require.NoError(t, err)
+		return n
+	}
+
+	// target of the parent isn't applied to its children
+	p := upsertParent{Email: "a", Children: []*upsertChild{{Name: "first"}, {Name: "second"}}}
+	require.NoError(t, UpsertOn(db, &p, "email"))
+	assert.EqualValues(t, 2, count(&upsertChild{}))
+
+	// invalid target fails before related models are written
+	c := upsertChild{Name: "third", Parent: &upsertParent{Email: "b"}}
+	assert.Error(t, UpsertOn(db, &c, "email"))
+	assert.EqualValues(t, 1, count(&upsertParent{}))
+	assert.EqualValues(t, 2, count(&upsertChild{}))
+}
+
+func TestUpsertIgnore(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(`
+		create table unique_groups(id integer primary key, email text unique, handle text unique, name text);
+		insert into unique_groups(email, handle, name) values ('a@test', 'a', 'first'), ('b@test', 'b', 'second');
+	`)
+	require.NoError(t, err)
+
+	m := modelWithUniqueGroups{Email: "b@test", Handle: "b", Name: "ignored"}
+	require.NoError(t, UpsertIgnore(db, &m, "email"))
+	assert.EqualValues(t, 2, m.ID, "primary key of existing row should be set")
+
+	var stored modelWithUniqueGroups
+	require.NoError(t, QueryStruct(db, WithWhere(DefaultOptions(), Where{"id": 2}), &stored))
+	assert.Equal(t, "second", stored.Name, "existing row should be untouched")
+
+	m = modelWithUniqueGroups{Email: "c@test", Handle: "c", Name: "third"}
+	require.NoError(t, UpsertIgnore(db, &m))
+	assert.EqualValues(t, 3, m.ID)
+}
+
+func TestUpsertPartial(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(`
+		create table unique_groups(id integer primary key, email text unique, handle text unique, name text);
+		insert into unique_groups(email, handle, name) values ('a@test', 'a', 'first'), ('b@test', 'b', 'second');
+	`)
+	require.NoError(t, err)
+
+	m := modelWithUniqueGroups{Email: "a@test", Name: "renamed"}
+	require.NoError(t, UpsertPartial(db, &m))
+	assert.EqualValues(t, 1, m.ID)
+
+	var stored modelWithUniqueGroups
+	require.NoError(t, QueryStruct(db, WithWhere(DefaultOptions(), Where{"id": 1}), &stored))
+	assert.Equal(t, modelWithUniqueGroups{ID: 1, Email: "a@test", Handle: "a", Name: "renamed"}, stored)
+
+	m = modelWithUniqueGroups{Email: "b@test", Handle: "bee", Name: "ignored"}
+	require.NoError(t, UpsertPartial(db, &m, "handle"))
+	require.NoError(t, QueryStruct(db, WithWhere(DefaultOptions(), Where{"id": 2}), &stored))
+	assert.Equal(t, modelWithUniqueGroups{ID: 2, Email: "b@test", Handle: "bee", Name: "second"}, stored)
+
+	// notnull fields which aren't updated are required only if the row is
+	// inserted
+	_, err = db.Exec(`create table required_partial(id integer primary key, name text not null, note text not null)`)
+	require.NoError(t, err)
+	assert.True(t, IsFieldRequired(UpsertPartial(db, &requiredPartialModel{ID: 1, Name: "x"}, "name")))
+	require.NoError(t, UpsertPartial(db, &requiredPartialModel{ID: 1, Name: "x", Note: "first"}, "name"))
+	require.NoError(t, UpsertPartial(db, &requiredPartialModel{ID: 1, Name: "y"}, "name"))
+	assert.True(t, IsFieldRequired(UpsertPartial(db, &requiredPartialModel{ID: 1, Note: "second"}, "name")))
+
+	var rp requiredPartialModel
+	require.NoError(t, QueryStruct(db, &Options{Where: Where{"id": 1}}, &rp))
+	assert.Equal(t, requiredPartialModel{ID: 1, Name: "y", Note: "first"}, rp)
+}
+
+type requiredPartialModel struct {
+	ID   int64  `ormlite:"primary"`
+	Name string `ormlite:"notnull"`
+	Note string `ormlite:"notnull"`
+}
+
+func (*requiredPartialModel) Table() string { return "required_partial" }
+
 type skipUpdatingExistingRelatedModels struct {
 	suite.Suite
 	db *sql.DB
@@ -351,3 +655,597 @@ func TestNotNullErrorCheck(t *testing.T) {
 	}
 
 }
+
+type modelWithDefaults struct {
+	ID     int64  `ormlite:"primary"`
+	Status string `ormlite:"default=active"`
+	Level  int    `ormlite:"default=3"`
+	Kind   string `ormlite:"default"`
+}
+
+func (*modelWithDefaults) Table() string { return "defaults" }
+
+func TestInsertDefaults(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	_, err = db.Exec(`create table defaults(id integer primary key, status text, level int, kind text not null default 'db')`)
+	require.NoError(t, err)
+
+	m := modelWithDefaults{}
+	require.NoError(t, Insert(db, &m))
+	assert.Equal(t, "active", m.Status)
+	assert.Equal(t, 3, m.Level)
+
+	var stored modelWithDefaults
+	require.NoError(t, QueryStruct(db, &Options{Where: Where{"id": m.ID}}, &stored))
+	assert.Equal(t, modelWithDefaults{ID: m.ID, Status: "active", Level: 3, Kind: "db"}, stored)
+
+	m1 := modelWithDefaults{Status: "blocked", Level: 1, Kind: "user"}
+	require.NoError(t, Insert(db, &m1))
+	var stored1 modelWithDefaults
+	require.NoError(t, QueryStruct(db, &Options{Where: Where{"id": m1.ID}}, &stored1))
+	assert.Equal(t, m1, stored1)
+
+	assert.Error(t, Insert(db, &modelWithAmbiguousDefault{}))
+}
+
+type modelWithAmbiguousDefault struct {
+	ID   int64  `ormlite:"primary"`
+	Kind string `ormlite:"default=default"`
+}
+
+func (*modelWithAmbiguousDefault) Table() string { return "defaults" }
+
+type modelWithRequiredField struct {
+	ID   int64  `ormlite:"primary"`
+	Name string `ormlite:"notnull"`
+}
+
+func (*modelWithRequiredField) Table() string { return "required" }
+
+func TestRequiredFields(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	_, err = db.Exec(`create table required(id integer primary key, name text not null)`)
+	require.NoError(t, err)
+
+	err = Insert(db, &modelWithRequiredField{})
+	if assert.Error(t, err) {
+		assert.True(t, IsFieldRequired(err))
+		assert.False(t, IsNotNullError(err))
+		assert.Contains(t, err.Error(), "name")
+	}
+
+	m := modelWithRequiredField{Name: "test"}
+	require.NoError(t, Insert(db, &m))
+
+	m.Name = ""
+	assert.True(t, IsFieldRequired(Update(db, &m)))
+}
+
+func TestConstraintError(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	_, err = db.Exec(`
+		create table test_unique(id integer primary key, field text unique);
+		insert into test_unique(field) values ('test');
+	`)
+	require.NoError(t, err)
+
+	err = Insert(db, &modelWithUniqueField{Field: "test"})
+	ce, ok := AsConstraintError(err)
+	if assert.True(t, ok) {
+		assert.Equal(t, ConstraintUnique, ce.Kind)
+		assert.Equal(t, "test_unique", ce.Table)
+		assert.Equal(t, "field", ce.Column)
+	}
+
+	_, ok = AsConstraintError(ErrNoRowsAffected)
+	assert.False(t, ok)
+}
+
+func TestIsTimeout(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	_, err = db.Exec(`create table simple_model(id integer primary key, not_tagged_field text, tagged_field text)`)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+
+	var mm []*simpleModel
+	err = QuerySliceContext(ctx, db, nil, &mm)
+	if assert.Error(t, err) {
+		assert.True(t, IsTimeout(err))
+	}
+
+	err = QuerySlice(db, &Options{Where: Where{"missing_column": 1}}, &mm)
+	if assert.Error(t, err) {
+		assert.False(t, IsTimeout(err))
+	}
+}
+
+type modelWithNullFields struct {
+	ID       int64 `ormlite:"primary"`
+	Nickname sql.NullString
+	Age      sql.NullInt64
+	Email    sql.NullString `ormlite:"notnull"`
+}
+
+func (*modelWithNullFields) Table() string { return "nullable" }
+
+func TestNullFields(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(`create table nullable(id integer primary key, nickname text, age int, email text)`)
+	require.NoError(t, err)
+
+	m := modelWithNullFields{
+		Nickname: sql.NullString{String: "ignored", Valid: false},
+		Age:      sql.NullInt64{Int64: 30, Valid: true},
+		Email:    sql.NullString{String: "a@test", Valid: true},
+	}
+	require.NoError(t, Insert(db, &m))
+
+	var nicknameIsNull bool
+	require.NoError(t, db.QueryRow("select nickname is null from nullable where id = ?", m.ID).Scan(&nicknameIsNull))
+	assert.True(t, nicknameIsNull, "invalid NullString should be written as NULL")
+
+	var stored modelWithNullFields
+	require.NoError(t, QueryStruct(db, &Options{Where: Where{"id": m.ID}}, &stored))
+	assert.False(t, stored.Nickname.Valid)
+	assert.Equal(t, sql.NullInt64{Int64: 30, Valid: true}, stored.Age)
+	assert.Equal(t, sql.NullString{String: "a@test", Valid: true}, stored.Email)
+
+	m = modelWithNullFields{Email: sql.NullString{String: "set but invalid"}}
+	assert.True(t, IsFieldRequired(Insert(db, &m)), "invalid NullString should be treated as empty")
+}
+
+type batchTag struct {
+	ID int64 `ormlite:"primary,ref=tag_id"`
+}
+
+func (*batchTag) Table() string { return "tags" }
+
+type batchPost struct {
+	ID   int64       `ormlite:"primary,ref=post_id"`
+	Tags []*batchTag `ormlite:"many_to_many,table=post_tags"`
+}
+
+func (*batchPost) Table() string { return "posts" }
+
+func TestManyToManyBatchSync(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(`
+		create table tags(id integer primary key);
+		create table posts(id integer primary key);
+		create table post_tags(post_id int, tag_id int check (tag_id <= 100));
+		insert into posts(id) values (1);
+	`)
+	require.NoError(t, err)
+	for i := 1; i <= 101; i++ {
+		_, err = db.Exec("insert into tags(id) values (?)", i)
+		require.NoError(t, err)
+	}
+
+	tags := func(from, to int) []*batchTag {
+		var tt []*batchTag
+		for i := from; i <= to; i++ {
+			tt = append(tt, &batchTag{ID: int64(i)})
+		}
+		return tt
+	}
+	stored := func() []int64 {
+		var ids []int64
+		rows, err := db.Query("select tag_id from post_tags where post_id = 1 order by tag_id")
+		require.NoError(t, err)
+		defer rows.Close()
+		for rows.Next() {
+			var id int64
+			require.NoError(t, rows.Scan(&id))
+			ids = append(ids, id)
+		}
+		return ids
+	}
+	mappingQueries := func(rec *queryRecorder) (inserts, deletes int) {
+		for _, q := range rec.queries {
+			switch {
+			case strings.HasPrefix(q, "insert into post_tags"):
+				inserts++
+			case strings.HasPrefix(q, "delete from post_tags"):
+				deletes++
+			}
+		}
+		return
+	}
+
+	rec := &queryRecorder{Executor: db}
+	require.NoError(t, Upsert(rec, &batchPost{ID: 1, Tags: tags(1, 40)}))
+	inserts, deletes := mappingQueries(rec)
+	assert.Equal(t, 1, inserts)
+	assert.Equal(t, 0, deletes)
+	assert.Len(t, stored(), 40)
+
+	rec = &queryRecorder{Executor: db}
+	require.NoError(t, Upsert(rec, &batchPost{ID: 1, Tags: tags(21, 60)}))
+	inserts, deletes = mappingQueries(rec)
+	assert.Equal(t, 1, inserts)
+	assert.Equal(t, 1, deletes)
+	ids := stored()
+	if assert.Len(t, ids, 40) {
+		assert.EqualValues(t, 21, ids[0])
+		assert.EqualValues(t, 60, ids[39])
+	}
+
+	// failed insert rolls back removal of stale relations
+	assert.Error(t, Upsert(db, &batchPost{ID: 1, Tags: tags(61, 101)}))
+	assert.Equal(t, ids, stored())
+}
+
+type edgeTag struct {
+	ID int `ormlite:"primary,ref=tag_id"`
+}
+
+func (*edgeTag) Table() string { return "tags" }
+
+type edgePost struct {
+	ID   int        `ormlite:"primary,ref=post_id"`
+	Tags []*edgeTag `ormlite:"many_to_many,table=post_tags,condition:kind=1"`
+}
+
+func (*edgePost) Table() string { return "posts" }
+
+type idempotentEdgePost struct {
+	ID   int        `ormlite:"primary,ref=post_id"`
+	Tags []*edgeTag `ormlite:"many_to_many,table=post_tags,condition:kind=1,ignore"`
+}
+
+func (*idempotentEdgePost) Table() string { return "posts" }
+
+func TestManyToManyExistingEdges(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(`
+		create table tags(id integer primary key);
+		create table posts(id integer primary key);
+		create table post_tags(post_id int, tag_id int, kind int, unique(post_id, tag_id));
+		insert into tags(id) values (1), (2), (3);
+		insert into posts(id) values (1), (2);
+		insert into post_tags(post_id, tag_id, kind) values (1, 1, 1), (2, 1, 2);
+	`)
+	require.NoError(t, err)
+
+	// stored edge is recognized despite different key types, so it's neither
+	// removed nor inserted again
+	rec := &queryRecorder{Executor: db}
+	require.NoError(t, Upsert(rec, &edgePost{ID: 1, Tags: []*edgeTag{{ID: 1}, {ID: 2}}}))
+	for _, q := range rec.queries {
+		assert.False(t, strings.HasPrefix(q, "delete from post_tags"), "unexpected query: %s", q)
+	}
+	var count int
+	require.NoError(t, db.QueryRow("select count(*) from post_tags where post_id = 1 and kind = 1").Scan(&count))
+	assert.Equal(t, 2, count)
+
+	// edge stored with other condition value conflicts with the inserted one
+	assert.Error(t, Upsert(db, &edgePost{ID: 2, Tags: []*edgeTag{{ID: 1}}}))
+	require.NoError(t, Upsert(db, &idempotentEdgePost{ID: 2, Tags: []*edgeTag{{ID: 1}, {ID: 3}}}))
+	require.NoError(t, db.QueryRow("select count(*) from post_tags where post_id = 2").Scan(&count))
+	assert.Equal(t, 2, count)
+}
+
+func TestUpsertResolvesConflictingRow(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	// last inserted id is kept per connection
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(`
+		create table unique_groups(id integer primary key, email text unique, handle text unique, name text);
+		insert into unique_groups(email, handle, name) values ('a@test', 'a', 'first'), ('b@test', 'b', 'second');
+	`)
+	require.NoError(t, err)
+
+	searches := func(rec *queryRecorder) (n int) {
+		for _, q := range rec.queries {
+			if strings.HasPrefix(q, "select") {
+				n++
+			}
+		}
+		return n
+	}
+
+	// inserted rows keep last inserted id without searching it
+	rec := &queryRecorder{Executor: db}
+	fresh := modelWithUniqueGroups{Email: "c@test", Handle: "c", Name: "third"}
+	require.NoError(t, Upsert(rec, &fresh))
+	assert.EqualValues(t, 3, fresh.ID)
+	fresh = modelWithUniqueGroups{Email: "d@test", Handle: "d", Name: "fourth"}
+	require.NoError(t, UpsertIgnore(rec, &fresh))
+	assert.EqualValues(t, 4, fresh.ID)
+	assert.Zero(t, searches(rec))
+
+	// every column except email differs from the stored row
+	m := modelWithUniqueGroups{Email: "a@test", Handle: "aa", Name: "changed"}
+	require.NoError(t, Upsert(rec, &m))
+	assert.EqualValues(t, 1, m.ID)
+	assert.Equal(t, 1, searches(rec))
+
+	m = modelWithUniqueGroups{Email: "b@test", Handle: "bb", Name: "ignored"}
+	require.NoError(t, UpsertIgnore(db, &m))
+	assert.EqualValues(t, 2, m.ID)
+
+	m = modelWithUniqueGroups{Email: "b@test", Handle: "bbb", Name: "partial"}
+	require.NoError(t, UpsertPartial(db, &m, "name"))
+	assert.EqualValues(t, 2, m.ID)
+
+	var stored modelWithUniqueGroups
+	require.NoError(t, QueryStruct(db, &Options{Where: Where{"id": 2}}, &stored))
+	assert.Equal(t, modelWithUniqueGroups{ID: 2, Email: "b@test", Handle: "b", Name: "partial"}, stored)
+}
+
+type tenantItem struct {
+	Tenant int64 `ormlite:"primary"`
+	Seq    int64 `ormlite:"primary,autoincrement"`
+	Name   string
+}
+
+func (*tenantItem) Table() string { return "tenant_items" }
+
+type invalidAutoincrementModel struct {
+	Code string `ormlite:"primary,autoincrement"`
+}
+
+func (*invalidAutoincrementModel) Table() string { return "tenant_items" }
+
+func TestInsertCompoundKeyWithAutoincrement(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(`create table tenant_items(seq integer primary key, tenant int not null default 0, name text)`)
+	require.NoError(t, err)
+
+	first := tenantItem{Tenant: 7, Name: "first"}
+	require.NoError(t, Insert(db, &first))
+	assert.Equal(t, tenantItem{Tenant: 7, Seq: 1, Name: "first"}, first)
+
+	// zero tenant is a valid part of the key, so generated id isn't assigned to it
+	second := tenantItem{Name: "second"}
+	require.NoError(t, Insert(db, &second))
+	assert.Equal(t, tenantItem{Tenant: 0, Seq: 2, Name: "second"}, second)
+
+	var stored tenantItem
+	require.NoError(t, QueryStruct(db, &Options{Where: Where{"seq": 2}}, &stored))
+	assert.Equal(t, second, stored)
+
+	assert.Error(t, Insert(db, &invalidAutoincrementModel{}))
+}
+
+func TestAddRemoveRelation(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(`
+		create table related_model (field text);
+		create table mtm_model (name text);
+		create table mtm (m_id int, rel_id int);
+		create table mtm_with_condition (m_id int, rel_id int, value boolean not null);
+
+		insert into related_model (field) values ('test 1'), ('test 2'), ('test 3'), ('test 4');
+		insert into mtm_model (name) values ('first'), ('second');
+		insert into mtm (m_id, rel_id) values (1, 1), (1, 2), (2, 1);
+		insert into mtm_with_condition (m_id, rel_id, value) values (1, 1, 1);
+	`)
+	require.NoError(t, err)
+
+	related := func(db Executor, id int64) (ids []int64) {
+		var m modelManyToMany
+		require.NoError(t, QueryStruct(db, &Options{Where: Where{"rowid": id}, RelationDepth: 1}, &m))
+		for _, r := range m.Related {
+			ids = append(ids, r.ID)
+		}
+		return ids
+	}
+
+	parent := &modelManyToMany{ID: 1}
+	// stored edge is kept as is
+	require.NoError(t, AddRelation(db, parent, "Related", &relatedModel{ID: 3}, &relatedModel{ID: 2}))
+	assert.Equal(t, []int64{1, 2, 3}, related(db, 1))
+	assert.Equal(t, []int64{1}, related(db, 2))
+
+	// stored edges are read by the transaction adding new ones
+	txRec := &txRecorder{queryRecorder: queryRecorder{Executor: db}, db: db}
+	require.NoError(t, AddRelation(txRec, parent, "Related", &relatedModel{ID: 4}, &relatedModel{ID: 3}))
+	assert.Empty(t, txRec.queries)
+	assert.Equal(t, []int64{1, 2, 3, 4}, related(db, 1))
+
+	rec := &queryRecorder{Executor: db}
+	require.NoError(t, RemoveRelation(rec, parent, "related", &relatedModel{ID: 1}, &relatedModel{ID: 4}))
+	assert.Len(t, rec.queries, 1)
+	assert.Equal(t, []int64{2, 3}, related(db, 1))
+	assert.Equal(t, []int64{1}, related(db, 2))
+
+	// edges are written with condition value
+	withCondition := &modelManyToManyWithCondition{ID: 1}
+	require.NoError(t, AddRelation(db, withCondition, "RelatedFalse", &relatedModel{ID: 1}, &relatedModel{ID: 4}))
+	require.NoError(t, RemoveRelation(db, withCondition, "RelatedTrue", &relatedModel{ID: 1}))
+	var m modelManyToManyWithCondition
+	require.NoError(t, QueryStruct(db, &Options{Where: Where{"rowid": 1}, RelationDepth: 1}, &m))
+	assert.Len(t, m.RelatedFalse, 2)
+	assert.Empty(t, m.RelatedTrue)
+
+	assert.Error(t, AddRelation(db, parent, "Name", &relatedModel{ID: 1}))
+	assert.Error(t, AddRelation(db, parent, "Related", &relatedModel{}))
+	assert.Error(t, AddRelation(db, parent, "Related", &simpleModel{ID: 1}))
+	assert.Error(t, RemoveRelation(db, &modelManyToMany{}, "Related", &relatedModel{ID: 1}))
+}
+
+// txRecorder records queries made outside of transactions it begins
+type txRecorder struct {
+	queryRecorder
+	db *sql.DB
+}
+
+func (r *txRecorder) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	return r.db.BeginTx(ctx, opts)
+}
+
+type accessControlledModel struct {
+	ID       int64 `ormlite:"primary"`
+	Name     string
+	Computed string `ormlite:"readonly"`
+	Secret   string `ormlite:"writeonly"`
+}
+
+func (*accessControlledModel) Table() string { return "access_controlled" }
+
+type conflictingAccessModel struct {
+	ID   int64  `ormlite:"primary"`
+	Name string `ormlite:"readonly,writeonly"`
+}
+
+func (*conflictingAccessModel) Table() string { return "access_controlled" }
+
+func TestReadonlyWriteonlyFields(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(`
+		create table access_controlled(id integer primary key, name text, computed text default 'computed', secret text);
+		create trigger access_controlled_computed after update of name on access_controlled begin
+			update access_controlled set computed = 'computed ' || new.name where id = new.id;
+		end;
+	`)
+	require.NoError(t, err)
+
+	m := accessControlledModel{Name: "first", Computed: "ignored", Secret: "hash"}
+	require.NoError(t, Insert(db, &m))
+
+	var stored accessControlledModel
+	require.NoError(t, QueryStruct(db, &Options{Where: Where{"id": m.ID}}, &stored))
+	assert.Equal(t, accessControlledModel{ID: m.ID, Name: "first", Computed: "computed"}, stored)
+
+	var secret string
+	require.NoError(t, db.QueryRow("select secret from access_controlled where id = ?", m.ID).Scan(&secret))
+	assert.Equal(t, "hash", secret)
+
+	m.Name, m.Computed, m.Secret = "second", "ignored again", "new hash"
+	require.NoError(t, Update(db, &m))
+	var mm []*accessControlledModel
+	require.NoError(t, QuerySlice(db, nil, &mm))
+	if assert.Len(t, mm, 1) {
+		assert.Equal(t, accessControlledModel{ID: m.ID, Name: "second", Computed: "computed second"}, *mm[0])
+	}
+	require.NoError(t, db.QueryRow("select secret from access_controlled where id = ?", m.ID).Scan(&secret))
+	assert.Equal(t, "new hash", secret)
+
+	// changes of readonly fields alone don't issue updates
+	require.NoError(t, Track(mm[0]))
+	mm[0].Computed = "changed"
+	rec := &queryRecorder{Executor: db}
+	require.NoError(t, UpdateChanged(rec, mm[0]))
+	assert.Empty(t, rec.queries)
+	Untrack(mm[0])
+
+	assert.Error(t, Insert(db, &conflictingAccessModel{Name: "x"}))
+}
+
+type pageCounter struct {
+	ID    int64  `ormlite:"primary"`
+	Page  string `ormlite:"unique"`
+	Count int    `ormlite:"on_conflict=count + excluded.count"`
+}
+
+func (*pageCounter) Table() string { return "page_counter" }
+
+func TestUpsertConflictExpression(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec("create table page_counter(id integer primary key, page text unique, count integer)")
+	require.NoError(t, err)
+
+	for _, n := range []int{1, 2, 3} {
+		require.NoError(t, Upsert(db, &pageCounter{Page: "index", Count: n}))
+	}
+	require.NoError(t, Upsert(db, &pageCounter{Page: "about", Count: 5}))
+
+	var counters []*pageCounter
+	require.NoError(t, QuerySlice(db, &Options{OrderBy: &OrderBy{Field: "id"}}, &counters))
+	if assert.Len(t, counters, 2) {
+		assert.Equal(t, pageCounter{ID: 1, Page: "index", Count: 6}, *counters[0])
+		assert.Equal(t, pageCounter{ID: 2, Page: "about", Count: 5}, *counters[1])
+	}
+
+	// partial upsert uses the expression for listed columns only
+	require.NoError(t, UpsertPartial(db, &pageCounter{Page: "about", Count: 10}, "page"))
+	var c pageCounter
+	require.NoError(t, QueryStruct(db, &Options{Where: Where{"page": "about"}}, &c))
+	assert.Equal(t, 5, c.Count)
+}
+
+type pageMaximum struct {
+	ID    int64  `ormlite:"primary"`
+	Page  string `ormlite:"unique"`
+	Count int    `ormlite:"on_conflict=max(count, excluded.count),notnull"`
+}
+
+func (*pageMaximum) Table() string { return "page_counter" }
+
+func TestUpsertConflictExpressionWithComma(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec("create table page_counter(id integer primary key, page text unique, count integer)")
+	require.NoError(t, err)
+
+	for _, n := range []int{3, 7, 5} {
+		require.NoError(t, Upsert(db, &pageMaximum{Page: "index", Count: n}))
+	}
+	var m pageMaximum
+	require.NoError(t, QueryStruct(db, &Options{Where: Where{"page": "index"}}, &m))
+	assert.Equal(t, 7, m.Count)
+
+	// settings following the expression are kept
+	assert.True(t, IsFieldRequired(Upsert(db, &pageMaximum{Page: "about"})))
+}
+
+func TestInsertWithProvidedPk(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(`
+		create table simple_model(id integer primary key, not_tagged_field text, tagged_field text);
+		insert into simple_model(not_tagged_field) values ('first');
+	`)
+	require.NoError(t, err)
+
+	rec := &queryRecorder{Executor: db}
+	m := simpleModel{ID: 10, NotTaggedField: "provided"}
+	require.NoError(t, Insert(rec, &m))
+	assert.EqualValues(t, 10, m.ID)
+	assert.Len(t, rec.queries, 1, "no search query is expected")
+
+	rec.queries = nil
+	m = simpleModel{ID: 1, NotTaggedField: "updated"}
+	require.NoError(t, Upsert(rec, &m))
+	assert.EqualValues(t, 1, m.ID)
+	assert.Len(t, rec.queries, 1)
+
+	var mm []*simpleModel
+	require.NoError(t, QuerySlice(db, &Options{OrderBy: &OrderBy{Field: "id"}}, &mm))
+	if assert.Len(t, mm, 2) {
+		assert.Equal(t, simpleModel{ID: 1, NotTaggedField: "updated"}, *mm[0])
+		assert.Equal(t, simpleModel{ID: 10, NotTaggedField: "provided"}, *mm[1])
+	}
+}
@@ -0,0 +1,478 @@
+package ormlite
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Returns sql expressions of expression columns keyed by their aliases,
+// e.g. "(a + b) as total" gives {"total": "(a + b)"}
+func expressionAliases(columns []string) map[string]string {
+	var aliases = make(map[string]string)
+	for _, col := range columns {
+		// expressions with arguments can't be inlined to other clauses
+		if strings.Contains(col, "?") {
+			continue
+		}
+		if i := strings.LastIndex(strings.ToLower(col), " as "); i != -1 {
+			aliases[strings.TrimSpace(col[i+4:])] = strings.TrimSpace(col[:i])
+		}
+	}
+	return aliases
+}
+
+// Returns sql of expression column without alias
+func expressionSQL(exp Expression) string {
+	for _, sql := range expressionAliases([]string{exp.Column()}) {
+		return sql
+	}
+	return exp.Column()
+}
+
+// Renders where conditions from options, keys which are aliases of expression
+// columns are replaced with their expressions, so they can be used in queries
+// that don't select them (e.g. Count)
+func buildWhereConditions(opts *Options, aliases map[string]string) ([]string, []interface{}) {
+	if opts == nil {
+		return nil, nil
+	}
+	return buildConditions(opts.Where, opts, aliases)
+}
+
+// Renders having conditions from options, aliases of selected columns can be
+// used as keys as is
+func buildHavingConditions(opts *Options) ([]string, []interface{}) {
+	if opts == nil {
+		return nil, nil
+	}
+	return buildConditions(opts.Having, opts, nil)
+}
+
+// Escapes wildcards of like operator, so user input is matched literally
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// Formats time operand according to time storage mode of the column,
+// other operands are returned as is
+func timeOperand(v interface{}, column string, opts *Options) interface{} {
+	t, ok := v.(time.Time)
+	if !ok || opts == nil {
+		return v
+	}
+	if i := strings.LastIndex(column, "."); i != -1 {
+		column = column[i+1:]
+	}
+	return formatTime(t, opts.timeStorage[column])
+}
+
+// Returns sql of referenced column, aliases of expression columns are
+// replaced with their expressions
+func columnOperand(col Col, aliases map[string]string) string {
+	if exp, ok := aliases[string(col)]; ok {
+		return exp
+	}
+	return string(col)
+}
+
+// Returns columns of the model which can be referenced by conditions, plain
+// and qualified with table name, expression columns are referenced by alias
+func conditionColumns(info *modelInfo) map[string]bool {
+	var columns = make(map[string]bool)
+	for _, f := range info.fields {
+		if isOmittedField(f) || isReferenceField(f) && !isHasOne(f) {
+			continue
+		}
+		if isExpressionField(f) {
+			for alias := range expressionAliases([]string{f.value.Interface().(Expression).Column()}) {
+				columns[alias] = true
+			}
+			continue
+		}
+		columns[f.column] = true
+		columns[info.table+"."+f.column] = true
+	}
+	return columns
+}
+
+// Checks that keys of where conditions, orderings and selected columns are
+// columns of the model, so misspelled ones are reported before the query is
+// executed
+func validateStrictColumns(info *modelInfo, opts *Options) error {
+	var (
+		columns = conditionColumns(info)
+		check   = func(kind, column string) error {
+			if !columns[column] {
+				return errors.Errorf("model %s does not have %s column %s", info.table, kind, column)
+			}
+			return nil
+		}
+	)
+	for k := range opts.Where {
+		if column, _, ok := parseJSONPathKey(k); ok {
+			if err := check("where", column); err != nil {
+				return err
+			}
+			continue
+		}
+		// compound keys are listed separated by comma
+		for _, c := range strings.Split(k, ",") {
+			if err := check("where", strings.TrimSpace(c)); err != nil {
+				return err
+			}
+		}
+	}
+	if opts.OrderBy != nil {
+		if err := check("order", opts.OrderBy.Field); err != nil {
+			return err
+		}
+	}
+	for _, o := range opts.ThenBy {
+		if err := check("order", o.Field); err != nil {
+			return err
+		}
+	}
+	if len(opts.Columns) == 0 {
+		return nil
+	}
+	// relation fields and expression columns are selected by their names
+	colInfo, err := getColumnInfo(info.value.Type())
+	if err != nil {
+		return err
+	}
+	for _, ci := range colInfo {
+		columns[ci.Name] = true
+	}
+	for c := range opts.Columns {
+		if err := check("selected", c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Checks that both sides of column to column conditions are columns of the
+// model, since they are rendered into query as is
+func validateColumnConditions(info *modelInfo, where Where) error {
+	var columns = conditionColumns(info)
+
+	var check func(k string, v interface{}) error
+	check = func(k string, v interface{}) error {
+		var (
+			col Col
+			ok  bool
+		)
+		switch op := v.(type) {
+		case Col:
+			col = op
+		case Comparison:
+			if col, ok = op.operand.(Col); !ok {
+				return nil
+			}
+		case OrGroup:
+			for _, gv := range op {
+				if err := check(k, gv); err != nil {
+					return err
+				}
+			}
+			return nil
+		case InTuples:
+			keyColumns := len(strings.Split(k, ","))
+			for i, t := range op {
+				if len(t) != keyColumns {
+					return errors.Errorf("tuple %d of condition %s has %d values, expected %d", i, k, len(t), keyColumns)
+				}
+			}
+			return nil
+		default:
+			return nil
+		}
+		for _, c := range []string{k, string(col)} {
+			if !columns[c] {
+				return errors.Errorf("model %s does not have column %s", info.table, c)
+			}
+		}
+		return nil
+	}
+
+	for k, v := range where {
+		if err := validateJSONPathKey(columns, k); err != nil {
+			return errors.Wrapf(err, "model %s", info.table)
+		}
+		if err := check(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Checks that columns referenced by having conditions are columns of the
+// model or aliases of its expression columns, keys are aggregate expressions
+// and can't be checked
+func validateHavingColumns(info *modelInfo, having Where) error {
+	var columns = conditionColumns(info)
+
+	var check func(v interface{}) error
+	check = func(v interface{}) error {
+		var (
+			col Col
+			ok  bool
+		)
+		switch op := v.(type) {
+		case Col:
+			col = op
+		case Comparison:
+			if col, ok = op.operand.(Col); !ok {
+				return nil
+			}
+		case OrGroup:
+			for _, gv := range op {
+				if err := check(gv); err != nil {
+					return err
+				}
+			}
+			return nil
+		default:
+			return nil
+		}
+		if !columns[string(col)] {
+			return errors.Errorf("model %s does not have column %s", info.table, col)
+		}
+		return nil
+	}
+
+	for _, v := range having {
+		if err := check(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var (
+	jsonPathPattern    = regexp.MustCompile(`^\$(\.[A-Za-z_][A-Za-z0-9_]*|\[[0-9]+\])*$`)
+	jsonPathKeyPattern = regexp.MustCompile(`^json_extract\(([A-Za-z_][A-Za-z0-9_.]*), '(.*)'\)$`)
+)
+
+// Returns column and path of where key made by JSONPath
+func parseJSONPathKey(k string) (column, path string, ok bool) {
+	m := jsonPathKeyPattern.FindStringSubmatch(k)
+	if m == nil {
+		return "", "", false
+	}
+	return m[1], m[2], true
+}
+
+// Checks that JSON path key refers a column of the model and has valid path,
+// since it's rendered into query as is, other keys are skipped
+func validateJSONPathKey(columns map[string]bool, k string) error {
+	if !strings.HasPrefix(k, "json_extract(") {
+		return nil
+	}
+	column, path, ok := parseJSONPathKey(k)
+	if !ok || !jsonPathPattern.MatchString(path) {
+		return errors.Errorf("invalid json path condition: %s", k)
+	}
+	if !columns[column] {
+		return errors.Errorf("does not have column %s", column)
+	}
+	return nil
+}
+
+// ByExample returns conditions matching models having the same values as
+// non-zero fields of given model, relations, expressions and fields stored
+// as JSON are skipped, strings are compared strictly. Invalid model gives nil
+// conditions
+func ByExample(m Model) Where {
+	where, _ := byExample(m)
+	return where
+}
+
+// Returns conditions of ByExample with error of invalid model
+func byExample(m Model) (Where, error) {
+	info, err := getModelInfo(m)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get model info")
+	}
+	var where = make(Where)
+	for _, f := range info.fields {
+		if isOmittedField(f) || isReferenceField(f) || isExpressionField(f) ||
+			isJSONType(f.value.Type()) || isZeroField(f.value) {
+			continue
+		}
+		value := f.value
+		if value.Kind() == reflect.Ptr {
+			value = value.Elem()
+		}
+		_, valuer := value.Interface().(driver.Valuer)
+		switch {
+		case valuer:
+			where[f.column] = value.Interface()
+		case value.Kind() == reflect.String:
+			where[f.column] = StrictString(value.String())
+		case value.Kind() == reflect.Slice:
+			// e.g. []byte, slice values would be matched by in operator
+			continue
+		default:
+			where[f.column] = value.Interface()
+		}
+	}
+	return where, nil
+}
+
+// Returns key with columns of queried model qualified with its table if
+// options require it, columns of compound keys are qualified separately
+func qualifyKey(opts *Options, key string) string {
+	if opts == nil || len(opts.qualified) == 0 {
+		return key
+	}
+	parts := strings.Split(key, ",")
+	for i, p := range parts {
+		if q, ok := opts.qualified[strings.TrimSpace(p)]; ok {
+			parts[i] = q
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// Returns glue between where conditions, AND is used when divider is not set
+func whereDivider(opts *Options) string {
+	if opts == nil || opts.Divider == "" {
+		return AND
+	}
+	return opts.Divider
+}
+
+func buildConditions(where Where, opts *Options, aliases map[string]string) ([]string, []interface{}) {
+	var (
+		keys []string
+		args []interface{}
+	)
+	for k, v := range where {
+		if exp, ok := aliases[k]; ok {
+			k = exp
+		} else {
+			k = qualifyKey(opts, k)
+		}
+		if opt, ok := v.(OptionalValue); ok {
+			if v, ok = opt.value(); !ok {
+				continue
+			}
+		}
+		if v == nil {
+			keys = append(keys, fmt.Sprintf("%s is null", k))
+			continue
+		}
+		switch op := v.(type) {
+		case time.Time:
+			keys = append(keys, fmt.Sprintf("%s = ?", k))
+			args = append(args, timeOperand(op, k, opts))
+			continue
+		case After:
+			keys = append(keys, fmt.Sprintf("%s > ?", k))
+			args = append(args, timeOperand(time.Time(op), k, opts))
+			continue
+		case Before:
+			keys = append(keys, fmt.Sprintf("%s < ?", k))
+			args = append(args, timeOperand(time.Time(op), k, opts))
+			continue
+		case Between:
+			keys = append(keys, fmt.Sprintf("%s between ? and ?", k))
+			args = append(args, timeOperand(op.From, k, opts), timeOperand(op.To, k, opts))
+			continue
+		case NullSafe:
+			keys = append(keys, fmt.Sprintf("%s is ?", k))
+			args = append(args, timeOperand(op.Value, k, opts))
+			continue
+		case Col:
+			keys = append(keys, fmt.Sprintf("%s = %s", k, columnOperand(op, aliases)))
+			continue
+		case Comparison:
+			if col, ok := op.operand.(Col); ok {
+				keys = append(keys, fmt.Sprintf("%s %s %s", k, op.operator, columnOperand(col, aliases)))
+				continue
+			}
+			keys = append(keys, fmt.Sprintf("%s %s ?", k, op.operator))
+			args = append(args, timeOperand(op.operand, k, opts))
+			continue
+		case SubQuery:
+			keys = append(keys, fmt.Sprintf("%s in (%s)", k, op.SQL))
+			args = append(args, op.Args...)
+			continue
+		}
+		if group, ok := v.(OrGroup); ok {
+			if len(group) == 0 {
+				keys = append(keys, "0") // empty group matches no rows
+				continue
+			}
+			var conditions []string
+			for _, gv := range group {
+				gk, ga := buildConditions(Where{k: gv}, opts, nil)
+				conditions = append(conditions, gk...)
+				args = append(args, ga...)
+			}
+			keys = append(keys, fmt.Sprintf("(%s)", strings.Join(conditions, OR)))
+			continue
+		}
+		if tuples, ok := v.(InTuples); ok {
+			if len(tuples) == 0 {
+				keys = append(keys, "0") // empty set matches no rows
+				continue
+			}
+			rows := make([]string, len(tuples))
+			for i, t := range tuples {
+				rows[i] = fmt.Sprintf("(%s)", strings.Trim(strings.Repeat("?,", len(t)), ","))
+				args = append(args, t...)
+			}
+			// sqlite requires subquery on the right side of row value in operator
+			keys = append(keys, fmt.Sprintf("(%s) in (values %s)", k, strings.Join(rows, ",")))
+			continue
+		}
+		value := reflect.ValueOf(v)
+		switch value.Kind() {
+		case reflect.Slice:
+			if _, _, json := parseJSONPathKey(k); strings.Contains(k, ",") && !json {
+				// rows of compound key values are grouped to be independent of divider
+				var (
+					rowValueCount = len(strings.Split(k, ","))
+					rows          []string
+				)
+				for i := 0; i < value.Len()/rowValueCount; i++ {
+					rows = append(rows, fmt.Sprintf("(%s) = (%s)", k, strings.Trim(strings.Repeat("?,", rowValueCount), ",")))
+				}
+				keys = append(keys, fmt.Sprintf("(%s)", strings.Join(rows, OR)))
+			} else {
+				// all values are bound, limit caps only number of returned rows
+				keys = append(keys, fmt.Sprintf("%s in (%s)", k, strings.Trim(strings.Repeat("?,", value.Len()), ",")))
+			}
+			for i := 0; i < value.Len(); i++ {
+				args = append(args, value.Index(i).Interface())
+			}
+		case reflect.String:
+			switch v.(type) {
+			case StrictString:
+				keys = append(keys, fmt.Sprintf("%s = ?", k))
+				args = append(args, v)
+			default:
+				keys = append(keys, fmt.Sprintf("%s like ? escape '\\'", k))
+				args = append(args, fmt.Sprintf("%%%s%%", likeEscaper.Replace(value.String())))
+			}
+		default:
+			switch v.(type) {
+			case BitwiseAND:
+				keys = append(keys, fmt.Sprintf("%s&? > 0", k))
+			case BitwiseANDStrict:
+				keys = append(keys, fmt.Sprintf("%s&? = ?", k))
+				args = append(args, v)
+			default:
+				keys = append(keys, fmt.Sprintf("%s = ?", k))
+			}
+			args = append(args, v)
+		}
+	}
+	return keys, args
+}